@@ -5,12 +5,14 @@ go 1.26.5
 require (
 	github.com/canonical/k8s-snap-api v1.1.0
 	github.com/kairos-io/kairos-sdk v0.7.2
+	github.com/klauspost/compress v1.18.0
 	github.com/mudler/go-pluggable v0.0.0-20230126220627-7710299a0ae5
 	github.com/mudler/yip v1.16.0
 	github.com/onsi/gomega v1.38.2
 	github.com/pkg/errors v0.9.1
 	github.com/sirupsen/logrus v1.9.4-0.20230606125235-dd1b4c2e81af
 	github.com/twpayne/go-vfs/v4 v4.3.0
+	github.com/ulikunitz/xz v0.5.11
 	gopkg.in/natefinch/lumberjack.v2 v2.2.1
 	gopkg.in/yaml.v3 v3.0.1
 )
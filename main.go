@@ -1,6 +1,11 @@
 package main
 
 import (
+	"fmt"
+	"os"
+
+	"github.com/kairos-io/provider-canonical/pkg/cli"
+	"github.com/kairos-io/provider-canonical/pkg/domain"
 	"github.com/kairos-io/provider-canonical/pkg/provider"
 
 	"github.com/kairos-io/provider-canonical/pkg/log"
@@ -11,7 +16,20 @@ import (
 )
 
 func main() {
-	log.InitLogger("/var/log/provider-canonical.log")
+	log.InitLogger(log.LoadConfig(domain.LogConfigPath))
+
+	if len(os.Args) > 1 {
+		if command, ok := cli.Commands[os.Args[1]]; ok {
+			logrus.Infof("running provider-canonical %s", os.Args[1])
+			if err := command(os.Args[2:]); err != nil {
+				logrus.Error(err)
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	logrus.Info("starting provider-canonical")
 	plugin := clusterplugin.ClusterPlugin{
 		Provider: provider.ClusterProvider,
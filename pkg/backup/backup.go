@@ -0,0 +1,224 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kairos-io/provider-canonical/pkg/domain"
+	"github.com/kairos-io/provider-canonical/pkg/utils"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/twpayne/go-vfs/v4"
+)
+
+const (
+	archivePrefix  = "canonical-backup-"
+	archiveSuffix  = ".tar.gz"
+	checksumSuffix = ".sha256"
+	partialSuffix  = ".partial"
+
+	timestampFormat = "20060102T150405Z"
+)
+
+// Sources returns the directories backed up along with the datastore snapshot: the
+// k8sd state, the kubernetes PKI and the kube components args.
+func Sources(root vfs.FS) []string {
+	var sources []string
+	for _, path := range []string{domain.K8sdStatePath, domain.KubeCertificateDirPath, domain.KubeComponentsArgsPath} {
+		if utils.DirExists(root, path) {
+			sources = append(sources, path)
+		}
+	}
+	return sources
+}
+
+// Create writes a gzip compressed tar archive of a datastore snapshot and the
+// backup sources into dir, along with a sha256sum compatible checksum file, and
+// returns the archive path.
+func Create(root vfs.FS, dir string, now time.Time, runner Runner) (string, error) {
+	if err := vfs.MkdirAll(root, dir, 0700); err != nil {
+		return "", errors.Wrap(err, "failed to create backup dir")
+	}
+
+	name := archivePrefix + now.UTC().Format(timestampFormat) + archiveSuffix
+	archivePath := filepath.Join(dir, name)
+	partialPath := archivePath + partialSuffix
+
+	snap, err := takeSnapshot(root, runner)
+	if err != nil {
+		return "", err
+	}
+	checksum, err := writeArchive(root, partialPath, append([]string{snap.dir}, Sources(root)...))
+	if err = stderrors.Join(err, snap.release()); err != nil {
+		_ = root.Remove(partialPath)
+		return "", err
+	}
+
+	if err = root.Rename(partialPath, archivePath); err != nil {
+		return "", errors.Wrap(err, "failed to rename backup archive")
+	}
+
+	content := fmt.Sprintf("%s  %s\n", checksum, name)
+	if err = root.WriteFile(archivePath+checksumSuffix, []byte(content), 0600); err != nil {
+		return "", errors.Wrap(err, "failed to write backup checksum")
+	}
+	return archivePath, nil
+}
+
+func writeArchive(root vfs.FS, path string, sources []string) (string, error) {
+	f, err := root.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create backup archive")
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	gz := gzip.NewWriter(io.MultiWriter(f, hash))
+	tw := tar.NewWriter(gz)
+
+	for _, source := range sources {
+		if err = addTree(root, tw, source); err != nil {
+			return "", errors.Wrapf(err, "failed to archive %s", source)
+		}
+	}
+
+	if err = tw.Close(); err != nil {
+		return "", err
+	}
+	if err = gz.Close(); err != nil {
+		return "", err
+	}
+	if err = f.Sync(); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func addTree(root vfs.FS, tw *tar.Writer, source string) error {
+	return vfs.Walk(root, source, func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		var link string
+		switch mode := info.Mode(); {
+		case mode.IsDir(), mode.IsRegular():
+		case mode&fs.ModeSymlink != 0:
+			if link, err = root.Readlink(path); err != nil {
+				return err
+			}
+		default:
+			// sockets and other special files (e.g. the k8s-dqlite socket) are runtime only
+			logrus.Debugf("skipping special file %s", path)
+			return nil
+		}
+
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = strings.TrimPrefix(filepath.ToSlash(path), "/")
+		if info.IsDir() {
+			header.Name += "/"
+		}
+
+		if err = tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		file, err := root.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		_, err = io.CopyN(tw, file, header.Size)
+		return err
+	})
+}
+
+// Verify checks the archive against the checksum file written next to it by Create.
+func Verify(root vfs.FS, archive string) error {
+	content, err := root.ReadFile(archive + checksumSuffix)
+	if err != nil {
+		return errors.Wrap(err, "failed to read backup checksum")
+	}
+	fields := strings.Fields(string(content))
+	if len(fields) == 0 {
+		return errors.Errorf("empty backup checksum file %s", archive+checksumSuffix)
+	}
+
+	f, err := root.Open(archive)
+	if err != nil {
+		return errors.Wrap(err, "failed to open backup archive")
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err = io.Copy(hash, f); err != nil {
+		return errors.Wrap(err, "failed to read backup archive")
+	}
+
+	if actual := hex.EncodeToString(hash.Sum(nil)); actual != fields[0] {
+		return errors.Errorf("backup archive checksum mismatch: expected %s, got %s", fields[0], actual)
+	}
+	return nil
+}
+
+// List returns the backup archives in dir, oldest first.
+func List(root vfs.FS, dir string) ([]string, error) {
+	archives, err := root.Glob(filepath.Join(dir, archivePrefix+"*"+archiveSuffix))
+	if err != nil {
+		return nil, err
+	}
+	// the timestamp in the name sorts chronologically
+	sort.Strings(archives)
+	return archives, nil
+}
+
+// Prune removes all but the newest retention archives from dir, along with their
+// checksum files and any partial archives left behind by an interrupted backup.
+func Prune(root vfs.FS, dir string, retention int) ([]string, error) {
+	partials, err := root.Glob(filepath.Join(dir, archivePrefix+"*"+partialSuffix))
+	if err != nil {
+		return nil, err
+	}
+	for _, partial := range partials {
+		if err = root.Remove(partial); err != nil {
+			return nil, errors.Wrapf(err, "failed to remove partial backup %s", partial)
+		}
+	}
+
+	archives, err := List(root, dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(archives) <= retention {
+		return nil, nil
+	}
+
+	pruned := archives[:len(archives)-retention]
+	for _, archive := range pruned {
+		if err = root.Remove(archive); err != nil {
+			return nil, errors.Wrapf(err, "failed to remove backup %s", archive)
+		}
+		if err = root.Remove(archive + checksumSuffix); err != nil && !os.IsNotExist(err) {
+			return nil, errors.Wrapf(err, "failed to remove backup checksum %s", archive+checksumSuffix)
+		}
+	}
+	return pruned, nil
+}
@@ -0,0 +1,111 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kairos-io/provider-canonical/pkg/domain"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4/vfst"
+)
+
+func TestCreate(t *testing.T) {
+	g := NewWithT(t)
+
+	t.Run("archives datastore, pki and args with a checksum", func(t *testing.T) {
+		testFS, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+			filepath.Join(domain.K8sDqliteDataPath, "0000000000000001-0000000000000002"): "segment",
+			filepath.Join(domain.KubeCertificateDirPath, "ca.crt"):                       "ca",
+			filepath.Join(domain.KubeComponentsArgsPath, "kubelet"):                      "--node-ip=10.0.0.1",
+		})
+		g.Expect(err).NotTo(HaveOccurred())
+		defer cleanup()
+
+		now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+		archive, err := Create(testFS, "/backups", now, stoppedDatastore)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(archive).To(Equal("/backups/canonical-backup-20260102T030405Z.tar.gz"))
+
+		content, err := testFS.ReadFile(archive)
+		g.Expect(err).NotTo(HaveOccurred())
+		sum := sha256.Sum256(content)
+
+		checksum, err := testFS.ReadFile(archive + checksumSuffix)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(string(checksum)).To(Equal(hex.EncodeToString(sum[:]) + "  canonical-backup-20260102T030405Z.tar.gz\n"))
+
+		gz, err := gzip.NewReader(strings.NewReader(string(content)))
+		g.Expect(err).NotTo(HaveOccurred())
+		tr := tar.NewReader(gz)
+
+		files := map[string]string{}
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			g.Expect(err).NotTo(HaveOccurred())
+			if header.Typeflag == tar.TypeReg {
+				data, err := io.ReadAll(tr)
+				g.Expect(err).NotTo(HaveOccurred())
+				files[header.Name] = string(data)
+			}
+		}
+
+		g.Expect(files).To(HaveKeyWithValue("var/snap/k8s/common/var/lib/k8s-dqlite/0000000000000001-0000000000000002", "segment"))
+		g.Expect(files).To(HaveKeyWithValue("etc/kubernetes/pki/ca.crt", "ca"))
+		g.Expect(files).To(HaveKeyWithValue("var/snap/k8s/common/args/kubelet", "--node-ip=10.0.0.1"))
+	})
+
+	t.Run("fails when there is no datastore", func(t *testing.T) {
+		testFS, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+			filepath.Join(domain.KubeCertificateDirPath, "ca.crt"): "ca",
+		})
+		g.Expect(err).NotTo(HaveOccurred())
+		defer cleanup()
+
+		_, err = Create(testFS, "/backups", time.Now(), stoppedDatastore)
+		g.Expect(err).To(MatchError(ContainSubstring("no k8s-dqlite or etcd datastore found")))
+	})
+}
+
+func TestPrune(t *testing.T) {
+	g := NewWithT(t)
+
+	t.Run("keeps the newest archives and removes the rest", func(t *testing.T) {
+		testFS, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+			"/backups/canonical-backup-20260101T000000Z.tar.gz":         "1",
+			"/backups/canonical-backup-20260101T000000Z.tar.gz.sha256":  "1",
+			"/backups/canonical-backup-20260102T000000Z.tar.gz":         "2",
+			"/backups/canonical-backup-20260102T000000Z.tar.gz.sha256":  "2",
+			"/backups/canonical-backup-20260103T000000Z.tar.gz":         "3",
+			"/backups/canonical-backup-20260103T000000Z.tar.gz.sha256":  "3",
+			"/backups/canonical-backup-20260104T000000Z.tar.gz.partial": "4",
+		})
+		g.Expect(err).NotTo(HaveOccurred())
+		defer cleanup()
+
+		pruned, err := Prune(testFS, "/backups", 2)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(pruned).To(Equal([]string{"/backups/canonical-backup-20260101T000000Z.tar.gz"}))
+
+		archives, err := List(testFS, "/backups")
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(archives).To(Equal([]string{
+			"/backups/canonical-backup-20260102T000000Z.tar.gz",
+			"/backups/canonical-backup-20260103T000000Z.tar.gz",
+		}))
+
+		_, err = testFS.Stat("/backups/canonical-backup-20260101T000000Z.tar.gz.sha256")
+		g.Expect(err).To(HaveOccurred())
+		_, err = testFS.Stat("/backups/canonical-backup-20260104T000000Z.tar.gz.partial")
+		g.Expect(err).To(HaveOccurred())
+	})
+}
@@ -0,0 +1,168 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/kairos-io/provider-canonical/pkg/domain"
+	"github.com/kairos-io/provider-canonical/pkg/utils"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/twpayne/go-vfs/v4"
+)
+
+const (
+	k8sDqliteService = "k8s.k8s-dqlite"
+	etcdSnapshotFile = "snapshot.db"
+)
+
+// Runner runs a command on the host and returns its combined output.
+type Runner func(name string, args ...string) ([]byte, error)
+
+// ExecRunner runs the commands as processes of the host.
+func ExecRunner(name string, args ...string) ([]byte, error) {
+	return exec.Command(name, args...).CombinedOutput()
+}
+
+func run(runner Runner, name string, args ...string) error {
+	if output, err := runner(name, args...); err != nil {
+		return errors.Wrapf(err, "%s %s failed: %s", name, strings.Join(args, " "), strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// snapshot is a consistent copy of the datastore, which stays valid until release
+// is called.
+type snapshot struct {
+	dir     string
+	release func() error
+}
+
+// takeSnapshot snapshots etcd through etcdctl, while the k8s-dqlite data dir is
+// copied with k8s-dqlite stopped, as it has no online backup. The datastore of a
+// single control plane node is unavailable for the duration of the copy.
+func takeSnapshot(root vfs.FS, runner Runner) (snapshot, error) {
+	switch {
+	case utils.DirExists(root, domain.EtcdDataPath):
+		return takeEtcdSnapshot(root, runner)
+	case utils.DirExists(root, domain.K8sDqliteDataPath):
+		return stopK8sDqlite(runner)
+	default:
+		return snapshot{}, errors.New("no k8s-dqlite or etcd datastore found")
+	}
+}
+
+func takeEtcdSnapshot(root vfs.FS, runner Runner) (snapshot, error) {
+	args, err := readArgs(root, "kube-apiserver")
+	if err != nil {
+		return snapshot{}, err
+	}
+	servers := strings.Split(args["--etcd-servers"], ",")
+	if servers[0] == "" {
+		return snapshot{}, errors.New("kube-apiserver args have no etcd servers")
+	}
+
+	if err = root.RemoveAll(domain.EtcdSnapshotDir); err != nil {
+		return snapshot{}, err
+	}
+	if err = vfs.MkdirAll(root, domain.EtcdSnapshotDir, 0700); err != nil {
+		return snapshot{}, errors.Wrap(err, "failed to create etcd snapshot dir")
+	}
+	release := func() error { return root.RemoveAll(domain.EtcdSnapshotDir) }
+
+	path, err := root.RawPath(filepath.Join(domain.EtcdSnapshotDir, etcdSnapshotFile))
+	if err != nil {
+		return snapshot{}, err
+	}
+	err = run(runner, domain.EtcdctlPath,
+		"--endpoints="+servers[0],
+		"--cacert="+args["--etcd-cafile"],
+		"--cert="+args["--etcd-certfile"],
+		"--key="+args["--etcd-keyfile"],
+		"snapshot", "save", path,
+	)
+	if err != nil {
+		_ = release()
+		return snapshot{}, errors.Wrap(err, "failed to snapshot etcd")
+	}
+	return snapshot{dir: domain.EtcdSnapshotDir, release: release}, nil
+}
+
+func stopK8sDqlite(runner Runner) (snapshot, error) {
+	// a stopped k8s-dqlite is consistent as it is, and is left stopped
+	if _, err := runner("systemctl", "is-active", "--quiet", "snap."+k8sDqliteService); err != nil {
+		return snapshot{dir: domain.K8sDqliteDataPath, release: func() error { return nil }}, nil
+	}
+
+	if err := run(runner, "snap", "stop", k8sDqliteService); err != nil {
+		return snapshot{}, errors.Wrap(err, "failed to stop k8s-dqlite")
+	}
+	release := func() error {
+		return errors.Wrap(run(runner, "snap", "start", k8sDqliteService), "failed to start k8s-dqlite")
+	}
+	return snapshot{dir: domain.K8sDqliteDataPath, release: release}, nil
+}
+
+// restoreEtcdSnapshot restores the etcd snapshot of an archive into a single member
+// data dir, named and addressed as in the restored etcd args.
+func restoreEtcdSnapshot(root vfs.FS, runner Runner) error {
+	args, err := readArgs(root, "etcd")
+	if err != nil {
+		return err
+	}
+	name, peerURLs := args["--name"], args["--initial-advertise-peer-urls"]
+	if name == "" || peerURLs == "" {
+		return errors.New("etcd args have no name or peer urls")
+	}
+	dataDir := args["--data-dir"]
+	if dataDir == "" {
+		dataDir = domain.EtcdDataPath
+	}
+
+	// etcdutl refuses to restore into an existing data dir
+	if err = root.RemoveAll(dataDir); err != nil {
+		return errors.Wrapf(err, "failed to clear %s", dataDir)
+	}
+	snapshotPath, err := root.RawPath(filepath.Join(domain.EtcdSnapshotDir, etcdSnapshotFile))
+	if err != nil {
+		return err
+	}
+	rawDataDir, err := root.RawPath(dataDir)
+	if err != nil {
+		return err
+	}
+
+	err = run(runner, domain.EtcdutlPath, "snapshot", "restore", snapshotPath,
+		"--data-dir="+rawDataDir,
+		"--name="+name,
+		"--initial-cluster="+name+"="+peerURLs,
+		"--initial-advertise-peer-urls="+peerURLs,
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed to restore the etcd snapshot")
+	}
+	if err = root.RemoveAll(domain.EtcdSnapshotDir); err != nil {
+		logrus.Warnf("failed to remove the restored etcd snapshot: %v", err)
+	}
+	return nil
+}
+
+// readArgs reads the args file of a k8s service, unquoting the values.
+func readArgs(root vfs.FS, service string) (map[string]string, error) {
+	content, err := root.ReadFile(filepath.Join(domain.KubeComponentsArgsPath, service))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read the %s args", service)
+	}
+
+	args := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		if key, value, ok := strings.Cut(scanner.Text(), "="); ok {
+			args[strings.TrimSpace(key)] = strings.Trim(strings.TrimSpace(value), `"`)
+		}
+	}
+	return args, scanner.Err()
+}
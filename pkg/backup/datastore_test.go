@@ -0,0 +1,213 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kairos-io/provider-canonical/pkg/domain"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+	"github.com/twpayne/go-vfs/v4/vfst"
+)
+
+// stoppedDatastore runs on a node whose k8s-dqlite is not active.
+func stoppedDatastore(name string, args ...string) ([]byte, error) {
+	return nil, errors.Errorf("unexpected command %s %v", name, args)
+}
+
+// datastoreWriter keeps appending records to a datastore file, each in two writes,
+// until the datastore is stopped.
+type datastoreWriter struct {
+	mu      sync.Mutex
+	stopped bool
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+func startDatastoreWriter(g *WithT, testFS *vfst.TestFS, path string) *datastoreWriter {
+	w := &datastoreWriter{done: make(chan struct{})}
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-w.done:
+				return
+			default:
+			}
+
+			w.mu.Lock()
+			if !w.stopped {
+				f, err := testFS.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+				g.Expect(err).NotTo(HaveOccurred())
+				_, _ = f.WriteString("record-")
+				time.Sleep(time.Millisecond)
+				_, _ = fmt.Fprintf(f, "%d\n", i)
+				f.Close()
+			}
+			w.mu.Unlock()
+		}
+	}()
+	return w
+}
+
+func (w *datastoreWriter) setStopped(stopped bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.stopped = stopped
+}
+
+func (w *datastoreWriter) close() {
+	close(w.done)
+	w.wg.Wait()
+}
+
+func TestCreateWhileWriting(t *testing.T) {
+	g := NewWithT(t)
+	segment := filepath.Join(domain.K8sDqliteDataPath, "open-1")
+
+	t.Run("restores a k8s-dqlite archive taken while the datastore was writing", func(t *testing.T) {
+		testFS, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+			segment: "",
+		})
+		g.Expect(err).NotTo(HaveOccurred())
+		defer cleanup()
+
+		writer := startDatastoreWriter(g, testFS, segment)
+		var commands []string
+		runner := func(name string, args ...string) ([]byte, error) {
+			command := strings.Join(append([]string{name}, args...), " ")
+			commands = append(commands, command)
+			switch command {
+			case "systemctl is-active --quiet snap.k8s.k8s-dqlite":
+			case "snap stop k8s.k8s-dqlite":
+				writer.setStopped(true)
+			case "snap start k8s.k8s-dqlite":
+				writer.setStopped(false)
+			default:
+				return nil, errors.Errorf("unexpected command %s", command)
+			}
+			return nil, nil
+		}
+
+		time.Sleep(10 * time.Millisecond)
+		archive, err := Create(testFS, "/backups", time.Now(), runner)
+		writer.close()
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(commands).To(Equal([]string{
+			"systemctl is-active --quiet snap.k8s.k8s-dqlite",
+			"snap stop k8s.k8s-dqlite",
+			"snap start k8s.k8s-dqlite",
+		}))
+
+		_, err = Restore(testFS, archive, stoppedDatastore)
+		g.Expect(err).NotTo(HaveOccurred())
+
+		content, err := testFS.ReadFile(segment)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(string(content)).To(MatchRegexp(`^(record-\d+\n)+$`))
+	})
+
+	t.Run("fails without starting k8s-dqlite when it cannot be stopped", func(t *testing.T) {
+		testFS, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+			segment: "record-0\n",
+		})
+		g.Expect(err).NotTo(HaveOccurred())
+		defer cleanup()
+
+		var commands []string
+		runner := func(name string, args ...string) ([]byte, error) {
+			commands = append(commands, strings.Join(append([]string{name}, args...), " "))
+			if name == "snap" {
+				return []byte("snap is busy"), errors.New("exit status 1")
+			}
+			return nil, nil
+		}
+
+		_, err = Create(testFS, "/backups", time.Now(), runner)
+		g.Expect(err).To(MatchError(ContainSubstring("failed to stop k8s-dqlite")))
+		g.Expect(err).To(MatchError(ContainSubstring("snap is busy")))
+		g.Expect(commands).NotTo(ContainElement("snap start k8s.k8s-dqlite"))
+
+		archives, err := testFS.Glob("/backups/*")
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(archives).To(BeEmpty())
+	})
+
+	t.Run("restores an etcd snapshot taken while etcd was writing", func(t *testing.T) {
+		wal := filepath.Join(domain.EtcdDataPath, "member/wal/0000000000000000-0000000000000000.wal")
+		testFS, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+			wal: "",
+			filepath.Join(domain.KubeComponentsArgsPath, "kube-apiserver"): "--etcd-servers=https://127.0.0.1:2379,https://10.0.0.2:2379\n" +
+				"--etcd-cafile=/etc/kubernetes/pki/etcd/ca.crt\n" +
+				"--etcd-certfile=/etc/kubernetes/pki/apiserver-etcd-client.crt\n" +
+				"--etcd-keyfile=/etc/kubernetes/pki/apiserver-etcd-client.key\n",
+			filepath.Join(domain.KubeComponentsArgsPath, "etcd"): "--name=\"node-1\"\n--initial-advertise-peer-urls=https://10.0.0.1:2380\n",
+		})
+		g.Expect(err).NotTo(HaveOccurred())
+		defer cleanup()
+
+		writer := startDatastoreWriter(g, testFS, wal)
+		var commands [][]string
+		runner := func(name string, args ...string) ([]byte, error) {
+			commands = append(commands, append([]string{name}, args...))
+			switch name {
+			case domain.EtcdctlPath:
+				return nil, os.WriteFile(args[len(args)-1], []byte("snapshot"), 0600)
+			case domain.EtcdutlPath:
+				content, err := os.ReadFile(args[2])
+				if err != nil {
+					return nil, err
+				}
+				dataDir := strings.TrimPrefix(args[3], "--data-dir=")
+				if err = os.MkdirAll(filepath.Join(dataDir, "member/snap"), 0700); err != nil {
+					return nil, err
+				}
+				return nil, os.WriteFile(filepath.Join(dataDir, "member/snap/db"), content, 0600)
+			}
+			return nil, errors.Errorf("unexpected command %s", name)
+		}
+
+		archive, err := Create(testFS, "/backups", time.Now(), runner)
+		writer.close()
+		g.Expect(err).NotTo(HaveOccurred())
+
+		snapshotPath, err := testFS.RawPath(filepath.Join(domain.EtcdSnapshotDir, etcdSnapshotFile))
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(commands[0]).To(Equal([]string{
+			domain.EtcdctlPath,
+			"--endpoints=https://127.0.0.1:2379",
+			"--cacert=/etc/kubernetes/pki/etcd/ca.crt",
+			"--cert=/etc/kubernetes/pki/apiserver-etcd-client.crt",
+			"--key=/etc/kubernetes/pki/apiserver-etcd-client.key",
+			"snapshot", "save", snapshotPath,
+		}))
+		vfst.RunTests(t, testFS, "",
+			vfst.TestPath(domain.EtcdSnapshotDir, vfst.TestDoesNotExist),
+		)
+
+		dirs, err := Restore(testFS, archive, runner)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(dirs).To(Equal([]string{domain.EtcdDataPath, domain.KubeComponentsArgsPath}))
+
+		dataDir, err := testFS.RawPath(domain.EtcdDataPath)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(commands[1]).To(Equal([]string{
+			domain.EtcdutlPath, "snapshot", "restore", snapshotPath,
+			"--data-dir=" + dataDir,
+			"--name=node-1",
+			"--initial-cluster=node-1=https://10.0.0.1:2380",
+			"--initial-advertise-peer-urls=https://10.0.0.1:2380",
+		}))
+		vfst.RunTests(t, testFS, "",
+			vfst.TestPath(filepath.Join(domain.EtcdDataPath, "member/snap/db"), vfst.TestContentsString("snapshot")),
+			vfst.TestPath(wal, vfst.TestDoesNotExist),
+			vfst.TestPath(domain.EtcdSnapshotDir, vfst.TestDoesNotExist),
+		)
+	})
+}
@@ -0,0 +1,168 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/kairos-io/provider-canonical/pkg/domain"
+	"github.com/pkg/errors"
+	"github.com/twpayne/go-vfs/v4"
+)
+
+// restorableDirs are the only locations an archive may write to on restore.
+var restorableDirs = []string{
+	domain.K8sDqliteDataPath,
+	domain.EtcdDataPath,
+	domain.EtcdSnapshotDir,
+	domain.K8sdStatePath,
+	domain.KubeCertificateDirPath,
+	domain.KubeComponentsArgsPath,
+}
+
+// Restore verifies the archive checksum and extracts its contents in place. Every
+// restorable directory present in the archive is emptied first, so the restored
+// node does not mix the backed up state with whatever the snap install created.
+// An etcd snapshot is then restored into the etcd data dir.
+func Restore(root vfs.FS, archive string, runner Runner) ([]string, error) {
+	if err := Verify(root, archive); err != nil {
+		return nil, err
+	}
+
+	dirs, err := archiveDirs(root, archive)
+	if err != nil {
+		return nil, err
+	}
+	if len(dirs) == 0 {
+		return nil, errors.Errorf("backup archive %s contains nothing to restore", archive)
+	}
+
+	for _, dir := range dirs {
+		if err = root.RemoveAll(dir); err != nil {
+			return nil, errors.Wrapf(err, "failed to clear %s", dir)
+		}
+	}
+
+	err = readArchive(root, archive, func(target string, header *tar.Header, tr *tar.Reader) error {
+		return extract(root, target, header, tr)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i, dir := range dirs {
+		if dir == domain.EtcdSnapshotDir {
+			if err = restoreEtcdSnapshot(root, runner); err != nil {
+				return nil, err
+			}
+			dirs[i] = domain.EtcdDataPath
+		}
+	}
+	return dirs, nil
+}
+
+// archiveDirs returns the restorable directories the archive has content for.
+func archiveDirs(root vfs.FS, archive string) ([]string, error) {
+	found := map[string]bool{}
+	err := readArchive(root, archive, func(target string, _ *tar.Header, _ *tar.Reader) error {
+		found[restorableDir(target)] = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var dirs []string
+	for _, dir := range restorableDirs {
+		if found[dir] {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs, nil
+}
+
+func readArchive(root vfs.FS, archive string, fn func(target string, header *tar.Header, tr *tar.Reader) error) error {
+	f, err := root.Open(archive)
+	if err != nil {
+		return errors.Wrap(err, "failed to open backup archive")
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return errors.Wrap(err, "failed to decompress backup archive")
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to read backup archive")
+		}
+
+		target := "/" + path.Clean(strings.TrimPrefix(header.Name, "/"))
+		if restorableDir(target) == "" {
+			return errors.Errorf("backup archive entry %s is outside of the restorable directories", header.Name)
+		}
+		if err = fn(target, header, tr); err != nil {
+			return err
+		}
+	}
+}
+
+func restorableDir(target string) string {
+	for _, dir := range restorableDirs {
+		if target == dir || strings.HasPrefix(target, dir+"/") {
+			return dir
+		}
+	}
+	return ""
+}
+
+func extract(root vfs.FS, target string, header *tar.Header, tr *tar.Reader) error {
+	mode := fs.FileMode(header.Mode).Perm()
+
+	switch header.Typeflag {
+	case tar.TypeDir:
+		if err := vfs.MkdirAll(root, target, mode); err != nil {
+			return err
+		}
+		return root.Chmod(target, mode)
+	case tar.TypeSymlink:
+		link := header.Linkname
+		if !filepath.IsAbs(link) {
+			link = filepath.Join(filepath.Dir(target), link)
+		}
+		if restorableDir(filepath.Clean(link)) == "" {
+			return errors.Errorf("backup archive symlink %s points outside of the restorable directories", header.Name)
+		}
+		if err := vfs.MkdirAll(root, filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		return root.Symlink(header.Linkname, target)
+	case tar.TypeReg:
+		if err := vfs.MkdirAll(root, filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		f, err := root.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if _, err = io.CopyN(f, tr, header.Size); err != nil {
+			return err
+		}
+		return f.Sync()
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,90 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kairos-io/provider-canonical/pkg/domain"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4/vfst"
+)
+
+func TestRestore(t *testing.T) {
+	g := NewWithT(t)
+
+	t.Run("restores the backed up directories and clears stale content", func(t *testing.T) {
+		testFS, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+			filepath.Join(domain.K8sDqliteDataPath, "snapshot-1"):   "snapshot",
+			filepath.Join(domain.KubeCertificateDirPath, "ca.crt"):  "ca",
+			filepath.Join(domain.KubeComponentsArgsPath, "kubelet"): "--node-ip=10.0.0.1",
+		})
+		g.Expect(err).NotTo(HaveOccurred())
+		defer cleanup()
+
+		archive, err := Create(testFS, "/backups", time.Now(), stoppedDatastore)
+		g.Expect(err).NotTo(HaveOccurred())
+
+		g.Expect(testFS.RemoveAll(domain.KubeCertificateDirPath)).To(Succeed())
+		g.Expect(testFS.WriteFile(filepath.Join(domain.K8sDqliteDataPath, "stale"), []byte("stale"), 0600)).To(Succeed())
+
+		dirs, err := Restore(testFS, archive, stoppedDatastore)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(dirs).To(Equal([]string{domain.K8sDqliteDataPath, domain.KubeCertificateDirPath, domain.KubeComponentsArgsPath}))
+
+		vfst.RunTests(t, testFS, "",
+			vfst.TestPath(filepath.Join(domain.K8sDqliteDataPath, "snapshot-1"), vfst.TestContentsString("snapshot")),
+			vfst.TestPath(filepath.Join(domain.K8sDqliteDataPath, "stale"), vfst.TestDoesNotExist),
+			vfst.TestPath(filepath.Join(domain.KubeCertificateDirPath, "ca.crt"), vfst.TestContentsString("ca")),
+			vfst.TestPath(filepath.Join(domain.KubeComponentsArgsPath, "kubelet"), vfst.TestContentsString("--node-ip=10.0.0.1")),
+		)
+	})
+
+	t.Run("refuses an archive with a checksum mismatch", func(t *testing.T) {
+		testFS, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+			filepath.Join(domain.K8sDqliteDataPath, "snapshot-1"): "snapshot",
+		})
+		g.Expect(err).NotTo(HaveOccurred())
+		defer cleanup()
+
+		archive, err := Create(testFS, "/backups", time.Now(), stoppedDatastore)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(testFS.WriteFile(archive+checksumSuffix, []byte("0000  archive\n"), 0600)).To(Succeed())
+
+		_, err = Restore(testFS, archive, stoppedDatastore)
+		g.Expect(err).To(MatchError(ContainSubstring("checksum mismatch")))
+	})
+
+	t.Run("refuses entries outside of the restorable directories", func(t *testing.T) {
+		testFS, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+			"/backups": &vfst.Dir{Perm: 0700},
+		})
+		g.Expect(err).NotTo(HaveOccurred())
+		defer cleanup()
+
+		archive := "/backups/canonical-backup-20260101T000000Z.tar.gz"
+		f, err := testFS.Create(archive)
+		g.Expect(err).NotTo(HaveOccurred())
+		gz := gzip.NewWriter(f)
+		tw := tar.NewWriter(gz)
+		g.Expect(tw.WriteHeader(&tar.Header{Name: "etc/kubernetes/pki/../../shadow", Mode: 0600, Size: 1, Typeflag: tar.TypeReg})).To(Succeed())
+		_, err = tw.Write([]byte("x"))
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(tw.Close()).To(Succeed())
+		g.Expect(gz.Close()).To(Succeed())
+		g.Expect(f.Close()).To(Succeed())
+
+		content, err := testFS.ReadFile(archive)
+		g.Expect(err).NotTo(HaveOccurred())
+		sum := sha256.Sum256(content)
+		g.Expect(testFS.WriteFile(archive+checksumSuffix, []byte(fmt.Sprintf("%s  archive\n", hex.EncodeToString(sum[:]))), 0600)).To(Succeed())
+
+		_, err = Restore(testFS, archive, stoppedDatastore)
+		g.Expect(err).To(MatchError(ContainSubstring("outside of the restorable directories")))
+	})
+}
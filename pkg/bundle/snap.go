@@ -0,0 +1,99 @@
+package bundle
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/kairos-io/provider-canonical/pkg/domain"
+	"github.com/pkg/errors"
+	"github.com/twpayne/go-vfs/v4"
+	"gopkg.in/yaml.v3"
+)
+
+// ReadRevision returns the revision of the named snap in the airgap bundle, from
+// the revision dir or the legacy location of older deployments, like
+// read_revision in common.sh.
+func ReadRevision(root vfs.FS, name string) (string, error) {
+	for _, path := range []string{
+		filepath.Join(domain.SnapRevisionDir, name+".revision"),
+		filepath.Join(domain.LegacySnapRevisionDir, name+".revision"),
+	} {
+		content, err := root.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		revision := strings.TrimSpace(string(content))
+		if revision == "" {
+			return "", errors.Errorf("%s is empty", path)
+		}
+		return revision, nil
+	}
+	return "", errors.Errorf("revision file for %s not found", name)
+}
+
+// SnapArchitectures returns the architectures declared in meta/snap.yaml of the
+// snap at path.
+func SnapArchitectures(root vfs.FS, path string) ([]string, error) {
+	f, err := root.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	readerAt, ok := f.(io.ReaderAt)
+	if !ok {
+		return nil, errors.Errorf("%s does not support random access", path)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	squashfs, err := openSquashfs(readerAt, info.Size())
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open snap %s", path)
+	}
+	content, err := squashfs.ReadFile("meta/snap.yaml")
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read snap.yaml of %s", path)
+	}
+
+	var snapYaml struct {
+		Architectures []string `yaml:"architectures"`
+	}
+	if err = yaml.Unmarshal(content, &snapYaml); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse snap.yaml of %s", path)
+	}
+	if len(snapYaml.Architectures) == 0 {
+		// snapd defaults to "all" when no architectures are declared
+		return []string{"all"}, nil
+	}
+	return snapYaml.Architectures, nil
+}
+
+// HostArchitecture returns the snap architecture name of the running host.
+func HostArchitecture() string {
+	switch runtime.GOARCH {
+	case "arm":
+		return "armhf"
+	case "386":
+		return "i386"
+	default:
+		return runtime.GOARCH
+	}
+}
+
+// SupportsArchitecture reports whether a snap built for archs runs on arch.
+func SupportsArchitecture(archs []string, arch string) bool {
+	for _, a := range archs {
+		if a == arch || a == "all" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,202 @@
+package bundle
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4/vfst"
+)
+
+func TestReadRevision(t *testing.T) {
+	g := NewWithT(t)
+
+	testFS, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/opt/canonical/revision/k8s.revision":   "3120\n",
+		"/opt/canonical/core.revision":           "1587",
+		"/opt/canonical/revision/snapd.revision": "",
+	})
+	g.Expect(err).NotTo(HaveOccurred())
+	defer cleanup()
+
+	revision, err := ReadRevision(testFS, "k8s")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(revision).To(Equal("3120"))
+
+	revision, err = ReadRevision(testFS, "core")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(revision).To(Equal("1587"))
+
+	_, err = ReadRevision(testFS, "snapd")
+	g.Expect(err).To(MatchError(ContainSubstring("is empty")))
+
+	_, err = ReadRevision(testFS, "missing")
+	g.Expect(err).To(MatchError(ContainSubstring("not found")))
+}
+
+func TestSnapArchitectures(t *testing.T) {
+	g := NewWithT(t)
+
+	snapYaml := "name: k8s\nversion: v1.32.0\narchitectures:\n  - amd64\n"
+
+	for name, fragment := range map[string]bool{"data block": false, "fragment": true} {
+		t.Run(name, func(t *testing.T) {
+			testFS, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/k8s.snap":    string(testSquashfs(g, "meta", "snap.yaml", snapYaml, fragment)),
+				"/broken.snap": "not a squashfs image",
+			})
+			g.Expect(err).NotTo(HaveOccurred())
+			defer cleanup()
+
+			archs, err := SnapArchitectures(testFS, "/k8s.snap")
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(archs).To(Equal([]string{"amd64"}))
+			g.Expect(SupportsArchitecture(archs, "amd64")).To(BeTrue())
+			g.Expect(SupportsArchitecture(archs, "arm64")).To(BeFalse())
+
+			_, err = SnapArchitectures(testFS, "/broken.snap")
+			g.Expect(err).To(HaveOccurred())
+		})
+	}
+
+	t.Run("rejects corrupt images", func(t *testing.T) {
+		image := testSquashfs(g, "meta", "snap.yaml", snapYaml, false)
+		withBlockSize := func(size uint32) []byte {
+			corrupt := bytes.Clone(image)
+			binary.LittleEndian.PutUint32(corrupt[12:], size)
+			return corrupt
+		}
+
+		// the inode of a file in a data block right after the superblock
+		var inode bytes.Buffer
+		g.Expect(binary.Write(&inode, binary.LittleEndian, []uint32{96, noFragment, 0, uint32(len(snapYaml))})).To(Succeed())
+		hugeFile := bytes.Clone(image)
+		at := bytes.Index(hugeFile, inode.Bytes())
+		g.Expect(at).To(BeNumerically(">", 0))
+		binary.LittleEndian.PutUint32(hugeFile[at+12:], 0xffffffff)
+
+		for path, content := range map[string][]byte{
+			"/zero-block.snap": withBlockSize(0),
+			"/odd-block.snap":  withBlockSize(3000),
+			"/huge-block.snap": withBlockSize(1 << 24),
+			"/truncated.snap":  image[:len(image)-8],
+			"/huge-file.snap":  hugeFile,
+		} {
+			testFS, cleanup, err := vfst.NewTestFS(map[string]interface{}{path: string(content)})
+			g.Expect(err).NotTo(HaveOccurred())
+
+			_, err = SnapArchitectures(testFS, path)
+			g.Expect(err).To(HaveOccurred(), path)
+			cleanup()
+		}
+	})
+
+	t.Run("fails without snap.yaml", func(t *testing.T) {
+		testFS, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+			"/k8s.snap": string(testSquashfs(g, "meta", "icon.png", "png", false)),
+		})
+		g.Expect(err).NotTo(HaveOccurred())
+		defer cleanup()
+
+		_, err = SnapArchitectures(testFS, "/k8s.snap")
+		g.Expect(err).To(MatchError(ContainSubstring("snap.yaml not found")))
+	})
+}
+
+// testSquashfs builds an uncompressed squashfs image holding the single file
+// /dir/file, its data either in a data block or in a fragment.
+func testSquashfs(g *WithT, dir, file, content string, fragment bool) []byte {
+	le := binary.LittleEndian
+	const blockSize = 4096
+
+	var image bytes.Buffer
+	image.Write(make([]byte, 96))
+
+	dataStart := uint64(image.Len())
+	image.WriteString(content)
+
+	fragmentTable := uint64(0)
+	if fragment {
+		entryBlock := uint64(image.Len())
+		var entry bytes.Buffer
+		g.Expect(binary.Write(&entry, le, struct {
+			Start  uint64
+			Size   uint32
+			Unused uint32
+		}{dataStart, uint32(len(content)) | dataUncompressed, 0})).To(Succeed())
+		writeMetadata(g, &image, entry.Bytes())
+
+		fragmentTable = uint64(image.Len())
+		g.Expect(binary.Write(&image, le, entryBlock)).To(Succeed())
+	}
+
+	var inodes bytes.Buffer
+	writeDirInode := func(listingOffset, listingSize int) {
+		g.Expect(binary.Write(&inodes, le, []uint16{inodeBasicDir, 0755, 0, 0})).To(Succeed())
+		g.Expect(binary.Write(&inodes, le, []uint32{0, 1})).To(Succeed())
+		g.Expect(binary.Write(&inodes, le, struct {
+			Block  uint32
+			Links  uint32
+			Size   uint16
+			Offset uint16
+			Parent uint32
+		}{0, 2, uint16(listingSize + 3), uint16(listingOffset), 0})).To(Succeed())
+	}
+
+	var listings bytes.Buffer
+	writeListing := func(inodeOffset uint16, kind uint16, name string) int {
+		start := listings.Len()
+		g.Expect(binary.Write(&listings, le, []uint32{0, 0, 1})).To(Succeed())
+		g.Expect(binary.Write(&listings, le, []uint16{inodeOffset, 0, kind, uint16(len(name) - 1)})).To(Succeed())
+		listings.WriteString(name)
+		return listings.Len() - start
+	}
+
+	rootListing := writeListing(32, inodeBasicDir, dir)
+	dirListing := writeListing(64, inodeBasicFile, file)
+	writeDirInode(0, rootListing)
+	writeDirInode(rootListing, dirListing)
+
+	g.Expect(binary.Write(&inodes, le, []uint16{inodeBasicFile, 0644, 0, 0})).To(Succeed())
+	g.Expect(binary.Write(&inodes, le, []uint32{0, 3})).To(Succeed())
+	if fragment {
+		g.Expect(binary.Write(&inodes, le, []uint32{0, 0, 0, uint32(len(content))})).To(Succeed())
+	} else {
+		g.Expect(binary.Write(&inodes, le, []uint32{uint32(dataStart), noFragment, 0, uint32(len(content))})).To(Succeed())
+		g.Expect(binary.Write(&inodes, le, uint32(len(content))|dataUncompressed)).To(Succeed())
+	}
+
+	inodeTable := uint64(image.Len())
+	writeMetadata(g, &image, inodes.Bytes())
+	directoryTable := uint64(image.Len())
+	writeMetadata(g, &image, listings.Bytes())
+
+	super := superblock{
+		Magic:          squashfsMagic,
+		InodeCount:     3,
+		BlockSize:      blockSize,
+		Compressor:     compressorGzip,
+		BlockLog:       12,
+		VersionMajor:   4,
+		RootInode:      0,
+		BytesUsed:      uint64(image.Len()),
+		InodeTable:     inodeTable,
+		DirectoryTable: directoryTable,
+		FragmentTable:  fragmentTable,
+	}
+	if fragment {
+		super.FragmentCount = 1
+	}
+
+	out := image.Bytes()
+	var header bytes.Buffer
+	g.Expect(binary.Write(&header, le, super)).To(Succeed())
+	copy(out, header.Bytes())
+	return out
+}
+
+func writeMetadata(g *WithT, w *bytes.Buffer, data []byte) {
+	g.Expect(binary.Write(w, binary.LittleEndian, uint16(len(data))|metadataUncompressed)).To(Succeed())
+	w.Write(data)
+}
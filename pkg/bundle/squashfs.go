@@ -0,0 +1,410 @@
+package bundle
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+	"github.com/ulikunitz/xz"
+)
+
+// squashfs is a minimal read-only squashfs 4.0 reader, enough to read single files
+// such as meta/snap.yaml out of a snap without mounting it. The bundle is verified
+// before snapd is installed, when neither unsquashfs nor a loop mount is available.
+type squashfs struct {
+	r     io.ReaderAt
+	size  int64
+	super superblock
+}
+
+type superblock struct {
+	Magic          uint32
+	InodeCount     uint32
+	ModTime        uint32
+	BlockSize      uint32
+	FragmentCount  uint32
+	Compressor     uint16
+	BlockLog       uint16
+	Flags          uint16
+	IDCount        uint16
+	VersionMajor   uint16
+	VersionMinor   uint16
+	RootInode      uint64
+	BytesUsed      uint64
+	IDTable        uint64
+	XattrTable     uint64
+	InodeTable     uint64
+	DirectoryTable uint64
+	FragmentTable  uint64
+	ExportTable    uint64
+}
+
+const (
+	squashfsMagic = 0x73717368
+
+	compressorGzip = 1
+	compressorXz   = 4
+	compressorZstd = 6
+
+	inodeBasicDir  = 1
+	inodeBasicFile = 2
+	inodeExtDir    = 8
+	inodeExtFile   = 9
+
+	metadataBlockSize    = 8192
+	minBlockSize         = 4 << 10
+	maxBlockSize         = 1 << 20
+	metadataUncompressed = 0x8000
+	dataUncompressed     = 1 << 24
+	noFragment           = 0xffffffff
+)
+
+// openSquashfs opens the squashfs image of size bytes. The image is untrusted, so
+// every size read from it is bounded by the image size or the block size.
+func openSquashfs(r io.ReaderAt, size int64) (*squashfs, error) {
+	fs := &squashfs{r: r, size: size}
+	if err := binary.Read(io.NewSectionReader(r, 0, 96), binary.LittleEndian, &fs.super); err != nil {
+		return nil, errors.Wrap(err, "failed to read squashfs superblock")
+	}
+	if fs.super.Magic != squashfsMagic {
+		return nil, errors.New("not a squashfs image")
+	}
+	if fs.super.VersionMajor != 4 {
+		return nil, errors.Errorf("unsupported squashfs version %d.%d", fs.super.VersionMajor, fs.super.VersionMinor)
+	}
+	if bs := fs.super.BlockSize; bs < minBlockSize || bs > maxBlockSize || bs&(bs-1) != 0 || 1<<fs.super.BlockLog != bs {
+		return nil, errors.Errorf("corrupt squashfs block size %d", bs)
+	}
+	if fs.super.BytesUsed > uint64(size) {
+		return nil, errors.Errorf("squashfs image is truncated to %d of %d bytes", size, fs.super.BytesUsed)
+	}
+	return fs, nil
+}
+
+// ReadFile returns the content of the regular file at path.
+func (fs *squashfs) ReadFile(path string) ([]byte, error) {
+	inode, err := fs.readInode(fs.super.RootInode)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range strings.Split(strings.Trim(path, "/"), "/") {
+		if !inode.isDir() {
+			return nil, errors.Errorf("%s: not a directory", path)
+		}
+		ref, err := fs.lookup(inode, name)
+		if err != nil {
+			return nil, errors.Wrap(err, path)
+		}
+		if inode, err = fs.readInode(ref); err != nil {
+			return nil, err
+		}
+	}
+
+	if inode.isDir() {
+		return nil, errors.Errorf("%s: is a directory", path)
+	}
+	return fs.readData(inode)
+}
+
+type inode struct {
+	kind uint16
+
+	// directories
+	dirBlock  uint32
+	dirOffset uint16
+	dirSize   uint32
+
+	// regular files
+	blocksStart uint64
+	fileSize    uint64
+	fragment    uint32
+	fragOffset  uint32
+	blockSizes  []uint32
+}
+
+func (i inode) isDir() bool {
+	return i.kind == inodeBasicDir || i.kind == inodeExtDir
+}
+
+func (fs *squashfs) readInode(ref uint64) (inode, error) {
+	r := fs.metadataReader(fs.super.InodeTable+ref>>16, uint16(ref&0xffff))
+
+	var header struct {
+		Kind, Mode, UID, GID uint16
+		MTime, Number        uint32
+	}
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return inode{}, errors.Wrap(err, "failed to read inode")
+	}
+
+	in := inode{kind: header.Kind}
+	switch header.Kind {
+	case inodeBasicDir:
+		var dir struct {
+			Block  uint32
+			Links  uint32
+			Size   uint16
+			Offset uint16
+			Parent uint32
+		}
+		if err := binary.Read(r, binary.LittleEndian, &dir); err != nil {
+			return in, err
+		}
+		in.dirBlock, in.dirOffset, in.dirSize = dir.Block, dir.Offset, uint32(dir.Size)
+	case inodeExtDir:
+		var dir struct {
+			Links      uint32
+			Size       uint32
+			Block      uint32
+			Parent     uint32
+			IndexCount uint16
+			Offset     uint16
+			Xattr      uint32
+		}
+		if err := binary.Read(r, binary.LittleEndian, &dir); err != nil {
+			return in, err
+		}
+		in.dirBlock, in.dirOffset, in.dirSize = dir.Block, dir.Offset, dir.Size
+	case inodeBasicFile:
+		var file struct {
+			Start    uint32
+			Fragment uint32
+			Offset   uint32
+			Size     uint32
+		}
+		if err := binary.Read(r, binary.LittleEndian, &file); err != nil {
+			return in, err
+		}
+		in.blocksStart, in.fragment, in.fragOffset, in.fileSize = uint64(file.Start), file.Fragment, file.Offset, uint64(file.Size)
+	case inodeExtFile:
+		var file struct {
+			Start    uint64
+			Size     uint64
+			Sparse   uint64
+			Links    uint32
+			Fragment uint32
+			Offset   uint32
+			Xattr    uint32
+		}
+		if err := binary.Read(r, binary.LittleEndian, &file); err != nil {
+			return in, err
+		}
+		in.blocksStart, in.fragment, in.fragOffset, in.fileSize = file.Start, file.Fragment, file.Offset, file.Size
+	default:
+		return in, errors.Errorf("unsupported inode type %d", header.Kind)
+	}
+
+	if !in.isDir() {
+		blocks := in.fileSize / uint64(fs.super.BlockSize)
+		if in.fragment == noFragment && in.fileSize%uint64(fs.super.BlockSize) != 0 {
+			blocks++
+		}
+		// every block takes a 4 byte entry of the block list in the image
+		if blocks > uint64(fs.size)/4 {
+			return in, errors.Errorf("corrupt file size %d", in.fileSize)
+		}
+		in.blockSizes = make([]uint32, blocks)
+		if err := binary.Read(r, binary.LittleEndian, in.blockSizes); err != nil {
+			return in, errors.Wrap(err, "failed to read file block list")
+		}
+	}
+	return in, nil
+}
+
+// lookup finds name in a directory listing and returns its inode reference.
+func (fs *squashfs) lookup(dir inode, name string) (uint64, error) {
+	// the listing size includes the implicit . and .. entries
+	if dir.dirSize <= 3 {
+		return 0, errors.Errorf("%s not found", name)
+	}
+	r := io.LimitReader(fs.metadataReader(fs.super.DirectoryTable+uint64(dir.dirBlock), dir.dirOffset), int64(dir.dirSize-3))
+
+	for {
+		var header struct {
+			Count, Start, Number uint32
+		}
+		if err := binary.Read(r, binary.LittleEndian, &header); err == io.EOF {
+			return 0, errors.Errorf("%s not found", name)
+		} else if err != nil {
+			return 0, errors.Wrap(err, "failed to read directory")
+		}
+
+		for i := uint32(0); i <= header.Count; i++ {
+			var entry struct {
+				Offset      uint16
+				InodeOffset int16
+				Kind        uint16
+				NameSize    uint16
+			}
+			if err := binary.Read(r, binary.LittleEndian, &entry); err != nil {
+				return 0, errors.Wrap(err, "failed to read directory entry")
+			}
+			entryName := make([]byte, int(entry.NameSize)+1)
+			if _, err := io.ReadFull(r, entryName); err != nil {
+				return 0, errors.Wrap(err, "failed to read directory entry")
+			}
+			if string(entryName) == name {
+				return uint64(header.Start)<<16 | uint64(entry.Offset), nil
+			}
+		}
+	}
+}
+
+func (fs *squashfs) readData(in inode) ([]byte, error) {
+	var data bytes.Buffer
+	offset := int64(in.blocksStart)
+
+	for _, size := range in.blockSizes {
+		onDisk := size &^ dataUncompressed
+		if onDisk > fs.super.BlockSize {
+			return nil, errors.New("corrupt data block size")
+		}
+		if onDisk == 0 {
+			// sparse block
+			data.Write(make([]byte, fs.super.BlockSize))
+			continue
+		}
+		block, err := fs.readBlock(offset, onDisk, size&dataUncompressed == 0, fs.super.BlockSize)
+		if err != nil {
+			return nil, err
+		}
+		data.Write(block)
+		offset += int64(onDisk)
+	}
+
+	if in.fragment != noFragment {
+		fragment, err := fs.readFragment(in.fragment)
+		if err != nil {
+			return nil, err
+		}
+		end := uint64(in.fragOffset) + in.fileSize%uint64(fs.super.BlockSize)
+		if end > uint64(len(fragment)) {
+			return nil, errors.New("fragment out of bounds")
+		}
+		data.Write(fragment[in.fragOffset:end])
+	}
+
+	if uint64(data.Len()) < in.fileSize {
+		return nil, errors.New("truncated file data")
+	}
+	return data.Bytes()[:in.fileSize], nil
+}
+
+func (fs *squashfs) readFragment(index uint32) ([]byte, error) {
+	var blockRef uint64
+	table := io.NewSectionReader(fs.r, int64(fs.super.FragmentTable)+int64(index/512)*8, 8)
+	if err := binary.Read(table, binary.LittleEndian, &blockRef); err != nil {
+		return nil, errors.Wrap(err, "failed to read fragment table")
+	}
+
+	var entry struct {
+		Start  uint64
+		Size   uint32
+		Unused uint32
+	}
+	r := fs.metadataReader(blockRef, uint16(index%512)*16)
+	if err := binary.Read(r, binary.LittleEndian, &entry); err != nil {
+		return nil, errors.Wrap(err, "failed to read fragment entry")
+	}
+	if size := entry.Size &^ dataUncompressed; size > fs.super.BlockSize {
+		return nil, errors.New("corrupt fragment size")
+	}
+	return fs.readBlock(int64(entry.Start), entry.Size&^dataUncompressed, entry.Size&dataUncompressed == 0, fs.super.BlockSize)
+}
+
+func (fs *squashfs) readBlock(offset int64, size uint32, compressed bool, limit uint32) ([]byte, error) {
+	raw := make([]byte, size)
+	if _, err := fs.r.ReadAt(raw, offset); err != nil {
+		return nil, errors.Wrap(err, "failed to read block")
+	}
+	if !compressed {
+		return raw, nil
+	}
+	return fs.decompress(raw, limit)
+}
+
+// decompress inflates a block, failing once it exceeds limit bytes.
+func (fs *squashfs) decompress(raw []byte, limit uint32) ([]byte, error) {
+	var r io.Reader
+	var err error
+
+	switch fs.super.Compressor {
+	case compressorGzip:
+		r, err = zlib.NewReader(bytes.NewReader(raw))
+	case compressorXz:
+		r, err = xz.NewReader(bytes.NewReader(raw))
+	case compressorZstd:
+		var d *zstd.Decoder
+		if d, err = zstd.NewReader(bytes.NewReader(raw)); err == nil {
+			defer d.Close()
+			r = d
+		}
+	default:
+		return nil, errors.Errorf("unsupported squashfs compressor %d", fs.super.Compressor)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decompress block")
+	}
+
+	out, err := io.ReadAll(io.LimitReader(r, int64(limit)+1))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decompress block")
+	}
+	if len(out) > int(limit) {
+		return nil, errors.New("decompressed block exceeds the block size")
+	}
+	return out, nil
+}
+
+// metadataReader reads the metadata blocks starting at the absolute position
+// block, skipping offset bytes of the first uncompressed block.
+func (fs *squashfs) metadataReader(block uint64, offset uint16) io.Reader {
+	return &metadataReader{fs: fs, next: int64(block), skip: int(offset)}
+}
+
+type metadataReader struct {
+	fs   *squashfs
+	next int64
+	skip int
+	buf  []byte
+}
+
+func (m *metadataReader) Read(p []byte) (int, error) {
+	for len(m.buf) == 0 {
+		if err := m.readBlock(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, m.buf)
+	m.buf = m.buf[n:]
+	return n, nil
+}
+
+func (m *metadataReader) readBlock() error {
+	var header uint16
+	if err := binary.Read(io.NewSectionReader(m.fs.r, m.next, 2), binary.LittleEndian, &header); err != nil {
+		return err
+	}
+	size := uint32(header &^ metadataUncompressed)
+	if size == 0 || size > metadataBlockSize {
+		return errors.New("corrupt metadata block")
+	}
+
+	block, err := m.fs.readBlock(m.next+2, size, header&metadataUncompressed == 0, metadataBlockSize)
+	if err != nil {
+		return err
+	}
+	m.next += 2 + int64(size)
+
+	if m.skip > len(block) {
+		return errors.New("metadata offset out of bounds")
+	}
+	m.buf = block[m.skip:]
+	m.skip = 0
+	return nil
+}
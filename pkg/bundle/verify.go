@@ -0,0 +1,195 @@
+package bundle
+
+import (
+	"bufio"
+	"crypto/sha3"
+	"encoding/base64"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/twpayne/go-vfs/v4"
+)
+
+// Snaps are the snaps of the airgap bundle, in install order. The core snap is
+// shipped as core20, core22 or core24 depending on the k8s release.
+var Snaps = []string{"snapd", "core", "k8s"}
+
+// SnapReport is the verification outcome of one snap of the bundle.
+type SnapReport struct {
+	Name          string   `json:"name"`
+	Revision      string   `json:"revision,omitempty"`
+	Snap          string   `json:"snap,omitempty"`
+	Assertion     string   `json:"assertion,omitempty"`
+	Architectures []string `json:"architectures,omitempty"`
+	Error         string   `json:"error,omitempty"`
+	Warning       string   `json:"warning,omitempty"`
+}
+
+type Report struct {
+	Dir   string       `json:"dir"`
+	Snaps []SnapReport `json:"snaps"`
+}
+
+// OK reports whether every snap of the bundle can be installed.
+func (r Report) OK() bool {
+	for _, snap := range r.Snaps {
+		if snap.Error != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// Verify checks that the snaps pinned by the revision files are in dir along with
+// their assertions, that the snap-revision assertions match the snap files, and
+// that the snaps are built for this host.
+func Verify(root vfs.FS, dir string) Report {
+	report := Report{Dir: dir}
+	for _, name := range Snaps {
+		report.Snaps = append(report.Snaps, verifySnap(root, dir, name))
+	}
+	return report
+}
+
+func verifySnap(root vfs.FS, dir, name string) SnapReport {
+	report := SnapReport{Name: name}
+
+	revision, err := ReadRevision(root, name)
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	report.Revision = revision
+
+	snap, assertion, err := findSnapFiles(root, dir, name, revision)
+	report.Snap, report.Assertion = snap, assertion
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+
+	if err = verifyAssertion(root, snap, assertion, revision); err != nil {
+		report.Error = err.Error()
+		return report
+	}
+
+	archs, err := SnapArchitectures(root, snap)
+	if err != nil {
+		report.Warning = "could not check the architecture: " + err.Error()
+		return report
+	}
+	report.Architectures = archs
+	if arch := HostArchitecture(); !SupportsArchitecture(archs, arch) {
+		report.Error = "built for " + strings.Join(archs, ", ") + ", this node is " + arch
+	}
+	return report
+}
+
+// findSnapFiles returns the snap and assertion file of a revision, matching the
+// file names install_snapd, install_core and install_k8s expect.
+func findSnapFiles(root vfs.FS, dir, name, revision string) (string, string, error) {
+	pattern := name + "_" + revision
+	if name == "core" {
+		pattern = "core*_" + revision
+	}
+
+	entries, err := root.ReadDir(dir)
+	if err != nil {
+		return "", "", errors.Wrap(err, "failed to read the snap bundle")
+	}
+
+	var snap, assertion string
+	for _, entry := range entries {
+		if ok, _ := filepath.Match(pattern+".snap", entry.Name()); ok && snap == "" {
+			snap = filepath.Join(dir, entry.Name())
+		}
+		if ok, _ := filepath.Match(pattern+".assert", entry.Name()); ok && assertion == "" {
+			assertion = filepath.Join(dir, entry.Name())
+		}
+	}
+
+	switch {
+	case snap == "" && assertion == "":
+		return "", "", errors.Errorf("%s.snap and %s.assert not found in %s", pattern, pattern, dir)
+	case snap == "":
+		return "", assertion, errors.Errorf("%s.snap not found in %s", pattern, dir)
+	case assertion == "":
+		return snap, "", errors.Errorf("%s.assert not found in %s", pattern, dir)
+	}
+	return snap, assertion, nil
+}
+
+// verifyAssertion checks the snap file against the digest and size of its
+// snap-revision assertion. The assertion signatures are left to `snap ack`.
+func verifyAssertion(root vfs.FS, snap, assertion, revision string) error {
+	content, err := root.Open(assertion)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	headers, err := findSnapRevisionAssertion(content, revision)
+	if err != nil {
+		return errors.Wrapf(err, "invalid assertion %s", assertion)
+	}
+
+	f, err := root.Open(snap)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	hash := sha3.New384()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read %s", snap)
+	}
+
+	if expected := headers["snap-size"]; expected != strconv.FormatInt(size, 10) {
+		return errors.Errorf("%s is %d bytes, its assertion expects %s", snap, size, expected)
+	}
+	if digest := base64.RawURLEncoding.EncodeToString(hash.Sum(nil)); digest != headers["snap-sha3-384"] {
+		return errors.Errorf("%s does not match the sha3-384 digest of its assertion", snap)
+	}
+	return nil
+}
+
+// findSnapRevisionAssertion returns the headers of the snap-revision assertion of
+// revision in a stream of assertions as written by `snap download`.
+func findSnapRevisionAssertion(r io.Reader, revision string) (map[string]string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var headers map[string]string
+	inHeaders := true
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if line == "" {
+			// headers end at the first blank line, the body and signature follow
+			if inHeaders && headers["type"] == "snap-revision" && headers["snap-revision"] == revision {
+				return headers, nil
+			}
+			inHeaders = false
+			continue
+		}
+
+		if strings.HasPrefix(line, "type: ") {
+			headers = map[string]string{}
+			inHeaders = true
+		}
+		if !inHeaders || headers == nil {
+			continue
+		}
+		if key, value, ok := strings.Cut(line, ": "); ok && !strings.HasPrefix(line, " ") {
+			headers[key] = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, errors.Errorf("no snap-revision assertion for revision %s", revision)
+}
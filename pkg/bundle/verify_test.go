@@ -0,0 +1,129 @@
+package bundle
+
+import (
+	"crypto/sha3"
+	"encoding/base64"
+	"fmt"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4/vfst"
+)
+
+func TestVerify(t *testing.T) {
+	g := NewWithT(t)
+
+	snap := func(name, arch string) string {
+		return string(testSquashfs(g, "meta", "snap.yaml", fmt.Sprintf("name: %s\narchitectures:\n  - %s\n", name, arch), false))
+	}
+	hostSnaps := map[string]string{
+		"snapd": snap("snapd", HostArchitecture()),
+		"core":  snap("core22", HostArchitecture()),
+		"k8s":   snap("k8s", HostArchitecture()),
+	}
+
+	bundleFiles := func(snaps map[string]string) map[string]interface{} {
+		return map[string]interface{}{
+			"/opt/canonical/revision/snapd.revision": "21759",
+			"/opt/canonical/revision/k8s.revision":   "3120",
+			"/opt/canonical/core.revision":           "1564",
+			"/opt/canonical-k8s/snapd_21759.snap":    snaps["snapd"],
+			"/opt/canonical-k8s/snapd_21759.assert":  testAssertion(snaps["snapd"], "21759"),
+			"/opt/canonical-k8s/core22_1564.snap":    snaps["core"],
+			"/opt/canonical-k8s/core22_1564.assert":  testAssertion(snaps["core"], "1564"),
+			"/opt/canonical-k8s/k8s_3120.snap":       snaps["k8s"],
+			"/opt/canonical-k8s/k8s_3120.assert":     testAssertion(snaps["k8s"], "3120"),
+		}
+	}
+
+	t.Run("verifies a complete bundle", func(t *testing.T) {
+		testFS, cleanup, err := vfst.NewTestFS(bundleFiles(hostSnaps))
+		g.Expect(err).NotTo(HaveOccurred())
+		defer cleanup()
+
+		report := Verify(testFS, "/opt/canonical-k8s")
+		g.Expect(report.OK()).To(BeTrue(), "%+v", report)
+		g.Expect(report.Snaps).To(HaveLen(3))
+		g.Expect(report.Snaps[1].Snap).To(Equal("/opt/canonical-k8s/core22_1564.snap"))
+		g.Expect(report.Snaps[2].Architectures).To(Equal([]string{HostArchitecture()}))
+	})
+
+	t.Run("reports missing snaps", func(t *testing.T) {
+		files := bundleFiles(hostSnaps)
+		delete(files, "/opt/canonical-k8s/k8s_3120.snap")
+		delete(files, "/opt/canonical-k8s/core22_1564.assert")
+		testFS, cleanup, err := vfst.NewTestFS(files)
+		g.Expect(err).NotTo(HaveOccurred())
+		defer cleanup()
+
+		report := Verify(testFS, "/opt/canonical-k8s")
+		g.Expect(report.OK()).To(BeFalse())
+		g.Expect(report.Snaps[0].Error).To(BeEmpty())
+		g.Expect(report.Snaps[1].Error).To(Equal("core*_1564.assert not found in /opt/canonical-k8s"))
+		g.Expect(report.Snaps[2].Error).To(Equal("k8s_3120.snap not found in /opt/canonical-k8s"))
+	})
+
+	t.Run("reports snaps not matching their assertion", func(t *testing.T) {
+		files := bundleFiles(hostSnaps)
+		tampered := []byte(hostSnaps["k8s"])
+		tampered[len(tampered)-1] ^= 0xff
+		files["/opt/canonical-k8s/k8s_3120.snap"] = string(tampered)
+		files["/opt/canonical-k8s/core22_1564.assert"] = testAssertion(hostSnaps["core"]+"x", "1564")
+		files["/opt/canonical-k8s/snapd_21759.assert"] = testAssertion(hostSnaps["snapd"], "21000")
+		testFS, cleanup, err := vfst.NewTestFS(files)
+		g.Expect(err).NotTo(HaveOccurred())
+		defer cleanup()
+
+		report := Verify(testFS, "/opt/canonical-k8s")
+		g.Expect(report.Snaps[0].Error).To(ContainSubstring("no snap-revision assertion for revision 21759"))
+		g.Expect(report.Snaps[1].Error).To(ContainSubstring("its assertion expects"))
+		g.Expect(report.Snaps[2].Error).To(ContainSubstring("does not match the sha3-384 digest"))
+	})
+
+	t.Run("reports snaps built for another architecture", func(t *testing.T) {
+		other := "s390x"
+		if HostArchitecture() == other {
+			other = "riscv64"
+		}
+		snaps := map[string]string{
+			"snapd": hostSnaps["snapd"],
+			"core":  hostSnaps["core"],
+			"k8s":   snap("k8s", other),
+		}
+		testFS, cleanup, err := vfst.NewTestFS(bundleFiles(snaps))
+		g.Expect(err).NotTo(HaveOccurred())
+		defer cleanup()
+
+		report := Verify(testFS, "/opt/canonical-k8s")
+		g.Expect(report.Snaps[2].Error).To(Equal(fmt.Sprintf("built for %s, this node is %s", other, HostArchitecture())))
+	})
+}
+
+// testAssertion returns a `snap download` style assertion stream, with an
+// account-key and the snap-revision assertion of content.
+func testAssertion(content, revision string) string {
+	digest := sha3.Sum384([]byte(content))
+	return fmt.Sprintf(`type: account-key
+authority-id: canonical
+public-key-sha3-384: BWDEoaqyr25nF5SNCvEv2v7QnM9QsfCc0PBMYD_i2NGSQ32EF2d4D0hqUel3m8ul
+account-id: canonical
+body-length: 12
+sign-key-sha3-384: -CvQKAwRQ5h3Ffn10FILJoEZUXOv6km9FwA80-Rcj-f-6jadQ89VRswHNiEB9Lxk
+
+AcbBTQRWhcGA
+
+AcLBUgQAAQoABgUCV4ZcwAAK
+
+type: snap-revision
+authority-id: canonical
+snap-sha3-384: %s
+developer-id: canonical
+snap-id: 99T7MUlRhtI3U0QFgl5mXXESAiSwt776
+snap-revision: %s
+snap-size: %d
+timestamp: 2026-01-12T10:15:00.000000Z
+sign-key-sha3-384: BWDEoaqyr25nF5SNCvEv2v7QnM9QsfCc0PBMYD_i2NGSQ32EF2d4D0hqUel3m8ul
+
+AcLBUgQAAQoABgUCZ4NbpAAKCRDgT5vottzAEgN7EACq
+`, base64.RawURLEncoding.EncodeToString(digest[:]), revision, len(content))
+}
@@ -0,0 +1,36 @@
+package cli
+
+import (
+	"flag"
+	"time"
+
+	"github.com/kairos-io/provider-canonical/pkg/backup"
+	"github.com/kairos-io/provider-canonical/pkg/domain"
+	"github.com/kairos-io/provider-canonical/pkg/fs"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+func runBackup(args []string) error {
+	flags := flag.NewFlagSet("backup", flag.ContinueOnError)
+	dir := flags.String("dir", domain.DefaultBackupDir, "directory to write the backup archives to")
+	retention := flags.Int("retention", domain.DefaultBackupRetention, "number of backup archives to keep")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	archive, err := backup.Create(fs.OSFS, *dir, time.Now(), backup.ExecRunner)
+	if err != nil {
+		return errors.Wrap(err, "failed to create backup")
+	}
+	logrus.Infof("created backup %s", archive)
+
+	pruned, err := backup.Prune(fs.OSFS, *dir, *retention)
+	if err != nil {
+		return errors.Wrap(err, "failed to prune backups")
+	}
+	for _, archive := range pruned {
+		logrus.Infof("pruned backup %s", archive)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+	"path/filepath"
+	"reflect"
+	"time"
+
+	"github.com/kairos-io/provider-canonical/pkg/bundle"
+	"github.com/kairos-io/provider-canonical/pkg/domain"
+	"github.com/kairos-io/provider-canonical/pkg/fs"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/twpayne/go-vfs/v4"
+)
+
+// runVerifyBundle verifies the airgap snap bundle. With --wait it keeps verifying
+// until the bundle is complete, only reporting the findings when they change.
+func runVerifyBundle(args []string) error {
+	flags := flag.NewFlagSet("verify-bundle", flag.ContinueOnError)
+	dir := flags.String("dir", domain.SnapBundleDir, "directory holding the snaps and their assertions")
+	wait := flags.Bool("wait", false, "wait until the bundle is complete")
+	interval := flags.Duration("interval", 10*time.Second, "delay between verifications when waiting")
+	timeout := flags.Duration("timeout", 0, "give up waiting after this long, 0 to wait forever")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	deadline := time.Now().Add(*timeout)
+
+	var previous bundle.Report
+	for {
+		report := bundle.Verify(fs.OSFS, *dir)
+		if !reflect.DeepEqual(report, previous) {
+			if err := writeBundleReport(report); err != nil {
+				return err
+			}
+			previous = report
+		}
+
+		if report.OK() {
+			return nil
+		}
+		if !*wait {
+			return errors.Errorf("snap bundle in %s is incomplete or corrupt, see %s", *dir, domain.BundleReportPath)
+		}
+		if *timeout > 0 && time.Now().Add(*interval).After(deadline) {
+			return errors.Errorf("snap bundle in %s is still incomplete or corrupt after %s, see %s", *dir, *timeout, domain.BundleReportPath)
+		}
+		time.Sleep(*interval)
+	}
+}
+
+func writeBundleReport(report bundle.Report) error {
+	for _, snap := range report.Snaps {
+		switch {
+		case snap.Error != "":
+			logrus.Errorf("snap bundle: %s: %s", snap.Name, snap.Error)
+		case snap.Warning != "":
+			logrus.Warnf("snap bundle: %s revision %s: %s", snap.Name, snap.Revision, snap.Warning)
+		default:
+			logrus.Infof("snap bundle: %s revision %s verified", snap.Name, snap.Revision)
+		}
+	}
+	content, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = vfs.MkdirAll(fs.OSFS, filepath.Dir(domain.BundleReportPath), 0755); err != nil {
+		return err
+	}
+	if err = fs.OSFS.WriteFile(domain.BundleReportPath, append(content, '\n'), 0644); err != nil {
+		return errors.Wrap(err, "failed to write the snap bundle report")
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+package cli
+
+// Commands are the provider subcommands invoked on the node by the generated
+// stages and systemd units, as opposed to the cluster plugin events handled
+// through go-pluggable.
+var Commands = map[string]func(args []string) error{
+	"backup":             runBackup,
+	"diagnose-join":      runDiagnoseJoin,
+	"import-images":      runImportImages,
+	"join-cluster":       runJoinCluster,
+	"log-output":         runLogOutput,
+	"preflight":          runPreflight,
+	"record-phase":       runRecordPhase,
+	"refresh-join-token": runRefreshJoinToken,
+	"restore":            runRestore,
+	"run-phase":          runRunPhase,
+	"verify-bundle":      runVerifyBundle,
+}
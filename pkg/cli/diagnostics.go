@@ -0,0 +1,88 @@
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/kairos-io/provider-canonical/pkg/diagnostics"
+	"github.com/kairos-io/provider-canonical/pkg/domain"
+	"github.com/kairos-io/provider-canonical/pkg/fs"
+	"github.com/kairos-io/provider-canonical/pkg/log"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/twpayne/go-vfs/v4"
+)
+
+// runDiagnoseJoin checks that the node can reach the cluster it joins. With --wait
+// it keeps checking until it can, printing the status whenever it changes.
+func runDiagnoseJoin(args []string) error {
+	flags := flag.NewFlagSet("diagnose-join", flag.ContinueOnError)
+	tokenFile := flags.String("token-file", domain.JoinTokenPath, "file holding the join token")
+	address := flags.String("address", "", "address the node advertises")
+	wait := flags.Bool("wait", false, "wait until the node can reach the cluster")
+	interval := flags.Duration("interval", 10*time.Second, "delay between diagnostics when waiting")
+	timeout := flags.Duration("timeout", 0, "give up waiting after this long, 0 to wait forever")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	deadline := time.Now().Add(*timeout)
+
+	previous := ""
+	for {
+		content, err := fs.OSFS.ReadFile(*tokenFile)
+		if err != nil {
+			return errors.Wrap(err, "failed to read the join token")
+		}
+		log.AddSecret(string(content))
+
+		report := diagnostics.DiagnoseJoin(diagnostics.Env{
+			Token:            string(content),
+			AdvertiseAddress: *address,
+		})
+		if err = writeJoinDiagnostics(report); err != nil {
+			return err
+		}
+
+		if status := report.Status(); status != previous {
+			if report.Ready() {
+				logrus.Info(status)
+			} else {
+				logrus.Warn(status)
+			}
+			fmt.Println(status)
+			previous = status
+		}
+
+		if report.Ready() {
+			return nil
+		}
+		if len(report.JoinAddresses) == 0 {
+			// waiting doesn't help, the token is only replaced by a join attempt
+			return errors.Errorf("the join token cannot be read, see %s", domain.JoinDiagnosticsPath)
+		}
+		if !*wait {
+			return errors.Errorf("the node cannot reach the cluster, see %s", domain.JoinDiagnosticsPath)
+		}
+		if *timeout > 0 && time.Now().Add(*interval).After(deadline) {
+			return errors.Errorf("the node still cannot reach the cluster after %s, see %s", *timeout, domain.JoinDiagnosticsPath)
+		}
+		time.Sleep(*interval)
+	}
+}
+
+func writeJoinDiagnostics(report diagnostics.Report) error {
+	content, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = vfs.MkdirAll(fs.OSFS, filepath.Dir(domain.JoinDiagnosticsPath), 0755); err != nil {
+		return err
+	}
+	if err = fs.OSFS.WriteFile(domain.JoinDiagnosticsPath, append(content, '\n'), 0644); err != nil {
+		return errors.Wrap(err, "failed to write the join diagnostics")
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package cli
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kairos-io/provider-canonical/pkg/diagnostics"
+	"github.com/kairos-io/provider-canonical/pkg/domain"
+	"github.com/kairos-io/provider-canonical/pkg/fs"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4/vfst"
+)
+
+func TestRunDiagnoseJoin(t *testing.T) {
+	g := NewWithT(t)
+
+	tlsServer := httptest.NewTLSServer(http.NotFoundHandler())
+	defer tlsServer.Close()
+	sum := sha256.Sum256(tlsServer.Certificate().Raw)
+	fingerprint := hex.EncodeToString(sum[:])
+
+	closedListener, err := net.Listen("tcp", "127.0.0.1:0")
+	g.Expect(err).NotTo(HaveOccurred())
+	closedAddress := closedListener.Addr().String()
+	g.Expect(closedListener.Close()).To(Succeed())
+
+	joinToken := func(address string) string {
+		content := fmt.Sprintf(`{"token":"s3cr3t","join_addresses":[%q],"fingerprint":%q}`, address, fingerprint)
+		return base64.StdEncoding.EncodeToString([]byte(content))
+	}
+
+	useTestFS := func(t *testing.T, token string) {
+		root := map[string]interface{}{"/run/provider-canonical": &vfst.Dir{Perm: 0700}}
+		if token != "" {
+			root[domain.JoinTokenPath] = token
+		}
+		testFS, cleanup, err := vfst.NewTestFS(root)
+		g.Expect(err).NotTo(HaveOccurred())
+		t.Cleanup(cleanup)
+
+		originalFS := fs.OSFS
+		fs.OSFS = testFS
+		t.Cleanup(func() { fs.OSFS = originalFS })
+	}
+
+	readReport := func() diagnostics.Report {
+		content, err := fs.OSFS.ReadFile(domain.JoinDiagnosticsPath)
+		g.Expect(err).NotTo(HaveOccurred())
+
+		var report diagnostics.Report
+		g.Expect(json.Unmarshal(content, &report)).To(Succeed())
+		return report
+	}
+
+	t.Run("succeeds once a join address is reachable", func(t *testing.T) {
+		useTestFS(t, joinToken(tlsServer.Listener.Addr().String()))
+
+		g.Expect(runDiagnoseJoin(nil)).To(Succeed())
+
+		report := readReport()
+		g.Expect(report.Version).To(Equal(diagnostics.ReportVersion))
+		g.Expect(report.JoinAddresses).To(HaveLen(1))
+		g.Expect(report.JoinAddresses[0].TLS).To(Equal(diagnostics.StatusOK))
+	})
+
+	t.Run("fails without waiting when the cluster is unreachable", func(t *testing.T) {
+		useTestFS(t, joinToken(closedAddress))
+
+		err := runDiagnoseJoin(nil)
+
+		g.Expect(err).To(MatchError("the node cannot reach the cluster, see " + domain.JoinDiagnosticsPath))
+		g.Expect(readReport().JoinAddresses[0].TCP).To(Equal(diagnostics.StatusFailed))
+	})
+
+	t.Run("gives up waiting after the timeout", func(t *testing.T) {
+		useTestFS(t, joinToken(closedAddress))
+
+		err := runDiagnoseJoin([]string{"--wait", "--interval", "10ms", "--timeout", "50ms"})
+
+		g.Expect(err).To(MatchError("the node still cannot reach the cluster after 50ms, see " + domain.JoinDiagnosticsPath))
+	})
+
+	t.Run("does not wait on a token it cannot read", func(t *testing.T) {
+		useTestFS(t, "not a token")
+
+		start := time.Now()
+		err := runDiagnoseJoin([]string{"--wait", "--interval", "1h"})
+
+		g.Expect(err).To(MatchError("the join token cannot be read, see " + domain.JoinDiagnosticsPath))
+		g.Expect(time.Since(start)).To(BeNumerically("<", time.Minute))
+		g.Expect(readReport().Token.Status).To(Equal(diagnostics.StatusFailed))
+	})
+
+	t.Run("fails without a token file", func(t *testing.T) {
+		useTestFS(t, "")
+
+		err := runDiagnoseJoin(nil)
+
+		g.Expect(err).To(MatchError(ContainSubstring("failed to read the join token")))
+	})
+}
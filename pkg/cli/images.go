@@ -0,0 +1,39 @@
+package cli
+
+import (
+	"flag"
+
+	"github.com/kairos-io/provider-canonical/pkg/domain"
+	"github.com/kairos-io/provider-canonical/pkg/fs"
+	"github.com/kairos-io/provider-canonical/pkg/images"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+func runImportImages(args []string) error {
+	flags := flag.NewFlagSet("import-images", flag.ContinueOnError)
+	source := flags.String("source", domain.DefaultLocalImagesDir, "directory holding the local images")
+	policy := flags.String("signature-policy", domain.SignaturePolicyOff, "whether image signatures are enforced, only warned about or not checked")
+	publicKey := flags.String("public-key", domain.ImageSigningKeyPath, "PEM public key to verify the image signatures with")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	verifier, err := images.NewVerifier(fs.OSFS, *policy, *publicKey)
+	if err != nil && *policy == domain.SignaturePolicyEnforce {
+		return errors.Wrap(err, "refusing to import unverified images")
+	} else if err != nil {
+		logrus.Warnf("not verifying image signatures: %v", err)
+		verifier = images.Verifier{}
+	}
+
+	report, err := images.Import(fs.OSFS, *source, verifier)
+	report.Log()
+	if err != nil {
+		return errors.Wrapf(err, "failed to import images from %s", *source)
+	}
+	if len(report.Failed) > 0 {
+		return errors.Errorf("%d images failed to import", len(report.Failed))
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	apiv1 "github.com/canonical/k8s-snap-api/api/v1"
+	"github.com/kairos-io/provider-canonical/pkg/domain"
+	"github.com/kairos-io/provider-canonical/pkg/fs"
+	"github.com/kairos-io/provider-canonical/pkg/k8sd"
+	"github.com/kairos-io/provider-canonical/pkg/log"
+	"github.com/kairos-io/provider-canonical/pkg/utils"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// k8sdPort is the port of the k8sd API the other nodes reach the joining node on.
+const k8sdPort = "6400"
+
+// runJoinCluster is k8s join-cluster with the token read from a file rather than
+// taken as an argument, where ps and the journal would show it.
+func runJoinCluster(args []string) error {
+	flags := flag.NewFlagSet("join-cluster", flag.ContinueOnError)
+	tokenFile := flags.String("token-file", domain.JoinTokenPath, "file holding the join token")
+	configFile := flags.String("file", "", "join config of the node")
+	address := flags.String("address", "", "address the node advertises, the default route address if empty")
+	name := flags.String("name", "", "name of the node, the hostname if empty")
+	timeout := flags.Duration("timeout", 90*time.Second, "how long to wait for the join to complete")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	content, err := fs.OSFS.ReadFile(*tokenFile)
+	if err != nil {
+		return errors.Wrap(err, "failed to read the join token")
+	}
+	token := strings.TrimSpace(string(content))
+	if token == "" {
+		return errors.Errorf("join token file %s is empty", *tokenFile)
+	}
+	log.AddSecret(token)
+
+	request := apiv1.JoinClusterRequest{Name: *name, Token: token, Timeout: *timeout}
+	if *configFile != "" {
+		config, err := fs.OSFS.ReadFile(*configFile)
+		if err != nil {
+			return errors.Wrap(err, "failed to read the join config")
+		}
+		request.Config = string(config)
+	}
+
+	if request.Name == "" {
+		if request.Name, err = os.Hostname(); err != nil {
+			return errors.Wrap(err, "failed to read the hostname")
+		}
+	}
+	if request.Address, err = joinAddress(*address); err != nil {
+		return err
+	}
+
+	logrus.Infof("joining the cluster as %s on %s", request.Name, request.Address)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout+30*time.Second)
+	defer cancel()
+	return k8sd.NewClient(domain.K8sdSocketPath).JoinCluster(ctx, request)
+}
+
+// joinAddress returns the address and k8sd port the node advertises.
+func joinAddress(address string) (string, error) {
+	if address == "" {
+		addr, err := utils.DefaultAddress()
+		if err != nil {
+			return "", errors.Wrap(err, "failed to find the address to advertise")
+		}
+		address = addr.String()
+	}
+
+	if addr, err := netip.ParseAddr(strings.Trim(address, "[]")); err == nil {
+		return net.JoinHostPort(addr.String(), k8sdPort), nil
+	}
+	if _, _, err := net.SplitHostPort(address); err == nil {
+		return address, nil
+	}
+	return net.JoinHostPort(address, k8sdPort), nil
+}
@@ -0,0 +1,25 @@
+package cli
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestJoinAddress(t *testing.T) {
+	g := NewWithT(t)
+
+	for address, expected := range map[string]string{
+		"10.0.0.1":                "10.0.0.1:6400",
+		"fd00::1":                 "[fd00::1]:6400",
+		"[fd00::1]":               "[fd00::1]:6400",
+		"10.0.0.1:7000":           "10.0.0.1:7000",
+		"[fd00::1]:7000":          "[fd00::1]:7000",
+		"node-1.example.com":      "node-1.example.com:6400",
+		"node-1.example.com:7000": "node-1.example.com:7000",
+	} {
+		actual, err := joinAddress(address)
+		g.Expect(err).NotTo(HaveOccurred(), address)
+		g.Expect(actual).To(Equal(expected), address)
+	}
+}
@@ -0,0 +1,61 @@
+package cli
+
+import (
+	"flag"
+	"io"
+	"os"
+
+	"github.com/kairos-io/provider-canonical/pkg/domain"
+	"github.com/kairos-io/provider-canonical/pkg/log"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// The output streams of a script, and the level their lines are logged at.
+var streamLevels = map[string]logrus.Level{
+	"stdout": logrus.InfoLevel,
+	"stderr": logrus.WarnLevel,
+	"trace":  logrus.DebugLevel,
+}
+
+// runLogOutput logs a stream of a script, read from stdin, line by line in the
+// provider log, tagged with the phase of the script. With --echo the stream is
+// copied to stdout as well, and with --file appended to the log file the scripts
+// of older releases wrote.
+func runLogOutput(args []string) error {
+	flags := flag.NewFlagSet("log-output", flag.ContinueOnError)
+	phase := flags.String("phase", "", "phase of the script")
+	stream := flags.String("stream", "stdout", "stream of the script: stdout, stderr or trace")
+	echo := flags.Bool("echo", false, "copy the stream to stdout")
+	file := flags.String("file", "", "log file to append the stream to as well")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	level, ok := streamLevels[*stream]
+	if !ok {
+		return errors.Errorf("unknown stream %q", *stream)
+	}
+	log.SetPhase(*phase)
+	// The secrets the script learns are registered in the processes that read
+	// them, not in this one, so the join token is read here too.
+	log.AddSecretFile(domain.JoinTokenPath)
+
+	lines := log.NewLineWriter(logrus.WithField("stream", *stream), level)
+	defer lines.Flush()
+	writers := []io.Writer{lines}
+	if *echo {
+		writers = append(writers, os.Stdout)
+	}
+	if *file != "" {
+		f, err := os.OpenFile(*file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			logrus.Warnf("failed to open %s, logging the %s of the script only to the provider log: %v", *file, *stream, err)
+		} else {
+			defer f.Close()
+			writers = append(writers, f)
+		}
+	}
+
+	_, err := io.Copy(io.MultiWriter(writers...), os.Stdin)
+	return err
+}
@@ -0,0 +1,52 @@
+package cli
+
+import (
+	"flag"
+	"os"
+
+	"github.com/kairos-io/provider-canonical/pkg/domain"
+	"github.com/kairos-io/provider-canonical/pkg/fs"
+	"github.com/kairos-io/provider-canonical/pkg/preflight"
+	"github.com/pkg/errors"
+)
+
+func runPreflight(args []string) error {
+	flags := flag.NewFlagSet("preflight", flag.ContinueOnError)
+	role := flags.String("role", "", "role of the node")
+	controlPlaneHost := flags.String("control-plane-host", "", "address of the cluster the node joins")
+	allowSwap := flags.Bool("allow-swap", false, "whether the kubelet tolerates swap")
+	advertiseInterface := flags.String("advertise-interface", "", "interface to resolve the advertise address from")
+	advertiseCidr := flags.String("advertise-cidr", "", "CIDR to resolve the advertise address within")
+	advertiseFamily := flags.String("advertise-ip-family", "", "preferred IP family of the advertise address")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	advertise, err := domain.ParseAdvertiseSelector(*advertiseInterface, *advertiseCidr, *advertiseFamily)
+	if err != nil {
+		return err
+	}
+
+	report := preflight.Run(preflight.Env{
+		Root:             fs.OSFS,
+		Role:             *role,
+		ControlPlaneHost: *controlPlaneHost,
+		AllowSwap:        *allowSwap,
+		Advertise:        advertise,
+	})
+	if err := preflight.WriteReport(fs.OSFS, domain.PreflightReportPath, report); err != nil {
+		return errors.Wrap(err, "failed to write preflight report")
+	}
+
+	if !report.Fatal() {
+		if err := fs.OSFS.Remove(domain.PreflightFailedPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	if err := fs.OSFS.WriteFile(domain.PreflightFailedPath, nil, 0644); err != nil {
+		return errors.Wrap(err, "failed to mark preflight as failed")
+	}
+	return errors.Errorf("preflight checks failed, see %s", domain.PreflightReportPath)
+}
@@ -0,0 +1,30 @@
+package cli
+
+import (
+	"flag"
+
+	"github.com/kairos-io/provider-canonical/pkg/backup"
+	"github.com/kairos-io/provider-canonical/pkg/fs"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+func runRestore(args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	archive := flags.String("archive", "", "backup archive to restore")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *archive == "" {
+		return errors.New("no backup archive given")
+	}
+
+	dirs, err := backup.Restore(fs.OSFS, *archive, backup.ExecRunner)
+	if err != nil {
+		return errors.Wrapf(err, "failed to restore backup %s", *archive)
+	}
+	for _, dir := range dirs {
+		logrus.Infof("restored %s from %s", dir, *archive)
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package cli
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/kairos-io/provider-canonical/pkg/fs"
+	"github.com/kairos-io/provider-canonical/pkg/log"
+	"github.com/kairos-io/provider-canonical/pkg/metrics"
+	"github.com/kairos-io/provider-canonical/pkg/status"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// runRecordPhase records the state of a provisioning phase in the node status,
+// and renders the metrics of the node when given a metrics dir.
+func runRecordPhase(args []string) error {
+	flags := flag.NewFlagSet("record-phase", flag.ContinueOnError)
+	phase := flags.String("phase", "", "name of the phase")
+	state := flags.String("state", "", "state of the phase: waiting, running, succeeded or failed")
+	message := flags.String("error", "", "error the phase failed with")
+	configHash := flags.String("config-hash", "", "hash of the provider config a starting phase runs with")
+	retries := flags.Int("retries", 0, "retries of a finished phase")
+	metricsDir := flags.String("metrics-dir", "", "textfile collector dir to render the metrics in")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	log.SetPhase(*phase)
+
+	return recordPhase(status.Update{
+		Phase:      *phase,
+		State:      *state,
+		Error:      *message,
+		ConfigHash: *configHash,
+		Retries:    *retries,
+	}, *metricsDir)
+}
+
+// runRunPhase runs the command after "--" as part of a provisioning phase, logging
+// its output line by line, and records the phase as failed when the command fails,
+// with the last line of its error output.
+func runRunPhase(args []string) error {
+	flags := flag.NewFlagSet("run-phase", flag.ContinueOnError)
+	phase := flags.String("phase", "", "name of the phase")
+	metricsDir := flags.String("metrics-dir", "", "textfile collector dir to render the metrics in")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	command := flags.Args()
+	if len(command) == 0 {
+		return errors.New("no command to run")
+	}
+	log.SetPhase(*phase)
+
+	stdout := log.NewLineWriter(logrus.WithField("stream", "stdout"), streamLevels["stdout"])
+	stderr := log.NewLineWriter(logrus.WithField("stream", "stderr"), streamLevels["stderr"])
+	lastLine := &lastLineWriter{}
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = io.MultiWriter(os.Stdout, stdout)
+	cmd.Stderr = io.MultiWriter(os.Stderr, stderr, lastLine)
+	err := cmd.Run()
+	stdout.Flush()
+	stderr.Flush()
+	if err == nil {
+		return nil
+	}
+
+	message := strings.Join(command, " ") + " failed: " + err.Error()
+	if line := lastLine.Last(); line != "" {
+		message += ": " + line
+	}
+	if recordErr := recordPhase(status.Update{Phase: *phase, State: status.StateFailed, Error: message}, *metricsDir); recordErr != nil {
+		logrus.Warnf("failed to record the %s phase: %v", *phase, recordErr)
+	}
+	return err
+}
+
+func recordPhase(update status.Update, metricsDir string) error {
+	current, err := status.Load(fs.OSFS, status.Paths...)
+	if err != nil {
+		logrus.Warnf("starting over from an empty status: %v", err)
+	}
+
+	update.Error = log.Redact(update.Error)
+	update.K8sRevision = status.InstalledK8sRevision(fs.OSFS)
+	update.Time = time.Now()
+	if err = current.Record(update); err != nil {
+		return err
+	}
+
+	if update.State == status.StateFailed {
+		logrus.Errorf("%s failed: %s", update.Phase, update.Error)
+	} else {
+		logrus.Infof("%s %s", update.Phase, update.State)
+	}
+	if err = current.Save(fs.OSFS, status.Paths...); err != nil {
+		return errors.Wrap(err, "failed to record the phase")
+	}
+
+	if metricsDir != "" {
+		if err = metrics.Write(fs.OSFS, metricsDir, current); err != nil {
+			logrus.Warnf("failed to render the metrics: %v", err)
+		}
+	}
+	return nil
+}
+
+// lastLineWriter keeps the last non-empty line written to it.
+type lastLineWriter struct {
+	last    string
+	partial []byte
+}
+
+func (w *lastLineWriter) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		if line := strings.TrimSpace(string(w.partial[:i])); line != "" {
+			w.last = line
+		}
+		w.partial = w.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+func (w *lastLineWriter) Last() string {
+	if line := strings.TrimSpace(string(w.partial)); line != "" {
+		return line
+	}
+	return w.last
+}
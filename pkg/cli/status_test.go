@@ -0,0 +1,33 @@
+package cli
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestLastLineWriter(t *testing.T) {
+	g := NewWithT(t)
+
+	t.Run("keeps the last non-empty line across writes", func(t *testing.T) {
+		w := &lastLineWriter{}
+		for _, chunk := range []string{"first line\nsecond ", "line\n", "  \n", "\n"} {
+			n, err := w.Write([]byte(chunk))
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(n).To(Equal(len(chunk)))
+		}
+
+		g.Expect(w.Last()).To(Equal("second line"))
+	})
+
+	t.Run("returns a last line without newline", func(t *testing.T) {
+		w := &lastLineWriter{}
+		_, _ = w.Write([]byte("error: first\nerror: unterminated"))
+
+		g.Expect(w.Last()).To(Equal("error: unterminated"))
+	})
+
+	t.Run("is empty when nothing was written", func(t *testing.T) {
+		g.Expect((&lastLineWriter{}).Last()).To(BeEmpty())
+	})
+}
@@ -0,0 +1,82 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/kairos-io/provider-canonical/pkg/domain"
+	"github.com/kairos-io/provider-canonical/pkg/fs"
+	"github.com/kairos-io/provider-canonical/pkg/log"
+	"github.com/kairos-io/provider-canonical/pkg/token"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const tokenRefreshTimeout = 2 * time.Minute
+
+// runRefreshJoinToken replaces the join token with a new one from the configured
+// token refresh source, for the join to retry with.
+func runRefreshJoinToken(args []string) error {
+	flags := flag.NewFlagSet("refresh-join-token", flag.ContinueOnError)
+	configFile := flags.String("config", domain.TokenRefreshConfigPath, "token refresh source of the node")
+	tokenFile := flags.String("token-file", domain.JoinTokenPath, "file holding the join token")
+	role := flags.String("role", "", "role of the node")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var config domain.TokenRefreshConfig
+	content, err := fs.OSFS.ReadFile(*configFile)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to read the token refresh config")
+	}
+	if err == nil {
+		if err = json.Unmarshal(content, &config); err != nil {
+			return errors.Wrap(err, "invalid token refresh config")
+		}
+	}
+
+	refresher, err := token.NewRefresher(fs.OSFS, config)
+	if err != nil {
+		return err
+	}
+
+	current, err := fs.OSFS.ReadFile(*tokenFile)
+	if err != nil {
+		return errors.Wrap(err, "failed to read the join token")
+	}
+	log.AddSecret(string(current))
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return errors.Wrap(err, "failed to read the hostname")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), tokenRefreshTimeout)
+	defer cancel()
+	refreshed, err := refresher.Refresh(ctx, token.Request{
+		Token:    strings.TrimSpace(string(current)),
+		NodeName: hostname,
+		NodeRole: *role,
+	})
+	if err != nil {
+		return err
+	}
+	log.AddSecret(refreshed)
+
+	tmp := filepath.Join(filepath.Dir(*tokenFile), "."+filepath.Base(*tokenFile)+".tmp")
+	if err = fs.OSFS.WriteFile(tmp, []byte(refreshed), 0600); err != nil {
+		return errors.Wrap(err, "failed to write the join token")
+	}
+	if err = fs.OSFS.Rename(tmp, *tokenFile); err != nil {
+		return errors.Wrap(err, "failed to replace the join token")
+	}
+
+	logrus.Infof("refreshed the join token from the %s source", config.Source)
+	return nil
+}
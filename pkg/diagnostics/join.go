@@ -0,0 +1,246 @@
+package diagnostics
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/kairos-io/provider-canonical/pkg/token"
+	"github.com/pkg/errors"
+)
+
+// ReportVersion is bumped whenever the report layout changes incompatibly.
+const ReportVersion = 1
+
+const (
+	StatusOK     = "ok"
+	StatusFailed = "failed"
+	// StatusSkipped is given to the checks that could not run, such as the TLS
+	// handshake with an address that is not reachable over TCP.
+	StatusSkipped = "skipped"
+
+	defaultTimeout = 5 * time.Second
+)
+
+// interfaceAddrs is swapped in tests.
+var interfaceAddrs = net.InterfaceAddrs
+
+// Env is what the diagnostics know about the join of the node.
+type Env struct {
+	Token string
+	// AdvertiseAddress is the address the node advertises, if any.
+	AdvertiseAddress string
+	Timeout          time.Duration
+	Now              func() time.Time
+}
+
+type TokenReport struct {
+	Status    string     `json:"status"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+type AdvertiseReport struct {
+	Address string `json:"address,omitempty"`
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+}
+
+// AddressReport is the outcome of the probes of one join address.
+type AddressReport struct {
+	Address string `json:"address"`
+	TCP     string `json:"tcp"`
+	TLS     string `json:"tls"`
+	Error   string `json:"error,omitempty"`
+}
+
+type Report struct {
+	Version       int             `json:"version"`
+	Time          time.Time       `json:"time"`
+	Token         TokenReport     `json:"token"`
+	Advertise     AdvertiseReport `json:"advertise"`
+	JoinAddresses []AddressReport `json:"joinAddresses"`
+}
+
+// Ready reports whether the node can reach the cluster: a join address completes a
+// TLS handshake with the certificate of the token, and the advertise address, if
+// any, is a routable address of the node. An expired token is reported but doesn't
+// block, as only a join attempt gets it refreshed. A token that cannot be read has
+// no join addresses to reach, so the node is not ready.
+func (r Report) Ready() bool {
+	if r.Advertise.Status == StatusFailed {
+		return false
+	}
+	for _, address := range r.JoinAddresses {
+		if address.TLS == StatusOK {
+			return true
+		}
+	}
+	return false
+}
+
+// Status sums the report up in one line, naming what keeps the node from joining.
+func (r Report) Status() string {
+	var problems []string
+	if r.Token.Error != "" {
+		problems = append(problems, "join token: "+r.Token.Error)
+	}
+	if r.Advertise.Error != "" {
+		problems = append(problems, "advertise address: "+r.Advertise.Error)
+	}
+
+	var reachable []string
+	for _, address := range r.JoinAddresses {
+		if address.TLS == StatusOK {
+			reachable = append(reachable, address.Address)
+		} else {
+			problems = append(problems, fmt.Sprintf("join address %s: %s", address.Address, address.Error))
+		}
+	}
+
+	switch {
+	case len(r.JoinAddresses) == 0:
+		return "not ready to join, cannot probe the join addresses: " + strings.Join(problems, "; ")
+	case r.Ready() && len(problems) == 0:
+		return fmt.Sprintf("ready to join through %s", strings.Join(reachable, ", "))
+	case r.Ready():
+		return fmt.Sprintf("ready to join through %s; %s", strings.Join(reachable, ", "), strings.Join(problems, "; "))
+	default:
+		return "not ready to join: " + strings.Join(problems, "; ")
+	}
+}
+
+// DiagnoseJoin checks the token, the advertise address and each join address of
+// the token.
+func DiagnoseJoin(env Env) Report {
+	if env.Now == nil {
+		env.Now = time.Now
+	}
+	if env.Timeout == 0 {
+		env.Timeout = defaultTimeout
+	}
+
+	report := Report{
+		Version:       ReportVersion,
+		Time:          env.Now().UTC(),
+		Token:         TokenReport{Status: StatusOK},
+		Advertise:     AdvertiseReport{Address: env.AdvertiseAddress, Status: StatusSkipped},
+		JoinAddresses: []AddressReport{},
+	}
+
+	info, err := token.Decode(env.Token)
+	if err != nil {
+		report.Token = TokenReport{Status: StatusFailed, Error: err.Error()}
+		return report
+	}
+	report.Token.ExpiresAt = info.ExpiresAt
+	if info.ExpiresAt != nil && !env.Now().Before(*info.ExpiresAt) {
+		report.Token.Status = StatusFailed
+		report.Token.Error = fmt.Sprintf("expired at %s", info.ExpiresAt.UTC().Format(time.RFC3339))
+	}
+
+	if env.AdvertiseAddress != "" {
+		report.Advertise = checkAdvertiseAddress(env.AdvertiseAddress, info.JoinAddresses)
+	}
+	for _, address := range info.JoinAddresses {
+		report.JoinAddresses = append(report.JoinAddresses, probe(address, info.Fingerprint, env.Timeout))
+	}
+	return report
+}
+
+// checkAdvertiseAddress checks that the address belongs to the node and that the
+// node has a route from it to every join address. The UDP sockets are only
+// connected, nothing is sent.
+func checkAdvertiseAddress(address string, joinAddresses []string) AdvertiseReport {
+	report := AdvertiseReport{Address: address, Status: StatusFailed}
+
+	addr, err := netip.ParseAddr(strings.Trim(address, "[]"))
+	if err != nil {
+		report.Error = fmt.Sprintf("%s is not an IP address", address)
+		return report
+	}
+	if !isLocal(addr) {
+		report.Error = fmt.Sprintf("%s is not assigned to any interface of this node", addr)
+		return report
+	}
+
+	dialer := net.Dialer{LocalAddr: &net.UDPAddr{IP: addr.AsSlice()}}
+	for _, joinAddress := range joinAddresses {
+		conn, err := dialer.Dial("udp", joinAddress)
+		if err != nil {
+			report.Error = fmt.Sprintf("no route from %s to %s: %v", addr, joinAddress, err)
+			return report
+		}
+		_ = conn.Close()
+	}
+
+	report.Status = StatusOK
+	return report
+}
+
+func isLocal(addr netip.Addr) bool {
+	addrs, err := interfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, local := range addrs {
+		if prefix, err := netip.ParsePrefix(local.String()); err == nil && prefix.Addr().Unmap() == addr.Unmap() {
+			return true
+		}
+	}
+	return false
+}
+
+// probe connects to the join address and completes a TLS handshake. The k8sd
+// certificate is pinned through the fingerprint of the token rather than signed by
+// a CA, so it is checked against the fingerprint instead of a CA. A token without
+// a fingerprint leaves the certificate unverified.
+func probe(address, fingerprint string, timeout time.Duration) AddressReport {
+	report := AddressReport{Address: address, TCP: StatusFailed, TLS: StatusSkipped}
+
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		report.Error = fmt.Sprintf("tcp: %v", err)
+		return report
+	}
+	defer func() { _ = conn.Close() }()
+	report.TCP = StatusOK
+
+	tlsConn := tls.Client(conn, &tls.Config{
+		InsecureSkipVerify:    true,
+		VerifyPeerCertificate: verifyFingerprint(fingerprint),
+	})
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+	if err = tlsConn.Handshake(); err != nil {
+		report.TLS = StatusFailed
+		report.Error = fmt.Sprintf("tls: %v", err)
+		return report
+	}
+	report.TLS = StatusOK
+	return report
+}
+
+// verifyFingerprint checks that the leaf certificate of the peer has the SHA-256
+// fingerprint of the token, so a join address answered by anything but the k8sd
+// of the cluster is reported.
+func verifyFingerprint(fingerprint string) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if fingerprint == "" {
+			return nil
+		}
+		if len(rawCerts) == 0 {
+			return errors.New("no certificate presented")
+		}
+		sum := sha256.Sum256(rawCerts[0])
+		if actual := hex.EncodeToString(sum[:]); !strings.EqualFold(actual, fingerprint) {
+			return errors.Errorf("certificate fingerprint %s does not match the join token", actual)
+		}
+		return nil
+	}
+}
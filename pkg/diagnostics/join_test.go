@@ -0,0 +1,130 @@
+package diagnostics
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func joinToken(addresses []string, fingerprint, expiresAt string) string {
+	content := fmt.Sprintf(`{"token":"s3cr3t","join_addresses":["%s"]`, strings.Join(addresses, `","`))
+	if fingerprint != "" {
+		content += fmt.Sprintf(`,"fingerprint":%q`, fingerprint)
+	}
+	if expiresAt != "" {
+		content += fmt.Sprintf(`,"expires_at":%q`, expiresAt)
+	}
+	return base64.StdEncoding.EncodeToString([]byte(content + "}"))
+}
+
+func TestDiagnoseJoin(t *testing.T) {
+	g := NewWithT(t)
+
+	tlsServer := httptest.NewTLSServer(http.NotFoundHandler())
+	defer tlsServer.Close()
+	tlsAddress := tlsServer.Listener.Addr().String()
+	sum := sha256.Sum256(tlsServer.Certificate().Raw)
+	fingerprint := hex.EncodeToString(sum[:])
+
+	plainListener, err := net.Listen("tcp", "127.0.0.1:0")
+	g.Expect(err).NotTo(HaveOccurred())
+	defer func() { _ = plainListener.Close() }()
+	go func() {
+		for {
+			conn, err := plainListener.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = conn.Write([]byte("not tls\n"))
+			_ = conn.Close()
+		}
+	}()
+	plainAddress := plainListener.Addr().String()
+
+	closedListener, err := net.Listen("tcp", "127.0.0.1:0")
+	g.Expect(err).NotTo(HaveOccurred())
+	closedAddress := closedListener.Addr().String()
+	g.Expect(closedListener.Close()).To(Succeed())
+
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+	t.Run("is ready when a join address completes a TLS handshake", func(t *testing.T) {
+		report := DiagnoseJoin(Env{
+			Token:            joinToken([]string{closedAddress, plainAddress, tlsAddress}, fingerprint, "2026-10-20T12:00:00Z"),
+			AdvertiseAddress: "127.0.0.1",
+			Timeout:          2 * time.Second,
+			Now:              func() time.Time { return now },
+		})
+
+		g.Expect(report.Ready()).To(BeTrue())
+		g.Expect(report.Token.Status).To(Equal(StatusOK))
+		g.Expect(report.Advertise.Status).To(Equal(StatusOK))
+		g.Expect(report.JoinAddresses).To(HaveLen(3))
+		g.Expect(report.JoinAddresses[0].TCP).To(Equal(StatusFailed))
+		g.Expect(report.JoinAddresses[0].TLS).To(Equal(StatusSkipped))
+		g.Expect(report.JoinAddresses[1].TCP).To(Equal(StatusOK))
+		g.Expect(report.JoinAddresses[1].TLS).To(Equal(StatusFailed))
+		g.Expect(report.JoinAddresses[2].TCP).To(Equal(StatusOK))
+		g.Expect(report.JoinAddresses[2].TLS).To(Equal(StatusOK))
+		g.Expect(report.Status()).To(HavePrefix("ready to join through " + tlsAddress + "; join address " + closedAddress + ": tcp: "))
+	})
+
+	t.Run("reports an expired token and unreachable join addresses", func(t *testing.T) {
+		report := DiagnoseJoin(Env{
+			Token: joinToken([]string{closedAddress}, fingerprint, "2026-10-19T11:00:00Z"),
+			Now:   func() time.Time { return now },
+		})
+
+		g.Expect(report.Ready()).To(BeFalse())
+		g.Expect(report.Token.Status).To(Equal(StatusFailed))
+		g.Expect(report.Status()).To(HavePrefix("not ready to join: join token: expired at 2026-10-19T11:00:00Z; join address " + closedAddress + ": tcp: "))
+	})
+
+	t.Run("is not ready with an advertise address of another node", func(t *testing.T) {
+		report := DiagnoseJoin(Env{
+			Token:            joinToken([]string{tlsAddress}, fingerprint, ""),
+			AdvertiseAddress: "192.0.2.10",
+		})
+
+		g.Expect(report.Ready()).To(BeFalse())
+		g.Expect(report.Advertise.Error).To(Equal("192.0.2.10 is not assigned to any interface of this node"))
+	})
+
+	t.Run("reports a join address with another certificate", func(t *testing.T) {
+		otherFingerprint := strings.Repeat("ab", sha256.Size)
+		report := DiagnoseJoin(Env{
+			Token: joinToken([]string{tlsAddress}, otherFingerprint, ""),
+			Now:   func() time.Time { return now },
+		})
+
+		g.Expect(report.Ready()).To(BeFalse())
+		g.Expect(report.JoinAddresses[0].TCP).To(Equal(StatusOK))
+		g.Expect(report.JoinAddresses[0].TLS).To(Equal(StatusFailed))
+		g.Expect(report.JoinAddresses[0].Error).To(Equal("tls: certificate fingerprint " + fingerprint + " does not match the join token"))
+	})
+
+	t.Run("completes the handshake with a token without fingerprint", func(t *testing.T) {
+		report := DiagnoseJoin(Env{
+			Token: joinToken([]string{tlsAddress}, "", ""),
+			Now:   func() time.Time { return now },
+		})
+
+		g.Expect(report.Ready()).To(BeTrue())
+	})
+
+	t.Run("is not ready with a token it cannot read", func(t *testing.T) {
+		report := DiagnoseJoin(Env{Token: "not a token"})
+
+		g.Expect(report.Ready()).To(BeFalse())
+		g.Expect(report.Status()).To(Equal("not ready to join, cannot probe the join addresses: join token: join token is not base64 encoded"))
+	})
+}
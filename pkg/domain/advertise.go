@@ -0,0 +1,136 @@
+package domain
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// maxInterfaceNameLength is IFNAMSIZ without the trailing NUL.
+const maxInterfaceNameLength = 15
+
+// AdvertiseSelector picks the advertise address of a node among the addresses of
+// its interfaces, so that one image serves sites with different networks.
+type AdvertiseSelector struct {
+	// Interface restricts the candidates to the addresses of one interface.
+	Interface string `json:"interface" yaml:"interface"`
+	// CIDRs restricts the candidates to the addresses within one of the CIDRs.
+	CIDRs []netip.Prefix `json:"cidrs" yaml:"cidrs"`
+	// Family is the preferred IP family, or dual to pick an address of each.
+	Family Stack `json:"family" yaml:"family"`
+}
+
+// ParseAdvertiseSelector validates the advertise interface, CIDRs and IP family.
+// The family defaults to dual for a dual-stack CIDR pair and to IPv4 otherwise.
+func ParseAdvertiseSelector(iface, cidrs, family string) (AdvertiseSelector, error) {
+	prefixes, err := ParseCidrPair(cidrs)
+	if err != nil {
+		return AdvertiseSelector{}, errors.Wrap(err, "invalid advertise CIDR")
+	}
+
+	selector := AdvertiseSelector{Interface: strings.TrimSpace(iface), CIDRs: prefixes}
+	if len(selector.Interface) > maxInterfaceNameLength || strings.ContainsAny(selector.Interface, "/ \t\n'\"$`;&|") {
+		return AdvertiseSelector{}, errors.Errorf("invalid advertise interface %q", iface)
+	}
+	switch Stack(family) {
+	case "":
+		selector.Family = StackIPv4
+		if len(prefixes) == 2 {
+			selector.Family = StackDual
+		}
+	case StackIPv4, StackIPv6, StackDual:
+		selector.Family = Stack(family)
+	default:
+		return AdvertiseSelector{}, errors.Errorf("unknown advertise IP family %q, expected ipv4, ipv6 or dual", family)
+	}
+	return selector, nil
+}
+
+// Enabled reports whether the advertise address is to be resolved at all.
+func (s AdvertiseSelector) Enabled() bool {
+	return s.Interface != "" || len(s.CIDRs) > 0
+}
+
+// CIDRString returns the CIDRs as a comma separated pair.
+func (s AdvertiseSelector) CIDRString() string {
+	var cidrs []string
+	for _, cidr := range s.CIDRs {
+		cidrs = append(cidrs, cidr.String())
+	}
+	return strings.Join(cidrs, ",")
+}
+
+// Select returns the addresses to advertise among the host addresses, the
+// preferred one first. Loopback, link-local and multicast addresses are never
+// advertised. A dual-stack selection needs an address of each family, ordered
+// like the CIDR pair or IPv4 first.
+func (s AdvertiseSelector) Select(addrs []netip.Addr) ([]netip.Addr, error) {
+	var ipv4, ipv6 []netip.Addr
+	for _, addr := range addrs {
+		addr = addr.Unmap().WithZone("")
+		if !s.usable(addr) {
+			continue
+		}
+		if addr.Is4() {
+			ipv4 = append(ipv4, addr)
+		} else {
+			ipv6 = append(ipv6, addr)
+		}
+	}
+
+	switch {
+	case s.Family == StackDual && len(ipv4) > 0 && len(ipv6) > 0:
+		if len(s.CIDRs) == 2 && s.CIDRs[0].Addr().Is6() {
+			return []netip.Addr{ipv6[0], ipv4[0]}, nil
+		}
+		return []netip.Addr{ipv4[0], ipv6[0]}, nil
+	case s.Family == StackDual:
+	case s.Family == StackIPv6 && len(ipv6) > 0:
+		return ipv6[:1], nil
+	case len(ipv4) > 0:
+		return ipv4[:1], nil
+	case len(ipv6) > 0:
+		return ipv6[:1], nil
+	}
+
+	found := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		found = append(found, addr.String())
+	}
+	return nil, errors.Errorf("no %s address %s, found [%s]", s.familyName(), s.describe(), strings.Join(found, ", "))
+}
+
+func (s AdvertiseSelector) usable(addr netip.Addr) bool {
+	if !addr.IsValid() || addr.IsLoopback() || addr.IsMulticast() || addr.IsLinkLocalUnicast() || addr.IsUnspecified() {
+		return false
+	}
+	if len(s.CIDRs) == 0 {
+		return true
+	}
+	for _, cidr := range s.CIDRs {
+		if cidr.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s AdvertiseSelector) familyName() string {
+	if s.Family == StackDual {
+		return "IPv4 and IPv6"
+	}
+	return "usable"
+}
+
+func (s AdvertiseSelector) describe() string {
+	var where []string
+	if s.Interface != "" {
+		where = append(where, fmt.Sprintf("on interface %s", s.Interface))
+	}
+	if len(s.CIDRs) > 0 {
+		where = append(where, fmt.Sprintf("within %s", s.CIDRString()))
+	}
+	return strings.Join(where, " ")
+}
@@ -0,0 +1,79 @@
+package domain
+
+import (
+	"net/netip"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestAdvertiseSelector(t *testing.T) {
+	g := NewWithT(t)
+
+	hostAddrs := []netip.Addr{
+		netip.MustParseAddr("127.0.0.1"),
+		netip.MustParseAddr("fe80::1"),
+		netip.MustParseAddr("192.168.1.5"),
+		netip.MustParseAddr("10.20.3.4"),
+		netip.MustParseAddr("fd00:20::4"),
+	}
+
+	for _, tc := range []struct {
+		name      string
+		iface     string
+		cidr      string
+		family    string
+		addresses []string
+	}{
+		{name: "prefers IPv4", iface: "eth1", addresses: []string{"192.168.1.5"}},
+		{name: "prefers IPv6", iface: "eth1", family: "ipv6", addresses: []string{"fd00:20::4"}},
+		{name: "picks within the CIDR", cidr: "10.20.0.0/16", addresses: []string{"10.20.3.4"}},
+		{name: "falls back to the other family", cidr: "10.20.0.0/16,fd00:20::/64", family: "ipv6", addresses: []string{"fd00:20::4"}},
+		{name: "picks both families of a CIDR pair in order", cidr: "fd00:20::/64,10.20.0.0/16", addresses: []string{"fd00:20::4", "10.20.3.4"}},
+		{name: "picks both families IPv4 first", iface: "eth1", family: "dual", addresses: []string{"192.168.1.5", "fd00:20::4"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			selector, err := ParseAdvertiseSelector(tc.iface, tc.cidr, tc.family)
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(selector.Enabled()).To(BeTrue())
+
+			addrs, err := selector.Select(hostAddrs)
+			g.Expect(err).NotTo(HaveOccurred())
+
+			var addresses []string
+			for _, addr := range addrs {
+				addresses = append(addresses, addr.String())
+			}
+			g.Expect(addresses).To(Equal(tc.addresses))
+		})
+	}
+
+	t.Run("fails when nothing matches", func(t *testing.T) {
+		selector, err := ParseAdvertiseSelector("eth1", "172.16.0.0/12", "")
+		g.Expect(err).NotTo(HaveOccurred())
+
+		_, err = selector.Select(hostAddrs)
+		g.Expect(err).To(MatchError("no usable address on interface eth1 within 172.16.0.0/12, found [127.0.0.1, fe80::1, 192.168.1.5, 10.20.3.4, fd00:20::4]"))
+
+		selector, err = ParseAdvertiseSelector("", "10.20.0.0/16", "dual")
+		g.Expect(err).NotTo(HaveOccurred())
+
+		_, err = selector.Select(hostAddrs)
+		g.Expect(err).To(MatchError(ContainSubstring("no IPv4 and IPv6 address within 10.20.0.0/16")))
+	})
+
+	t.Run("rejects invalid options", func(t *testing.T) {
+		_, err := ParseAdvertiseSelector("eth1; reboot", "", "")
+		g.Expect(err).To(HaveOccurred())
+		_, err = ParseAdvertiseSelector("", "10.20.0.0", "")
+		g.Expect(err).To(HaveOccurred())
+		_, err = ParseAdvertiseSelector("eth1", "", "ipv5")
+		g.Expect(err).To(HaveOccurred())
+	})
+
+	t.Run("is disabled without interface or CIDR", func(t *testing.T) {
+		selector, err := ParseAdvertiseSelector("", "", "ipv6")
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(selector.Enabled()).To(BeFalse())
+	})
+}
@@ -1,5 +1,7 @@
 package domain
 
+import "time"
+
 type ClusterContext struct {
 	NodeRole               string `json:"nodeRole" yaml:"nodeRole"`
 	ClusterCidr            string `json:"clusterCidr" yaml:"clusterCidr"`
@@ -10,5 +12,125 @@ type ClusterContext struct {
 	LocalImagesPath        string `json:"localImagesPath" yaml:"localImagesPath"`
 	CustomAdvertiseAddress string `json:"customAdvertiseAddress" yaml:"customAdvertiseAddress"`
 
+	// NodeIPs are the parsed advertise addresses, one per IP family. The first
+	// one is CustomAdvertiseAddress.
+	NodeIPs []string `json:"nodeIPs" yaml:"nodeIPs"`
+
+	// Advertise selects the advertise addresses among the host addresses when no
+	// literal advertise address is set.
+	Advertise AdvertiseSelector `json:"advertise" yaml:"advertise"`
+
+	// NodeLabels and NodeTaints are registered with the node by the kubelet. They
+	// only apply when the node registers, later changes have to be made with
+	// kubectl.
+	NodeLabels []string `json:"nodeLabels" yaml:"nodeLabels"`
+	NodeTaints []string `json:"nodeTaints" yaml:"nodeTaints"`
+
+	// ProxyCACert is the PEM bundle of a TLS intercepting proxy to trust.
+	ProxyCACert string `json:"proxyCACert" yaml:"proxyCACert"`
+
+	// InitMode selects how the init node creates its cluster: a fresh bootstrap,
+	// or a restore of RestoreBackupPath.
+	InitMode          string `json:"initMode" yaml:"initMode"`
+	RestoreBackupPath string `json:"restoreBackupPath" yaml:"restoreBackupPath"`
+
+	Backup BackupConfig `json:"backup" yaml:"backup"`
+
+	// SnapSource selects whether the snaps are installed from the airgap bundle or
+	// from the store, tracking K8sChannel.
+	SnapSource string `json:"snapSource" yaml:"snapSource"`
+	K8sChannel string `json:"k8sChannel" yaml:"k8sChannel"`
+
+	ImageSignature ImageSignatureConfig `json:"imageSignature" yaml:"imageSignature"`
+
+	TokenRefresh TokenRefreshConfig `json:"tokenRefresh" yaml:"tokenRefresh"`
+
+	Retry RetryPolicy `json:"retry" yaml:"retry"`
+
+	// Features are merged into the cluster config the init node bootstraps with.
+	Features ClusterFeatures `json:"features" yaml:"features"`
+
+	// ConfigHash identifies the provider config of the node in its status.
+	ConfigHash string `json:"configHash" yaml:"configHash"`
+	// MetricsDir is where the metrics of the node are rendered for the textfile
+	// collector of the node exporter.
+	MetricsDir string `json:"metricsDir" yaml:"metricsDir"`
+	// ScriptLogFiles appends the output of the scripts to the per-script log files
+	// of older releases as well as to the provider log. The xtrace of the scripts
+	// is logged at debug level, so below it these files are the only place it is
+	// kept.
+	ScriptLogFiles bool `json:"scriptLogFiles" yaml:"scriptLogFiles"`
+
 	EnvConfig map[string]string `json:"envConfig" yaml:"envConfig"`
+
+	// ConfigErrors are the invalid provider options that keep the node from
+	// bootstrapping or joining, as it would come up other than configured.
+	ConfigErrors []error `json:"-" yaml:"-"`
+}
+
+// BackupConfig describes the scheduled datastore backups of a control plane node.
+// Backups are disabled when Schedule is empty.
+type BackupConfig struct {
+	Schedule  string `json:"schedule" yaml:"schedule"`
+	Retention int    `json:"retention" yaml:"retention"`
+	Dir       string `json:"dir" yaml:"dir"`
+}
+
+func (b BackupConfig) Enabled() bool {
+	return b.Schedule != ""
+}
+
+// ImageSignatureConfig describes how the signatures of the local images are
+// verified before they are imported.
+type ImageSignatureConfig struct {
+	Policy    string `json:"policy" yaml:"policy"`
+	PublicKey string `json:"publicKey" yaml:"publicKey"`
+}
+
+// TokenRefreshConfig selects where a joining node gets a new join token from when
+// the cluster no longer knows its token.
+type TokenRefreshConfig struct {
+	Source string `json:"source" yaml:"source"`
+
+	// URL is the HTTPS endpoint of the https source, trusted through CAFile and
+	// authenticated with the client certificate, if set, and Headers.
+	URL            string            `json:"url,omitempty" yaml:"url,omitempty"`
+	CAFile         string            `json:"caFile,omitempty" yaml:"caFile,omitempty"`
+	ClientCertFile string            `json:"clientCertFile,omitempty" yaml:"clientCertFile,omitempty"`
+	ClientKeyFile  string            `json:"clientKeyFile,omitempty" yaml:"clientKeyFile,omitempty"`
+	Headers        map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+	// Insecure skips the verification of the endpoint certificate.
+	Insecure bool `json:"insecure,omitempty" yaml:"insecure,omitempty"`
+	// ResolveJoinAddresses sends the request to the join addresses of the token,
+	// in turn, rather than to the address the URL host resolves to.
+	ResolveJoinAddresses bool `json:"resolveJoinAddresses,omitempty" yaml:"resolveJoinAddresses,omitempty"`
+	// Expiry is the lifetime the endpoint is asked to give the new token.
+	Expiry string `json:"expiry,omitempty" yaml:"expiry,omitempty"`
+
+	// File is read for a new token by the file source.
+	File string `json:"file,omitempty" yaml:"file,omitempty"`
+}
+
+func (t TokenRefreshConfig) Enabled() bool {
+	return t.Source != "" && t.Source != TokenRefreshDisabled
+}
+
+// RetryPolicy bounds the retry loops of the bootstrap, join, restore and upgrade
+// scripts. The delay between attempts grows exponentially from InitialDelay up to
+// MaxDelay, with jitter, and a phase fails once it made MaxAttempts attempts at a
+// step or ran for PhaseDeadline. Zero MaxAttempts or PhaseDeadline are unbounded.
+type RetryPolicy struct {
+	MaxAttempts   int           `json:"maxAttempts" yaml:"maxAttempts"`
+	InitialDelay  time.Duration `json:"initialDelay" yaml:"initialDelay"`
+	MaxDelay      time.Duration `json:"maxDelay" yaml:"maxDelay"`
+	PhaseDeadline time.Duration `json:"phaseDeadline" yaml:"phaseDeadline"`
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:   DefaultRetryMaxAttempts,
+		InitialDelay:  DefaultRetryInitialDelay,
+		MaxDelay:      DefaultRetryMaxDelay,
+		PhaseDeadline: DefaultRetryPhaseDeadline,
+	}
 }
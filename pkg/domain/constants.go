@@ -1,10 +1,112 @@
 package domain
 
+import "time"
+
 const (
 	K8sNoProxy             = ".svc,.svc.cluster,.svc.cluster.local,localhost,127.0.0.1"
 	KubeComponentsArgsPath = "/var/snap/k8s/common/args"
 	KubeCertificateDirPath = "/etc/kubernetes/pki"
 
+	K8sDqliteDataPath = "/var/snap/k8s/common/var/lib/k8s-dqlite"
+	EtcdDataPath      = "/var/snap/k8s/common/var/lib/etcd"
+	K8sdStatePath     = "/var/snap/k8s/common/var/lib/k8sd/state"
+
+	// EtcdSnapshotDir holds the etcd snapshot of a backup, which is restored into
+	// EtcdDataPath rather than the data dir being copied while etcd runs.
+	EtcdSnapshotDir = "/var/snap/k8s/common/var/lib/etcd-snapshot"
+	EtcdctlPath     = "/snap/k8s/current/bin/etcdctl"
+	EtcdutlPath     = "/snap/k8s/current/bin/etcdutl"
+
+	ContainerdHostsDir = "/etc/containerd/hosts.d"
+
 	CanonicalScriptDir    = "/opt/canonical/scripts"
 	DefaultLocalImagesDir = "/opt/canonical/images"
+
+	// K8sImagesDir is where the k8s snap picks up image archives to import into containerd.
+	K8sImagesDir            = "/var/snap/k8s/common/images"
+	ImportedImagesStateFile = "/var/lib/provider-canonical/imported-images.json"
+	ImageSigningKeyPath     = "/etc/provider-canonical/image-signing.pub"
+
+	SignaturePolicyEnforce = "enforce"
+	SignaturePolicyWarn    = "warn"
+	SignaturePolicyOff     = "off"
+
+	// SnapBundleDir holds the airgap snaps, whose revisions are pinned by the
+	// .revision files in SnapRevisionDir, or LegacySnapRevisionDir on older deployments.
+	SnapBundleDir         = "/opt/canonical-k8s"
+	SnapRevisionDir       = "/opt/canonical/revision"
+	LegacySnapRevisionDir = "/opt/canonical"
+	BundleReportPath      = "/run/provider-canonical/bundle.json"
+
+	// PreflightReportPath is the report of the last preflight run, PreflightFailedPath
+	// only exists while it has fatal findings.
+	PreflightReportPath = "/run/provider-canonical/preflight.json"
+	PreflightFailedPath = "/run/provider-canonical/preflight.failed"
+
+	ProviderBinaryPath = "/usr/local/system/providers/agent-provider-canonical"
+
+	// ProviderParamsPath holds the node parameters the scripts read, rather than
+	// taking them as arguments of a shell command line.
+	ProviderParamsPath = "/run/provider-canonical/params"
+
+	// LogConfigPath holds the log config resolved from the provider options, for
+	// the subcommands run by the stages and scripts.
+	LogConfigPath  = "/run/provider-canonical/logging.json"
+	DefaultLogPath = "/var/log/provider-canonical.log"
+
+	// JoinTokenPath holds the token of a joining node until it has joined, so that
+	// the token never shows on a command line.
+	JoinTokenPath = "/run/provider-canonical/join-token"
+
+	// JoinDiagnosticsPath is the report of the last connectivity diagnostics of a
+	// joining node.
+	JoinDiagnosticsPath = "/run/provider-canonical/join-diagnostics.json"
+
+	// K8sdSocketPath serves the k8sd API of the node.
+	K8sdSocketPath = "/var/snap/k8s/common/var/lib/k8sd/state/control.socket"
+
+	TokenRefreshDisabled = "disabled"
+	TokenRefreshHTTPS    = "https"
+	TokenRefreshFile     = "file"
+	// TokenRefreshPalette is the https source preset of the Palette edge API. It
+	// is the default of the Palette edge hosts, which refreshed their token from
+	// it before the token refresh options existed.
+	TokenRefreshPalette = "palette"
+
+	// PaletteUserdataPath is the userdata of a Palette edge host, it gives the
+	// site name and local UI port of the edge API.
+	PaletteUserdataPath     = "/run/stylus/userdata"
+	PaletteMTLSDir          = "/oem/.spectrocloud/mtls"
+	PaletteEdgeAPIHost      = "internal.spectrocloud.com"
+	PaletteLocalUIPort      = 5080
+	PaletteTokenRefreshPath = "/v1/internal/edgehosts/current/actions/cluster-token"
+
+	// TokenRefreshConfigPath holds the token refresh source of a joining node. It
+	// may hold credentials in its headers, so it is a root only file written next
+	// to JoinTokenPath.
+	TokenRefreshConfigPath = "/run/provider-canonical/token-refresh.json"
+
+	SnapSourceAirgap = "airgap"
+	SnapSourceStore  = "store"
+
+	InitModeBootstrap = "bootstrap"
+	InitModeRestore   = "restore"
+
+	DefaultBackupDir       = "/var/lib/provider-canonical/backups"
+	DefaultBackupRetention = 7
+
+	// StatusPath records the state of the provisioning phases of the node. It is
+	// mirrored to StatusMirrorPath, which outlives a reboot.
+	StatusPath       = "/run/provider-canonical/status.json"
+	StatusMirrorPath = "/opt/canonical/status.json"
+	// K8sSnapCurrentPath links to the revision of the installed k8s snap.
+	K8sSnapCurrentPath = "/snap/k8s/current"
+
+	// DefaultMetricsDir is the dir of the textfile collector of the node exporter.
+	DefaultMetricsDir = "/var/lib/node_exporter/textfile_collector"
+
+	DefaultRetryMaxAttempts   = 0
+	DefaultRetryInitialDelay  = 10 * time.Second
+	DefaultRetryMaxDelay      = 5 * time.Minute
+	DefaultRetryPhaseDeadline = time.Hour
 )
@@ -0,0 +1,236 @@
+package domain
+
+import (
+	"bytes"
+	stderrors "errors"
+	"io"
+	"net/netip"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// ClusterFeatures is the cluster_features section of the provider options: the
+// features of the k8s snap the init node bootstraps the cluster with. Unset
+// features keep the snap defaults, or the cluster config of the cluster options.
+type ClusterFeatures struct {
+	Network       *ToggleFeature       `json:"network,omitempty" yaml:"network,omitempty"`
+	Ingress       *IngressFeature      `json:"ingress,omitempty" yaml:"ingress,omitempty"`
+	Gateway       *ToggleFeature       `json:"gateway,omitempty" yaml:"gateway,omitempty"`
+	LoadBalancer  *LoadBalancerFeature `json:"load-balancer,omitempty" yaml:"load-balancer,omitempty"`
+	LocalStorage  *LocalStorageFeature `json:"local-storage,omitempty" yaml:"local-storage,omitempty"`
+	MetricsServer *ToggleFeature       `json:"metrics-server,omitempty" yaml:"metrics-server,omitempty"`
+}
+
+type ToggleFeature struct {
+	Enabled *bool `json:"enabled,omitempty" yaml:"enabled,omitempty"`
+}
+
+type IngressFeature struct {
+	Enabled             *bool   `json:"enabled,omitempty" yaml:"enabled,omitempty"`
+	DefaultTLSSecret    *string `json:"default-tls-secret,omitempty" yaml:"default-tls-secret,omitempty"`
+	EnableProxyProtocol *bool   `json:"enable-proxy-protocol,omitempty" yaml:"enable-proxy-protocol,omitempty"`
+}
+
+// LoadBalancerFeature assigns the addresses of CIDRs, as prefixes or first-last
+// ranges, to the LoadBalancer services and announces them through L2, BGP or both.
+type LoadBalancerFeature struct {
+	Enabled *bool    `json:"enabled,omitempty" yaml:"enabled,omitempty"`
+	CIDRs   []string `json:"cidrs,omitempty" yaml:"cidrs,omitempty"`
+	L2      *L2Mode  `json:"l2,omitempty" yaml:"l2,omitempty"`
+	BGP     *BGPMode `json:"bgp,omitempty" yaml:"bgp,omitempty"`
+}
+
+// L2Mode announces the addresses through ARP on Interfaces, or all of them.
+type L2Mode struct {
+	Enabled    *bool    `json:"enabled,omitempty" yaml:"enabled,omitempty"`
+	Interfaces []string `json:"interfaces,omitempty" yaml:"interfaces,omitempty"`
+}
+
+// BGPMode announces the addresses to a BGP peer.
+type BGPMode struct {
+	Enabled     *bool   `json:"enabled,omitempty" yaml:"enabled,omitempty"`
+	LocalASN    *int    `json:"local-asn,omitempty" yaml:"local-asn,omitempty"`
+	PeerAddress *string `json:"peer-address,omitempty" yaml:"peer-address,omitempty"`
+	PeerASN     *int    `json:"peer-asn,omitempty" yaml:"peer-asn,omitempty"`
+	PeerPort    *int    `json:"peer-port,omitempty" yaml:"peer-port,omitempty"`
+}
+
+type LocalStorageFeature struct {
+	Enabled       *bool   `json:"enabled,omitempty" yaml:"enabled,omitempty"`
+	LocalPath     *string `json:"local-path,omitempty" yaml:"local-path,omitempty"`
+	ReclaimPolicy *string `json:"reclaim-policy,omitempty" yaml:"reclaim-policy,omitempty"`
+	Default       *bool   `json:"default,omitempty" yaml:"default,omitempty"`
+}
+
+// ParseClusterFeatures parses and validates the cluster_features YAML, rejecting
+// unknown keys so a typo doesn't silently leave a feature out.
+func ParseClusterFeatures(content string) (ClusterFeatures, error) {
+	var features ClusterFeatures
+	if strings.TrimSpace(content) == "" {
+		return features, nil
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader([]byte(content)))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&features); err != nil && err != io.EOF {
+		return ClusterFeatures{}, errors.Wrap(err, "invalid cluster features")
+	}
+	if err := features.Validate(); err != nil {
+		return ClusterFeatures{}, err
+	}
+	return features, nil
+}
+
+// Validate rejects invalid and contradictory settings, such as the settings of a
+// disabled feature or a load balancer that announces its addresses nowhere.
+func (f ClusterFeatures) Validate() error {
+	var errs []error
+
+	if f.Network != nil && isFalse(f.Network.Enabled) {
+		// the ingress, gateway and load balancer are provided by the network plugin
+		if f.Ingress != nil && isTrue(f.Ingress.Enabled) {
+			errs = append(errs, errors.New("ingress requires the network feature"))
+		}
+		if f.Gateway != nil && isTrue(f.Gateway.Enabled) {
+			errs = append(errs, errors.New("gateway requires the network feature"))
+		}
+		if f.LoadBalancer != nil && isTrue(f.LoadBalancer.Enabled) {
+			errs = append(errs, errors.New("load-balancer requires the network feature"))
+		}
+	}
+
+	if ingress := f.Ingress; ingress != nil && isFalse(ingress.Enabled) &&
+		(ingress.DefaultTLSSecret != nil || ingress.EnableProxyProtocol != nil) {
+		errs = append(errs, errors.New("ingress is disabled but configured"))
+	}
+	if lb := f.LoadBalancer; lb != nil {
+		errs = append(errs, lb.validate()...)
+	}
+	if storage := f.LocalStorage; storage != nil {
+		errs = append(errs, storage.validate()...)
+	}
+	return stderrors.Join(errs...)
+}
+
+func (lb LoadBalancerFeature) validate() []error {
+	if isFalse(lb.Enabled) {
+		if len(lb.CIDRs) > 0 || lb.L2 != nil || lb.BGP != nil {
+			return []error{errors.New("load-balancer is disabled but configured")}
+		}
+		return nil
+	}
+
+	var errs []error
+	if isTrue(lb.Enabled) && len(lb.CIDRs) == 0 {
+		errs = append(errs, errors.New("load-balancer requires cidrs"))
+	}
+	for _, cidr := range lb.CIDRs {
+		if err := validateAddressPool(cidr); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// L2 is on unless disabled, BGP off unless enabled
+	l2 := lb.L2 == nil || !isFalse(lb.L2.Enabled)
+	bgp := lb.BGP != nil && isTrue(lb.BGP.Enabled)
+	if !l2 && !bgp {
+		errs = append(errs, errors.New("load-balancer announces its addresses nowhere, enable l2 or bgp"))
+	}
+	if lb.L2 != nil && !l2 && len(lb.L2.Interfaces) > 0 {
+		errs = append(errs, errors.New("load-balancer l2 is disabled but has interfaces"))
+	}
+	if lb.L2 != nil {
+		for _, iface := range lb.L2.Interfaces {
+			if !validInterfaceName(iface) {
+				errs = append(errs, errors.Errorf("invalid load-balancer l2 interface %q", iface))
+			}
+		}
+	}
+
+	if lb.BGP != nil && !bgp {
+		if lb.BGP.LocalASN != nil || lb.BGP.PeerAddress != nil || lb.BGP.PeerASN != nil || lb.BGP.PeerPort != nil {
+			errs = append(errs, errors.New("load-balancer bgp is disabled but configured"))
+		}
+	}
+	if bgp {
+		errs = append(errs, lb.BGP.validate()...)
+	}
+	return errs
+}
+
+func (b BGPMode) validate() []error {
+	var errs []error
+	if b.LocalASN == nil || !validASN(*b.LocalASN) {
+		errs = append(errs, errors.New("load-balancer bgp requires a local-asn between 1 and 4294967294"))
+	}
+	if b.PeerASN == nil || !validASN(*b.PeerASN) {
+		errs = append(errs, errors.New("load-balancer bgp requires a peer-asn between 1 and 4294967294"))
+	}
+	if b.PeerAddress == nil {
+		errs = append(errs, errors.New("load-balancer bgp requires a peer-address"))
+	} else if _, err := netip.ParseAddr(*b.PeerAddress); err != nil {
+		errs = append(errs, errors.Errorf("load-balancer bgp peer-address %q is not an IP address", *b.PeerAddress))
+	}
+	if b.PeerPort == nil || *b.PeerPort < 1 || *b.PeerPort > 65535 {
+		errs = append(errs, errors.New("load-balancer bgp requires a peer-port between 1 and 65535"))
+	}
+	return errs
+}
+
+func (s LocalStorageFeature) validate() []error {
+	if isFalse(s.Enabled) {
+		if s.LocalPath != nil || s.ReclaimPolicy != nil || s.Default != nil {
+			return []error{errors.New("local-storage is disabled but configured")}
+		}
+		return nil
+	}
+
+	var errs []error
+	if s.LocalPath != nil && (!filepath.IsAbs(*s.LocalPath) || filepath.Clean(*s.LocalPath) == "/") {
+		errs = append(errs, errors.Errorf("local-storage local-path %q is not an absolute path below /", *s.LocalPath))
+	}
+	if s.ReclaimPolicy != nil {
+		switch *s.ReclaimPolicy {
+		case "Retain", "Recycle", "Delete":
+		default:
+			errs = append(errs, errors.Errorf("unknown local-storage reclaim-policy %q, expected Retain, Recycle or Delete", *s.ReclaimPolicy))
+		}
+	}
+	return errs
+}
+
+// validateAddressPool accepts a CIDR or a first-last range of addresses of one
+// family.
+func validateAddressPool(pool string) error {
+	if first, last, ok := strings.Cut(pool, "-"); ok {
+		start, err1 := netip.ParseAddr(strings.TrimSpace(first))
+		end, err2 := netip.ParseAddr(strings.TrimSpace(last))
+		if err1 != nil || err2 != nil || start.Is4() != end.Is4() || end.Less(start) {
+			return errors.Errorf("load-balancer cidr %q is not a valid address range", pool)
+		}
+		return nil
+	}
+	if _, err := netip.ParsePrefix(pool); err != nil {
+		return errors.Errorf("load-balancer cidr %q is not a CIDR or an address range", pool)
+	}
+	return nil
+}
+
+func validASN(asn int) bool {
+	return asn >= 1 && asn <= 4294967294
+}
+
+func validInterfaceName(name string) bool {
+	return name != "" && len(name) <= maxInterfaceNameLength && !strings.ContainsAny(name, "/ \t\n'\"$`;&|")
+}
+
+func isTrue(value *bool) bool {
+	return value != nil && *value
+}
+
+func isFalse(value *bool) bool {
+	return value != nil && !*value
+}
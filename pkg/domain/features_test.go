@@ -0,0 +1,122 @@
+package domain
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestParseClusterFeatures(t *testing.T) {
+	g := NewWithT(t)
+
+	t.Run("parses the features", func(t *testing.T) {
+		features, err := ParseClusterFeatures(`
+ingress:
+  enabled: true
+  default-tls-secret: default-tls
+load-balancer:
+  enabled: true
+  cidrs: [10.0.0.0/28, 10.0.1.10-10.0.1.20]
+  l2:
+    interfaces: [eth0]
+  bgp:
+    enabled: true
+    local-asn: 64512
+    peer-address: 10.0.0.1
+    peer-asn: 64513
+    peer-port: 179
+local-storage:
+  enabled: true
+  local-path: /var/lib/local-storage
+  reclaim-policy: Retain
+metrics-server:
+  enabled: false
+`)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(*features.Ingress.DefaultTLSSecret).To(Equal("default-tls"))
+		g.Expect(features.LoadBalancer.CIDRs).To(Equal([]string{"10.0.0.0/28", "10.0.1.10-10.0.1.20"}))
+		g.Expect(features.LoadBalancer.L2.Interfaces).To(Equal([]string{"eth0"}))
+		g.Expect(*features.LoadBalancer.BGP.PeerASN).To(Equal(64513))
+		g.Expect(*features.LocalStorage.ReclaimPolicy).To(Equal("Retain"))
+		g.Expect(*features.MetricsServer.Enabled).To(BeFalse())
+		g.Expect(features.Network).To(BeNil())
+		g.Expect(features.Gateway).To(BeNil())
+	})
+
+	t.Run("accepts no features", func(t *testing.T) {
+		features, err := ParseClusterFeatures("")
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(features).To(Equal(ClusterFeatures{}))
+	})
+
+	t.Run("rejects unknown keys", func(t *testing.T) {
+		_, err := ParseClusterFeatures("ingres:\n  enabled: true\n")
+		g.Expect(err).To(MatchError(ContainSubstring("invalid cluster features")))
+	})
+
+	t.Run("rejects contradictory settings", func(t *testing.T) {
+		_, err := ParseClusterFeatures(`
+network:
+  enabled: false
+ingress:
+  enabled: true
+gateway:
+  enabled: true
+local-storage:
+  enabled: false
+  reclaim-policy: Retain
+`)
+		g.Expect(err).To(MatchError(ContainSubstring("ingress requires the network feature")))
+		g.Expect(err).To(MatchError(ContainSubstring("gateway requires the network feature")))
+		g.Expect(err).To(MatchError(ContainSubstring("local-storage is disabled but configured")))
+	})
+
+	t.Run("rejects an invalid load balancer", func(t *testing.T) {
+		_, err := ParseClusterFeatures(`
+load-balancer:
+  enabled: true
+  cidrs: [10.0.0.0/33, 10.0.1.20-10.0.1.10, 10.0.1.1-fd00::1]
+  l2:
+    enabled: false
+    interfaces: ["eth0;reboot"]
+  bgp:
+    enabled: false
+    peer-asn: 64513
+`)
+		g.Expect(err).To(MatchError(ContainSubstring(`load-balancer cidr "10.0.0.0/33" is not a CIDR or an address range`)))
+		g.Expect(err).To(MatchError(ContainSubstring(`load-balancer cidr "10.0.1.20-10.0.1.10" is not a valid address range`)))
+		g.Expect(err).To(MatchError(ContainSubstring(`load-balancer cidr "10.0.1.1-fd00::1" is not a valid address range`)))
+		g.Expect(err).To(MatchError(ContainSubstring("announces its addresses nowhere")))
+		g.Expect(err).To(MatchError(ContainSubstring("l2 is disabled but has interfaces")))
+		g.Expect(err).To(MatchError(ContainSubstring(`invalid load-balancer l2 interface "eth0;reboot"`)))
+		g.Expect(err).To(MatchError(ContainSubstring("bgp is disabled but configured")))
+	})
+
+	t.Run("rejects an incomplete bgp peering", func(t *testing.T) {
+		_, err := ParseClusterFeatures(`
+load-balancer:
+  enabled: true
+  cidrs: [10.0.0.0/28]
+  bgp:
+    enabled: true
+    local-asn: 0
+    peer-address: router
+    peer-port: 70000
+`)
+		g.Expect(err).To(MatchError(ContainSubstring("requires a local-asn")))
+		g.Expect(err).To(MatchError(ContainSubstring("requires a peer-asn")))
+		g.Expect(err).To(MatchError(ContainSubstring(`peer-address "router" is not an IP address`)))
+		g.Expect(err).To(MatchError(ContainSubstring("requires a peer-port")))
+	})
+
+	t.Run("rejects an enabled load balancer without cidrs", func(t *testing.T) {
+		_, err := ParseClusterFeatures("load-balancer:\n  enabled: true\n")
+		g.Expect(err).To(MatchError(ContainSubstring("load-balancer requires cidrs")))
+	})
+
+	t.Run("rejects invalid local storage", func(t *testing.T) {
+		_, err := ParseClusterFeatures("local-storage:\n  enabled: true\n  local-path: data\n  reclaim-policy: Keep\n")
+		g.Expect(err).To(MatchError(ContainSubstring(`local-path "data" is not an absolute path`)))
+		g.Expect(err).To(MatchError(ContainSubstring(`unknown local-storage reclaim-policy "Keep"`)))
+	})
+}
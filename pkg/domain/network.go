@@ -0,0 +1,121 @@
+package domain
+
+import (
+	"net/netip"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Stack is the set of IP families a cluster network is made of.
+type Stack string
+
+const (
+	StackIPv4 Stack = "ipv4"
+	StackIPv6 Stack = "ipv6"
+	StackDual Stack = "dual"
+)
+
+// ClusterNetwork holds the validated pod and service CIDRs of a cluster, at most
+// one per IP family.
+type ClusterNetwork struct {
+	PodCIDRs     []netip.Prefix
+	ServiceCIDRs []netip.Prefix
+}
+
+// ClusterNetwork parses and validates the pod and service CIDRs of the cluster.
+// Both must be made of the same IP families.
+func (c *ClusterContext) ClusterNetwork() (ClusterNetwork, error) {
+	pods, err := ParseCidrPair(c.ClusterCidr)
+	if err != nil {
+		return ClusterNetwork{}, errors.Wrap(err, "invalid pod CIDR")
+	}
+
+	services, err := ParseCidrPair(c.ServiceCidr)
+	if err != nil {
+		return ClusterNetwork{}, errors.Wrap(err, "invalid service CIDR")
+	}
+
+	if len(pods) > 0 && len(services) > 0 && stackOf(pods) != stackOf(services) {
+		return ClusterNetwork{}, errors.Errorf("pod CIDR %q and service CIDR %q are not of the same IP families", c.ClusterCidr, c.ServiceCidr)
+	}
+	return ClusterNetwork{PodCIDRs: pods, ServiceCIDRs: services}, nil
+}
+
+// Stack returns the IP families of the network, IPv4 when no CIDR is set.
+func (n ClusterNetwork) Stack() Stack {
+	if len(n.PodCIDRs) > 0 {
+		return stackOf(n.PodCIDRs)
+	}
+	return stackOf(n.ServiceCIDRs)
+}
+
+// ParseCidrPair parses a single CIDR or a comma separated dual-stack pair.
+func ParseCidrPair(cidrs string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, cidr := range splitPair(cidrs) {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix)
+	}
+
+	if len(prefixes) > 2 {
+		return nil, errors.Errorf("%q has more than one CIDR per IP family", cidrs)
+	}
+	if len(prefixes) == 2 && prefixes[0].Addr().Is6() == prefixes[1].Addr().Is6() {
+		return nil, errors.Errorf("%q has more than one CIDR per IP family", cidrs)
+	}
+	return prefixes, nil
+}
+
+// ParseAddressPair parses a single IP address or a comma separated dual-stack pair.
+func ParseAddressPair(addresses string) ([]netip.Addr, error) {
+	var addrs []netip.Addr
+	for _, address := range splitPair(addresses) {
+		addr, err := netip.ParseAddr(strings.Trim(address, "[]"))
+		if err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, addr)
+	}
+
+	if len(addrs) > 2 {
+		return nil, errors.Errorf("%q has more than one address per IP family", addresses)
+	}
+	if len(addrs) == 2 && addrs[0].Is6() == addrs[1].Is6() {
+		return nil, errors.Errorf("%q has more than one address per IP family", addresses)
+	}
+	return addrs, nil
+}
+
+func stackOf(prefixes []netip.Prefix) Stack {
+	ipv4, ipv6 := false, false
+	for _, prefix := range prefixes {
+		if prefix.Addr().Is6() {
+			ipv6 = true
+		} else {
+			ipv4 = true
+		}
+	}
+
+	switch {
+	case ipv4 && ipv6:
+		return StackDual
+	case ipv6:
+		return StackIPv6
+	default:
+		return StackIPv4
+	}
+}
+
+func splitPair(values string) []string {
+	var result []string
+	for _, value := range strings.Split(values, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			result = append(result, value)
+		}
+	}
+	return result
+}
@@ -0,0 +1,68 @@
+package domain
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestClusterNetwork(t *testing.T) {
+	g := NewWithT(t)
+
+	t.Run("parses an ipv4 network", func(t *testing.T) {
+		ctx := &ClusterContext{ClusterCidr: "10.244.0.0/16", ServiceCidr: "10.96.0.0/12"}
+		network, err := ctx.ClusterNetwork()
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(network.Stack()).To(Equal(StackIPv4))
+	})
+
+	t.Run("parses an ipv6 network", func(t *testing.T) {
+		ctx := &ClusterContext{ClusterCidr: "fd00:10:244::/56", ServiceCidr: "fd98::/108"}
+		network, err := ctx.ClusterNetwork()
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(network.Stack()).To(Equal(StackIPv6))
+	})
+
+	t.Run("parses a dual-stack network", func(t *testing.T) {
+		ctx := &ClusterContext{ClusterCidr: "10.244.0.0/16, fd00:10:244::/56", ServiceCidr: "fd98::/108,10.96.0.0/12"}
+		network, err := ctx.ClusterNetwork()
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(network.Stack()).To(Equal(StackDual))
+		g.Expect(network.PodCIDRs).To(HaveLen(2))
+		g.Expect(network.ServiceCIDRs).To(HaveLen(2))
+	})
+
+	t.Run("rejects mismatched families", func(t *testing.T) {
+		ctx := &ClusterContext{ClusterCidr: "10.244.0.0/16,fd00:10:244::/56", ServiceCidr: "10.96.0.0/12"}
+		_, err := ctx.ClusterNetwork()
+		g.Expect(err).To(MatchError(ContainSubstring("not of the same IP families")))
+	})
+
+	t.Run("rejects two CIDRs of the same family", func(t *testing.T) {
+		ctx := &ClusterContext{ClusterCidr: "10.244.0.0/16,10.245.0.0/16", ServiceCidr: "10.96.0.0/12"}
+		_, err := ctx.ClusterNetwork()
+		g.Expect(err).To(MatchError(ContainSubstring("more than one CIDR per IP family")))
+	})
+
+	t.Run("rejects invalid CIDRs", func(t *testing.T) {
+		ctx := &ClusterContext{ClusterCidr: "10.244.0.0", ServiceCidr: "10.96.0.0/12"}
+		_, err := ctx.ClusterNetwork()
+		g.Expect(err).To(MatchError(ContainSubstring("invalid pod CIDR")))
+	})
+}
+
+func TestParseAddressPair(t *testing.T) {
+	g := NewWithT(t)
+
+	t.Run("parses a dual-stack pair", func(t *testing.T) {
+		addrs, err := ParseAddressPair("10.0.0.5,[fd00::5]")
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(addrs).To(HaveLen(2))
+		g.Expect(addrs[1].String()).To(Equal("fd00::5"))
+	})
+
+	t.Run("rejects two addresses of the same family", func(t *testing.T) {
+		_, err := ParseAddressPair("10.0.0.5,10.0.0.6")
+		g.Expect(err).To(HaveOccurred())
+	})
+}
@@ -0,0 +1,140 @@
+package domain
+
+import (
+	stderrors "errors"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	qualifiedNameRegexp = regexp.MustCompile(`^([A-Za-z0-9][-A-Za-z0-9_.]*)?[A-Za-z0-9]$`)
+	dnsSubdomainRegexp  = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+
+	// kubeletLabels and kubeletLabelNamespaces are the kubernetes.io labels a kubelet
+	// may set on its own node, see NodeRestriction.
+	kubeletLabels = map[string]bool{
+		"kubernetes.io/hostname":                   true,
+		"kubernetes.io/arch":                       true,
+		"kubernetes.io/os":                         true,
+		"beta.kubernetes.io/arch":                  true,
+		"beta.kubernetes.io/os":                    true,
+		"beta.kubernetes.io/instance-type":         true,
+		"node.kubernetes.io/instance-type":         true,
+		"topology.kubernetes.io/region":            true,
+		"topology.kubernetes.io/zone":              true,
+		"failure-domain.beta.kubernetes.io/region": true,
+		"failure-domain.beta.kubernetes.io/zone":   true,
+	}
+	kubeletLabelNamespaces = []string{"kubelet.kubernetes.io", "node.kubernetes.io"}
+
+	taintEffects = map[string]bool{"NoSchedule": true, "PreferNoSchedule": true, "NoExecute": true}
+)
+
+// ParseNodeLabels parses comma or newline separated key=value node labels, in the
+// format of the kubelet --node-labels flag. Invalid labels are left out and
+// reported in the returned error.
+func ParseNodeLabels(labels string) ([]string, error) {
+	var valid []string
+	var errs []error
+
+	for _, label := range splitList(labels) {
+		key, value, _ := strings.Cut(label, "=")
+		if err := validateLabelKey(key); err != nil {
+			errs = append(errs, errors.Wrapf(err, "invalid node label %q", label))
+			continue
+		}
+		if err := validateLabelValue(value); err != nil {
+			errs = append(errs, errors.Wrapf(err, "invalid node label %q", label))
+			continue
+		}
+		if isRestrictedLabel(key) {
+			errs = append(errs, errors.Errorf("node label %q is in a namespace the kubelet may not set", label))
+			continue
+		}
+		valid = append(valid, key+"="+value)
+	}
+	return valid, stderrors.Join(errs...)
+}
+
+// ParseNodeTaints parses comma or newline separated key[=value]:Effect taints, in
+// the format of the kubelet --register-with-taints flag. Invalid taints are left
+// out and reported in the returned error.
+func ParseNodeTaints(taints string) ([]string, error) {
+	var valid []string
+	var errs []error
+
+	for _, taint := range splitList(taints) {
+		keyValue, effect, ok := strings.Cut(taint, ":")
+		if !ok || !taintEffects[effect] {
+			errs = append(errs, errors.Errorf("invalid node taint %q: effect must be NoSchedule, PreferNoSchedule or NoExecute", taint))
+			continue
+		}
+		key, value, _ := strings.Cut(keyValue, "=")
+		if err := validateLabelKey(key); err != nil {
+			errs = append(errs, errors.Wrapf(err, "invalid node taint %q", taint))
+			continue
+		}
+		if err := validateLabelValue(value); err != nil {
+			errs = append(errs, errors.Wrapf(err, "invalid node taint %q", taint))
+			continue
+		}
+		valid = append(valid, taint)
+	}
+	return valid, stderrors.Join(errs...)
+}
+
+func validateLabelKey(key string) error {
+	prefix, name, found := strings.Cut(key, "/")
+	if !found {
+		prefix, name = "", key
+	} else if prefix == "" || len(prefix) > 253 || !dnsSubdomainRegexp.MatchString(prefix) {
+		return errors.Errorf("key prefix %q must be a DNS subdomain", prefix)
+	}
+
+	if len(name) == 0 || len(name) > 63 || !qualifiedNameRegexp.MatchString(name) {
+		return errors.Errorf("key name %q must be 63 characters or less, alphanumeric, '-', '_' or '.'", name)
+	}
+	return nil
+}
+
+func validateLabelValue(value string) error {
+	if value == "" {
+		return nil
+	}
+	if len(value) > 63 || !qualifiedNameRegexp.MatchString(value) {
+		return errors.Errorf("value %q must be 63 characters or less, alphanumeric, '-', '_' or '.'", value)
+	}
+	return nil
+}
+
+// isRestrictedLabel reports whether key is in the kubernetes.io or k8s.io
+// namespaces without being one of the labels the kubelet may set.
+func isRestrictedLabel(key string) bool {
+	namespace, _, found := strings.Cut(key, "/")
+	if !found || kubeletLabels[key] {
+		return false
+	}
+	for _, allowed := range kubeletLabelNamespaces {
+		if namespace == allowed || strings.HasSuffix(namespace, "."+allowed) {
+			return false
+		}
+	}
+	for _, restricted := range []string{"kubernetes.io", "k8s.io"} {
+		if namespace == restricted || strings.HasSuffix(namespace, "."+restricted) {
+			return true
+		}
+	}
+	return false
+}
+
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.FieldsFunc(list, func(r rune) bool { return r == ',' || r == '\n' }) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
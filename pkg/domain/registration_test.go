@@ -0,0 +1,53 @@
+package domain
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestParseNodeLabels(t *testing.T) {
+	g := NewWithT(t)
+
+	t.Run("accepts labels the kubelet may set", func(t *testing.T) {
+		labels, err := ParseNodeLabels("env=prod, example.com/rack=r1\ntopology.kubernetes.io/zone=zone-a,node.kubernetes.io/pool=gpu,edge=")
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(labels).To(Equal([]string{
+			"env=prod",
+			"example.com/rack=r1",
+			"topology.kubernetes.io/zone=zone-a",
+			"node.kubernetes.io/pool=gpu",
+			"edge=",
+		}))
+	})
+
+	t.Run("rejects invalid and restricted labels", func(t *testing.T) {
+		labels, err := ParseNodeLabels("env=prod,node-role.kubernetes.io/worker=,foo.k8s.io/bar=baz,-bad=x,Example.com/x=y,key=bad value")
+		g.Expect(labels).To(Equal([]string{"env=prod"}))
+		g.Expect(err).To(MatchError(ContainSubstring(`"node-role.kubernetes.io/worker=" is in a namespace the kubelet may not set`)))
+		g.Expect(err).To(MatchError(ContainSubstring(`"foo.k8s.io/bar=baz" is in a namespace`)))
+		g.Expect(err).To(MatchError(ContainSubstring(`invalid node label "-bad=x"`)))
+		g.Expect(err).To(MatchError(ContainSubstring(`invalid node label "Example.com/x=y"`)))
+		g.Expect(err).To(MatchError(ContainSubstring(`invalid node label "key=bad value"`)))
+	})
+
+	t.Run("accepts no labels", func(t *testing.T) {
+		labels, err := ParseNodeLabels("")
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(labels).To(BeEmpty())
+	})
+}
+
+func TestParseNodeTaints(t *testing.T) {
+	g := NewWithT(t)
+
+	taints, err := ParseNodeTaints("dedicated=gpu:NoSchedule,example.com/maintenance:NoExecute,spot:PreferNoSchedule")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(taints).To(Equal([]string{"dedicated=gpu:NoSchedule", "example.com/maintenance:NoExecute", "spot:PreferNoSchedule"}))
+
+	taints, err = ParseNodeTaints("dedicated=gpu:NoSchedule,dedicated=gpu,spot:Sometimes,bad key:NoSchedule")
+	g.Expect(taints).To(Equal([]string{"dedicated=gpu:NoSchedule"}))
+	g.Expect(err).To(MatchError(ContainSubstring(`invalid node taint "dedicated=gpu": effect must be`)))
+	g.Expect(err).To(MatchError(ContainSubstring(`invalid node taint "spot:Sometimes"`)))
+	g.Expect(err).To(MatchError(ContainSubstring(`invalid node taint "bad key:NoSchedule"`)))
+}
@@ -0,0 +1,57 @@
+package images
+
+import (
+	"archive/tar"
+	"io"
+	"io/fs"
+	"path/filepath"
+
+	"github.com/twpayne/go-vfs/v4"
+)
+
+// writeLayoutArchive writes an OCI image layout dir as an OCI archive, a tar with
+// the layout at its root, which containerd imports like a docker archive.
+func writeLayoutArchive(root vfs.FS, layout string, w io.Writer) error {
+	tw := tar.NewWriter(w)
+
+	err := vfs.Walk(root, layout, func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == layout || !(info.IsDir() || info.Mode().IsRegular()) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(layout, path)
+		if err != nil {
+			return err
+		}
+
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		if err = tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		f, err := root.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.CopyN(tw, f, header.Size)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
@@ -0,0 +1,401 @@
+package images
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/kairos-io/provider-canonical/pkg/domain"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/twpayne/go-vfs/v4"
+)
+
+const (
+	// ManifestFile is the optional sha256sum compatible manifest of the archives in
+	// the local images dir.
+	ManifestFile = "SHA256SUMS"
+
+	ociLayoutFile = "oci-layout"
+	maxDepth      = 16
+)
+
+type Format string
+
+const (
+	FormatTar       Format = "tar"
+	FormatTarGzip   Format = "tar+gzip"
+	FormatTarZstd   Format = "tar+zstd"
+	FormatOCILayout Format = "oci-layout"
+)
+
+// Image is a local image archive or OCI image layout found in the images dir.
+type Image struct {
+	Path   string
+	Format Format
+	// Name is the relative path of the image in the images dir.
+	Name string
+}
+
+// Report lists the images by name according to the import outcome.
+type Report struct {
+	Imported []string
+	Skipped  []string
+	Failed   map[string]error
+}
+
+// Import places every image of sourceDir into the k8s snap images dir as a plain
+// tar archive, which the snap then imports into containerd. Images are tracked by
+// digest in the imported images state, so an image is only imported again once
+// its archive is gone from the k8s snap images dir.
+// Signatures are checked by verifier first, on every import. The entries of the
+// manifest that match no image fail as well.
+func Import(root vfs.FS, sourceDir string, verifier Verifier) (Report, error) {
+	report := Report{Failed: map[string]error{}}
+
+	images, err := Discover(root, sourceDir)
+	if err != nil {
+		return report, err
+	}
+
+	manifest, err := readManifest(root, filepath.Join(sourceDir, ManifestFile))
+	if err != nil {
+		return report, err
+	}
+
+	state, err := readState(root)
+	if err != nil {
+		return report, err
+	}
+
+	if err = vfs.MkdirAll(root, domain.K8sImagesDir, 0755); err != nil {
+		return report, errors.Wrap(err, "failed to create images dir")
+	}
+
+	collisions := targetCollisions(images)
+	found := map[string]bool{}
+	for _, image := range images {
+		found[image.Name] = true
+		if other, ok := collisions[image.Name]; ok {
+			report.Failed[image.Name] = errors.Errorf("its archive %s would replace the one of %s", targetName(image), other)
+			continue
+		}
+
+		expected, ok := manifest[image.Name]
+		if ok && image.Format == FormatOCILayout {
+			report.Failed[image.Name] = errors.Errorf("OCI layouts have no checksum in %s, their blobs are checked against their digests", ManifestFile)
+			continue
+		} else if manifest != nil && !ok && image.Format != FormatOCILayout {
+			logrus.Warnf("image %s is not listed in %s", image.Name, ManifestFile)
+		}
+
+		digest, err := imageDigest(root, image)
+		if err != nil {
+			report.Failed[image.Name] = err
+			continue
+		}
+
+		if ok && expected != digest {
+			report.Failed[image.Name] = errors.Errorf("checksum mismatch: expected %s, got %s", expected, digest)
+			continue
+		}
+
+		if verifier.Enabled() {
+			if err = verifier.Verify(root, image); err != nil && verifier.Policy == domain.SignaturePolicyEnforce {
+				report.Failed[image.Name] = errors.Wrap(err, "signature verification failed")
+				continue
+			} else if err != nil {
+				logrus.Warnf("importing image %s despite failed signature verification: %v", image.Name, err)
+			}
+		}
+
+		// the images dir is emptied when the snap is purged, so the state alone
+		// does not tell the image is still there to be imported
+		target := filepath.Join(domain.K8sImagesDir, targetName(image))
+		if _, ok := state[digest]; ok {
+			if _, err = root.Stat(target); err == nil {
+				report.Skipped = append(report.Skipped, image.Name)
+				continue
+			}
+		}
+
+		if err = importImage(root, image, target); err != nil {
+			_ = root.Remove(target)
+			report.Failed[image.Name] = err
+			continue
+		}
+
+		state[digest] = image.Name
+		report.Imported = append(report.Imported, image.Name)
+	}
+
+	for name := range manifest {
+		if !found[name] {
+			report.Failed[name] = errors.Errorf("listed in %s but not found in %s", ManifestFile, sourceDir)
+		}
+	}
+
+	if err = writeState(root, state); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// Discover returns the images under dir, following symlinks. OCI image layouts are
+// recognized by their oci-layout file and are not descended into.
+func Discover(root vfs.FS, dir string) ([]Image, error) {
+	var images []Image
+	if err := discover(root, dir, "", 0, &images); err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+func discover(root vfs.FS, dir, name string, depth int, images *[]Image) error {
+	if depth > maxDepth {
+		return errors.Errorf("%s is nested too deep", dir)
+	}
+
+	entries, err := root.ReadDir(dir)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read %s", dir)
+	}
+
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		entryName := filepath.Join(name, entry.Name())
+
+		info, err := root.Stat(path)
+		if err != nil {
+			logrus.Warnf("skipping %s: %v", path, err)
+			continue
+		}
+
+		if info.IsDir() {
+			if _, err = root.Stat(filepath.Join(path, ociLayoutFile)); err == nil {
+				*images = append(*images, Image{Path: path, Format: FormatOCILayout, Name: entryName})
+				continue
+			}
+			if err = discover(root, path, entryName, depth+1, images); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if format, ok := archiveFormat(entry.Name()); ok {
+			*images = append(*images, Image{Path: path, Format: format, Name: entryName})
+		}
+	}
+	return nil
+}
+
+func archiveFormat(name string) (Format, bool) {
+	switch {
+	case strings.HasSuffix(name, ".tar"):
+		return FormatTar, true
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return FormatTarGzip, true
+	case strings.HasSuffix(name, ".tar.zst"), strings.HasSuffix(name, ".tar.zstd"):
+		return FormatTarZstd, true
+	default:
+		return "", false
+	}
+}
+
+// targetName flattens the image name into a single tar file name.
+func targetName(image Image) string {
+	name := image.Name
+	for _, suffix := range []string{".tar.gz", ".tgz", ".tar.zst", ".tar.zstd", ".tar"} {
+		if strings.HasSuffix(name, suffix) {
+			name = strings.TrimSuffix(name, suffix)
+			break
+		}
+	}
+	return strings.ReplaceAll(name, string(filepath.Separator), "_") + ".tar"
+}
+
+// targetCollisions maps the names of the images sharing their target name, such
+// as a/b.tar and a_b.tar or x.tar and x.tar.gz, to another image of the same
+// target. Neither is imported, rather than one silently replacing the other.
+func targetCollisions(images []Image) map[string]string {
+	byTarget := map[string][]string{}
+	for _, image := range images {
+		target := targetName(image)
+		byTarget[target] = append(byTarget[target], image.Name)
+	}
+
+	collisions := map[string]string{}
+	for _, names := range byTarget {
+		if len(names) < 2 {
+			continue
+		}
+		for i, name := range names {
+			collisions[name] = names[(i+1)%len(names)]
+		}
+	}
+	return collisions
+}
+
+// imageDigest returns the sha256 of an archive, or of the index of an OCI layout
+// after checking its blobs against their digests.
+func imageDigest(root vfs.FS, image Image) (string, error) {
+	if image.Format == FormatOCILayout {
+		if err := verifyOCIBlobs(root, image.Path); err != nil {
+			return "", err
+		}
+		return fileDigest(root, filepath.Join(image.Path, "index.json"))
+	}
+	return fileDigest(root, image.Path)
+}
+
+func fileDigest(root vfs.FS, path string) (string, error) {
+	f, err := root.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err = io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func verifyOCIBlobs(root vfs.FS, layout string) error {
+	blobsDir := filepath.Join(layout, "blobs", "sha256")
+	entries, err := root.ReadDir(blobsDir)
+	if err != nil {
+		return errors.Wrap(err, "invalid OCI layout")
+	}
+
+	for _, entry := range entries {
+		digest, err := fileDigest(root, filepath.Join(blobsDir, entry.Name()))
+		if err != nil {
+			return err
+		}
+		if digest != entry.Name() {
+			return errors.Errorf("OCI blob sha256:%s is corrupt", entry.Name())
+		}
+	}
+	return nil
+}
+
+func importImage(root vfs.FS, image Image, target string) error {
+	out, err := root.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if image.Format == FormatOCILayout {
+		return writeLayoutArchive(root, image.Path, out)
+	}
+
+	in, err := root.Open(image.Path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	var reader io.Reader = in
+	switch image.Format {
+	case FormatTarGzip:
+		gz, err := gzip.NewReader(in)
+		if err != nil {
+			return errors.Wrap(err, "failed to decompress gzip archive")
+		}
+		defer gz.Close()
+		reader = gz
+	case FormatTarZstd:
+		zr, err := zstd.NewReader(in)
+		if err != nil {
+			return errors.Wrap(err, "failed to decompress zstd archive")
+		}
+		defer zr.Close()
+		reader = zr
+	}
+
+	if _, err = io.Copy(out, reader); err != nil {
+		return errors.Wrap(err, "failed to write image archive")
+	}
+	return out.Sync()
+}
+
+func readManifest(root vfs.FS, path string) (map[string]string, error) {
+	content, err := root.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read images manifest")
+	}
+
+	manifest := map[string]string{}
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		// sha256sum marks binary mode entries with a leading '*'
+		manifest[filepath.Clean(strings.TrimPrefix(fields[1], "*"))] = strings.ToLower(fields[0])
+	}
+	return manifest, nil
+}
+
+func readState(root vfs.FS) (map[string]string, error) {
+	state := map[string]string{}
+
+	content, err := root.ReadFile(domain.ImportedImagesStateFile)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read imported images state")
+	}
+
+	if err = json.Unmarshal(content, &state); err != nil {
+		logrus.Warnf("discarding corrupt imported images state: %v", err)
+		return map[string]string{}, nil
+	}
+	return state, nil
+}
+
+func writeState(root vfs.FS, state map[string]string) error {
+	if err := vfs.MkdirAll(root, filepath.Dir(domain.ImportedImagesStateFile), 0700); err != nil {
+		return err
+	}
+	content, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return root.WriteFile(domain.ImportedImagesStateFile, content, 0600)
+}
+
+// Log reports the import outcome in the provider log.
+func (r Report) Log() {
+	for _, name := range r.Imported {
+		logrus.Infof("imported image %s", name)
+	}
+	for _, name := range r.Skipped {
+		logrus.Infof("skipped image %s, already imported", name)
+	}
+
+	failed := make([]string, 0, len(r.Failed))
+	for name := range r.Failed {
+		failed = append(failed, name)
+	}
+	sort.Strings(failed)
+	for _, name := range failed {
+		logrus.Errorf("failed to import image %s: %v", name, r.Failed[name])
+	}
+
+	logrus.Infof("image import: %d imported, %d skipped, %d failed", len(r.Imported), len(r.Skipped), len(r.Failed))
+}
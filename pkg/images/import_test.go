@@ -0,0 +1,205 @@
+package images
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"path/filepath"
+	"testing"
+
+	"github.com/kairos-io/provider-canonical/pkg/domain"
+	"github.com/klauspost/compress/zstd"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4/vfst"
+)
+
+const imagesDir = "/opt/canonical/images"
+
+func TestImport(t *testing.T) {
+	g := NewWithT(t)
+
+	plain := testArchive(g, "manifest.json", "pause")
+	blob := "layer"
+	blobSum := sha256sum(blob)
+
+	t.Run("imports tar, gzip, zstd and OCI layout images", func(t *testing.T) {
+		testFS, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+			filepath.Join(imagesDir, "pause.tar"):                     plain,
+			filepath.Join(imagesDir, "coredns.tar.gz"):                gzipped(g, plain),
+			filepath.Join(imagesDir, "cilium/agent.tar.zst"):          zstded(g, plain),
+			filepath.Join(imagesDir, "metrics/oci-layout"):            `{"imageLayoutVersion":"1.0.0"}`,
+			filepath.Join(imagesDir, "metrics/index.json"):            `{"schemaVersion":2}`,
+			filepath.Join(imagesDir, "metrics/blobs/sha256", blobSum): blob,
+			filepath.Join(imagesDir, "README.md"):                     "ignored",
+		})
+		g.Expect(err).NotTo(HaveOccurred())
+		defer cleanup()
+
+		report, err := Import(testFS, imagesDir, Verifier{})
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(report.Failed).To(BeEmpty())
+		g.Expect(report.Imported).To(ConsistOf("pause.tar", "coredns.tar.gz", "cilium/agent.tar.zst", "metrics"))
+
+		for _, name := range []string{"pause.tar", "coredns.tar", "cilium_agent.tar"} {
+			content, err := testFS.ReadFile(filepath.Join(domain.K8sImagesDir, name))
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(string(content)).To(Equal(plain))
+		}
+
+		content, err := testFS.ReadFile(filepath.Join(domain.K8sImagesDir, "metrics.tar"))
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(tarFiles(g, content)).To(HaveKeyWithValue("blobs/sha256/"+blobSum, blob))
+		g.Expect(tarFiles(g, content)).To(HaveKey("oci-layout"))
+
+		report, err = Import(testFS, imagesDir, Verifier{})
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(report.Imported).To(BeEmpty())
+		g.Expect(report.Skipped).To(HaveLen(4))
+
+		// a purged snap leaves the state behind but not the images
+		g.Expect(testFS.RemoveAll(domain.K8sImagesDir)).To(Succeed())
+		report, err = Import(testFS, imagesDir, Verifier{})
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(report.Skipped).To(BeEmpty())
+		g.Expect(report.Imported).To(HaveLen(4))
+	})
+
+	t.Run("fails images not matching the manifest", func(t *testing.T) {
+		testFS, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+			filepath.Join(imagesDir, "pause.tar"): plain,
+			filepath.Join(imagesDir, "other.tar"): plain,
+			filepath.Join(imagesDir, ManifestFile): sha256sum(plain) + "  pause.tar\n" +
+				sha256sum("tampered") + " *other.tar\n",
+		})
+		g.Expect(err).NotTo(HaveOccurred())
+		defer cleanup()
+
+		report, err := Import(testFS, imagesDir, Verifier{})
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(report.Imported).To(ConsistOf("pause.tar"))
+		g.Expect(report.Failed).To(HaveKey("other.tar"))
+
+		_, err = testFS.Stat(filepath.Join(domain.K8sImagesDir, "other.tar"))
+		g.Expect(err).To(HaveOccurred())
+	})
+
+	t.Run("fails the manifest entries of OCI layouts and of missing images", func(t *testing.T) {
+		testFS, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+			filepath.Join(imagesDir, "pause.tar"):                     plain,
+			filepath.Join(imagesDir, "metrics/oci-layout"):            `{"imageLayoutVersion":"1.0.0"}`,
+			filepath.Join(imagesDir, "metrics/index.json"):            `{"schemaVersion":2}`,
+			filepath.Join(imagesDir, "metrics/blobs/sha256", blobSum): blob,
+			filepath.Join(imagesDir, ManifestFile): sha256sum(plain) + "  pause.tar\n" +
+				sha256sum(`{"schemaVersion":2}`) + "  metrics\n" +
+				sha256sum(plain) + "  coredns.tar\n",
+		})
+		g.Expect(err).NotTo(HaveOccurred())
+		defer cleanup()
+
+		report, err := Import(testFS, imagesDir, Verifier{})
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(report.Imported).To(ConsistOf("pause.tar"))
+		g.Expect(report.Failed).To(HaveKeyWithValue("metrics", MatchError(ContainSubstring("OCI layouts have no checksum"))))
+		g.Expect(report.Failed).To(HaveKeyWithValue("coredns.tar", MatchError("listed in SHA256SUMS but not found in "+imagesDir)))
+	})
+
+	t.Run("fails images imported under the same archive name", func(t *testing.T) {
+		testFS, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+			filepath.Join(imagesDir, "cilium/agent.tar"): plain,
+			filepath.Join(imagesDir, "cilium_agent.tar"): plain,
+			filepath.Join(imagesDir, "pause.tar"):        plain,
+			filepath.Join(imagesDir, "pause.tar.gz"):     gzipped(g, plain),
+			filepath.Join(imagesDir, "coredns.tar"):      plain,
+		})
+		g.Expect(err).NotTo(HaveOccurred())
+		defer cleanup()
+
+		report, err := Import(testFS, imagesDir, Verifier{})
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(report.Imported).To(ConsistOf("coredns.tar"))
+		g.Expect(report.Failed).To(HaveKeyWithValue("cilium/agent.tar", MatchError("its archive cilium_agent.tar would replace the one of cilium_agent.tar")))
+		g.Expect(report.Failed).To(HaveKeyWithValue("cilium_agent.tar", MatchError("its archive cilium_agent.tar would replace the one of cilium/agent.tar")))
+		g.Expect(report.Failed).To(HaveKey("pause.tar"))
+		g.Expect(report.Failed).To(HaveKey("pause.tar.gz"))
+
+		vfst.RunTests(t, testFS, "",
+			vfst.TestPath(filepath.Join(domain.K8sImagesDir, "cilium_agent.tar"), vfst.TestDoesNotExist),
+			vfst.TestPath(filepath.Join(domain.K8sImagesDir, "pause.tar"), vfst.TestDoesNotExist),
+		)
+	})
+
+	t.Run("fails OCI layouts with corrupt blobs and broken archives", func(t *testing.T) {
+		testFS, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+			filepath.Join(imagesDir, "metrics/oci-layout"):            `{"imageLayoutVersion":"1.0.0"}`,
+			filepath.Join(imagesDir, "metrics/index.json"):            `{"schemaVersion":2}`,
+			filepath.Join(imagesDir, "metrics/blobs/sha256", blobSum): "corrupt",
+			filepath.Join(imagesDir, "broken.tar.gz"):                 "not gzip",
+		})
+		g.Expect(err).NotTo(HaveOccurred())
+		defer cleanup()
+
+		report, err := Import(testFS, imagesDir, Verifier{})
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(report.Imported).To(BeEmpty())
+		g.Expect(report.Failed).To(HaveKey("metrics"))
+		g.Expect(report.Failed).To(HaveKey("broken.tar.gz"))
+
+		vfst.RunTests(t, testFS, "",
+			vfst.TestPath(filepath.Join(domain.K8sImagesDir, "broken.tar"), vfst.TestDoesNotExist),
+		)
+	})
+}
+
+func testArchive(g *WithT, name, content string) string {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	g.Expect(tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg})).To(Succeed())
+	_, err := tw.Write([]byte(content))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(tw.Close()).To(Succeed())
+	return buf.String()
+}
+
+func tarFiles(g *WithT, content []byte) map[string]string {
+	files := map[string]string{}
+	tr := tar.NewReader(bytes.NewReader(content))
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			break
+		}
+		if header.Typeflag == tar.TypeReg {
+			var buf bytes.Buffer
+			_, err = buf.ReadFrom(tr)
+			g.Expect(err).NotTo(HaveOccurred())
+			files[header.Name] = buf.String()
+		}
+	}
+	return files
+}
+
+func gzipped(g *WithT, content string) string {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, err := gz.Write([]byte(content))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(gz.Close()).To(Succeed())
+	return buf.String()
+}
+
+func zstded(g *WithT, content string) string {
+	var buf bytes.Buffer
+	zw, err := zstd.NewWriter(&buf)
+	g.Expect(err).NotTo(HaveOccurred())
+	_, err = zw.Write([]byte(content))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(zw.Close()).To(Succeed())
+	return buf.String()
+}
+
+func sha256sum(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
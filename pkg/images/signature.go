@@ -0,0 +1,138 @@
+package images
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kairos-io/provider-canonical/pkg/domain"
+	"github.com/pkg/errors"
+	"github.com/twpayne/go-vfs/v4"
+)
+
+// SignatureSuffix is appended to an image archive or OCI layout dir name to find
+// its signature, as written by `cosign sign-blob --output-signature`.
+const SignatureSuffix = ".sig"
+
+// Verifier checks the cosign blob signatures of the local images against a
+// public key. The zero value verifies nothing.
+type Verifier struct {
+	Policy string
+	Key    crypto.PublicKey
+}
+
+// NewVerifier returns a verifier for policy, loading the PEM public key at keyPath
+// unless the policy is off.
+func NewVerifier(root vfs.FS, policy, keyPath string) (Verifier, error) {
+	switch policy {
+	case "", domain.SignaturePolicyOff:
+		return Verifier{Policy: domain.SignaturePolicyOff}, nil
+	case domain.SignaturePolicyWarn, domain.SignaturePolicyEnforce:
+	default:
+		return Verifier{}, errors.Errorf("unknown signature policy %q", policy)
+	}
+
+	verifier := Verifier{Policy: policy}
+	if keyPath == "" {
+		return verifier, errors.New("no public key to verify image signatures with")
+	}
+
+	content, err := root.ReadFile(keyPath)
+	if err != nil {
+		return verifier, errors.Wrap(err, "failed to read image signing key")
+	}
+	verifier.Key, err = ParsePublicKey(string(content))
+	return verifier, err
+}
+
+// ParsePublicKey parses a PEM encoded ECDSA, RSA or Ed25519 public key, the key
+// types cosign signs with.
+func ParsePublicKey(content string) (crypto.PublicKey, error) {
+	block, _ := pem.Decode([]byte(content))
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, errors.New("no PEM public key found")
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse public key")
+	}
+
+	switch key.(type) {
+	case *ecdsa.PublicKey, *rsa.PublicKey, ed25519.PublicKey:
+		return key, nil
+	default:
+		return nil, errors.Errorf("unsupported public key type %T", key)
+	}
+}
+
+// Enabled reports whether images are verified at all.
+func (v Verifier) Enabled() bool {
+	return v.Policy == domain.SignaturePolicyWarn || v.Policy == domain.SignaturePolicyEnforce
+}
+
+// Verify checks the signature of an image. Archives are signed as is, OCI layouts
+// through their index.json, as the index pins every blob by digest.
+func (v Verifier) Verify(root vfs.FS, image Image) error {
+	if v.Key == nil {
+		return errors.New("no public key to verify image signatures with")
+	}
+
+	encoded, err := root.ReadFile(image.Path + SignatureSuffix)
+	if os.IsNotExist(err) {
+		return errors.New("image is not signed")
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to read image signature")
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encoded)))
+	if err != nil {
+		return errors.Wrap(err, "failed to decode image signature")
+	}
+
+	payload := image.Path
+	if image.Format == FormatOCILayout {
+		payload = filepath.Join(image.Path, "index.json")
+	}
+
+	if key, ok := v.Key.(ed25519.PublicKey); ok {
+		message, err := root.ReadFile(payload)
+		if err != nil {
+			return err
+		}
+		if !ed25519.Verify(key, message, signature) {
+			return errors.New("invalid image signature")
+		}
+		return nil
+	}
+
+	digest, err := fileDigest(root, payload)
+	if err != nil {
+		return err
+	}
+	sum, err := hex.DecodeString(digest)
+	if err != nil {
+		return err
+	}
+
+	switch key := v.Key.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(key, sum, signature) {
+			return errors.New("invalid image signature")
+		}
+	case *rsa.PublicKey:
+		if err = rsa.VerifyPKCS1v15(key, crypto.SHA256, sum, signature); err != nil {
+			return errors.New("invalid image signature")
+		}
+	}
+	return nil
+}
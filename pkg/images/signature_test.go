@@ -0,0 +1,130 @@
+package images
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"path/filepath"
+	"testing"
+
+	"github.com/kairos-io/provider-canonical/pkg/domain"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4/vfst"
+)
+
+func TestVerify(t *testing.T) {
+	g := NewWithT(t)
+
+	archive := testArchive(g, "manifest.json", "pause")
+	sum := sha256.Sum256([]byte(archive))
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	g.Expect(err).NotTo(HaveOccurred())
+	ecSig, err := ecdsa.SignASN1(rand.Reader, ecKey, sum[:])
+	g.Expect(err).NotTo(HaveOccurred())
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	g.Expect(err).NotTo(HaveOccurred())
+	rsaSig, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, sum[:])
+	g.Expect(err).NotTo(HaveOccurred())
+
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	g.Expect(err).NotTo(HaveOccurred())
+	edSig := ed25519.Sign(edKey, []byte(archive))
+
+	for name, tc := range map[string]struct {
+		pub crypto.PublicKey
+		sig []byte
+	}{
+		"ecdsa":   {&ecKey.PublicKey, ecSig},
+		"rsa":     {&rsaKey.PublicKey, rsaSig},
+		"ed25519": {edPub, edSig},
+	} {
+		t.Run(name, func(t *testing.T) {
+			testFS, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/key.pub":                                   publicKeyPEM(g, tc.pub),
+				filepath.Join(imagesDir, "pause.tar"):        archive,
+				filepath.Join(imagesDir, "pause.tar.sig"):    base64.StdEncoding.EncodeToString(tc.sig) + "\n",
+				filepath.Join(imagesDir, "tampered.tar"):     archive + "x",
+				filepath.Join(imagesDir, "tampered.tar.sig"): base64.StdEncoding.EncodeToString(tc.sig),
+				filepath.Join(imagesDir, "unsigned.tar.zst"): zstded(g, archive),
+			})
+			g.Expect(err).NotTo(HaveOccurred())
+			defer cleanup()
+
+			verifier, err := NewVerifier(testFS, domain.SignaturePolicyEnforce, "/key.pub")
+			g.Expect(err).NotTo(HaveOccurred())
+
+			report, err := Import(testFS, imagesDir, verifier)
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(report.Imported).To(ConsistOf("pause.tar"))
+			g.Expect(report.Failed).To(HaveKey("tampered.tar"))
+			g.Expect(report.Failed).To(HaveKey("unsigned.tar.zst"))
+		})
+	}
+
+	t.Run("imports unverified images with the warn policy", func(t *testing.T) {
+		testFS, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+			"/key.pub":                            publicKeyPEM(g, &ecKey.PublicKey),
+			filepath.Join(imagesDir, "pause.tar"): archive,
+		})
+		g.Expect(err).NotTo(HaveOccurred())
+		defer cleanup()
+
+		verifier, err := NewVerifier(testFS, domain.SignaturePolicyWarn, "/key.pub")
+		g.Expect(err).NotTo(HaveOccurred())
+
+		report, err := Import(testFS, imagesDir, verifier)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(report.Imported).To(ConsistOf("pause.tar"))
+	})
+
+	t.Run("verifies OCI layouts through their index", func(t *testing.T) {
+		index := `{"schemaVersion":2}`
+		indexSum := sha256.Sum256([]byte(index))
+		sig, err := ecdsa.SignASN1(rand.Reader, ecKey, indexSum[:])
+		g.Expect(err).NotTo(HaveOccurred())
+
+		testFS, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+			"/key.pub": publicKeyPEM(g, &ecKey.PublicKey),
+			filepath.Join(imagesDir, "metrics/oci-layout"): `{"imageLayoutVersion":"1.0.0"}`,
+			filepath.Join(imagesDir, "metrics/index.json"): index,
+			filepath.Join(imagesDir, "metrics.sig"):        base64.StdEncoding.EncodeToString(sig),
+		})
+		g.Expect(err).NotTo(HaveOccurred())
+		defer cleanup()
+
+		verifier, err := NewVerifier(testFS, domain.SignaturePolicyEnforce, "/key.pub")
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(verifier.Verify(testFS, Image{
+			Path:   filepath.Join(imagesDir, "metrics"),
+			Format: FormatOCILayout,
+			Name:   "metrics",
+		})).To(Succeed())
+	})
+
+	t.Run("rejects unknown policies and missing keys", func(t *testing.T) {
+		_, err := NewVerifier(nil, "audit", "")
+		g.Expect(err).To(HaveOccurred())
+
+		_, err = NewVerifier(nil, domain.SignaturePolicyEnforce, "")
+		g.Expect(err).To(HaveOccurred())
+
+		verifier, err := NewVerifier(nil, domain.SignaturePolicyOff, "")
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(verifier.Enabled()).To(BeFalse())
+	})
+}
+
+func publicKeyPEM(g *WithT, key crypto.PublicKey) string {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	g.Expect(err).NotTo(HaveOccurred())
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
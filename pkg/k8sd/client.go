@@ -0,0 +1,82 @@
+package k8sd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+
+	apiv1 "github.com/canonical/k8s-snap-api/api/v1"
+	"github.com/pkg/errors"
+)
+
+// Client talks to the k8sd API of the node over its unix socket, as the k8s CLI
+// does, for the calls whose arguments must not show on a command line.
+type Client struct {
+	http *http.Client
+}
+
+// NewClient returns a client of the k8sd API served on socketPath.
+func NewClient(socketPath string) *Client {
+	return &Client{
+		http: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var dialer net.Dialer
+					return dialer.DialContext(ctx, "unix", socketPath)
+				},
+			},
+		},
+	}
+}
+
+// response is the envelope of every k8sd API response.
+type response struct {
+	Type       string          `json:"type"`
+	StatusCode int             `json:"status_code"`
+	ErrorCode  int             `json:"error_code"`
+	Error      string          `json:"error"`
+	Metadata   json.RawMessage `json:"metadata"`
+}
+
+// JoinCluster joins the node to the cluster of the request token.
+func (c *Client) JoinCluster(ctx context.Context, request apiv1.JoinClusterRequest) error {
+	return c.post(ctx, apiv1.JoinClusterRPC, request)
+}
+
+func (c *Client) post(ctx context.Context, rpc string, request interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("http://k8sd/%s/%s", apiv1.K8sdAPIVersion, rpc)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "failed to call %s", rpc)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read the response of %s", rpc)
+	}
+
+	var envelope response
+	if err = json.Unmarshal(content, &envelope); err != nil {
+		return errors.Errorf("%s failed with HTTP %d: %s", rpc, resp.StatusCode, bytes.TrimSpace(content))
+	}
+	if envelope.Type == "error" || envelope.Error != "" || resp.StatusCode >= http.StatusBadRequest {
+		return errors.Errorf("%s failed with HTTP %d: %s", rpc, resp.StatusCode, envelope.Error)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package k8sd
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	apiv1 "github.com/canonical/k8s-snap-api/api/v1"
+	. "github.com/onsi/gomega"
+)
+
+func TestJoinCluster(t *testing.T) {
+	g := NewWithT(t)
+
+	var received apiv1.JoinClusterRequest
+	reply := `{"type":"sync","status":"Success","status_code":200,"metadata":{}}`
+	status := http.StatusOK
+
+	socketPath := filepath.Join(t.TempDir(), "control.socket")
+	listener, err := net.Listen("unix", socketPath)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		g.Expect(r.Method).To(Equal(http.MethodPost))
+		g.Expect(r.URL.Path).To(Equal("/1.0/k8sd/cluster/join"))
+		g.Expect(json.NewDecoder(r.Body).Decode(&received)).To(Succeed())
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(reply))
+	}))
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+
+	client := NewClient(socketPath)
+	request := apiv1.JoinClusterRequest{
+		Name:    "node-1",
+		Address: "10.0.0.5:6400",
+		Token:   "eyJ0b2tlbiI6ImFiYyJ9",
+		Config:  "extra-sans: []\n",
+		Timeout: 90 * time.Second,
+	}
+
+	t.Run("posts the join request", func(t *testing.T) {
+		g.Expect(client.JoinCluster(context.Background(), request)).To(Succeed())
+		g.Expect(received).To(Equal(request))
+	})
+
+	t.Run("returns the error of k8sd", func(t *testing.T) {
+		status = http.StatusInternalServerError
+		reply = `{"type":"error","error_code":500,"error":"failed to join: CoreTokenRecord not found"}`
+
+		err := client.JoinCluster(context.Background(), request)
+		g.Expect(err).To(MatchError("k8sd/cluster/join failed with HTTP 500: failed to join: CoreTokenRecord not found"))
+	})
+}
@@ -0,0 +1,47 @@
+package log
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// LineWriter logs the output of a command or script line by line, as entries of
+// Entry at Level.
+type LineWriter struct {
+	Entry   *logrus.Entry
+	Level   logrus.Level
+	partial []byte
+}
+
+func NewLineWriter(entry *logrus.Entry, level logrus.Level) *LineWriter {
+	return &LineWriter{Entry: entry, Level: level}
+}
+
+func (w *LineWriter) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		w.log(string(w.partial[:i]))
+		w.partial = w.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush logs the last line when it has no newline.
+func (w *LineWriter) Flush() {
+	if len(w.partial) > 0 {
+		w.log(string(w.partial))
+		w.partial = nil
+	}
+}
+
+func (w *LineWriter) log(line string) {
+	if line = strings.TrimRight(line, "\r"); strings.TrimSpace(line) != "" {
+		w.Entry.Log(w.Level, line)
+	}
+}
@@ -1,39 +1,284 @@
 package log
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/kairos-io/provider-canonical/pkg/domain"
 	"github.com/kairos-io/provider-canonical/pkg/version"
 	"github.com/sirupsen/logrus"
 	"gopkg.in/natefinch/lumberjack.v2"
 )
 
-func InitLogger(path string) {
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+
+	// PathStderr logs to stderr, which ends up in the journal under systemd.
+	PathStderr = "stderr"
+
+	// The env overrides of the config, and the phase the process runs in.
+	EnvLevel  = "PROVIDER_CANONICAL_LOG_LEVEL"
+	EnvFormat = "PROVIDER_CANONICAL_LOG_FORMAT"
+	EnvPath   = "PROVIDER_CANONICAL_LOG_PATH"
+	EnvPhase  = "PROVIDER_CANONICAL_PHASE"
+)
+
+// Config is where and how the provider logs. Role is added to every entry.
+//
+// Only the process with Rotate set rotates the log file, as rotation assumes a
+// single writer. The subcommands of the stages and scripts, several of which run
+// at once, append to it instead.
+type Config struct {
+	Level  string `json:"level,omitempty"`
+	Format string `json:"format,omitempty"`
+	Path   string `json:"path,omitempty"`
+	Role   string `json:"role,omitempty"`
+	Rotate bool   `json:"-"`
+}
+
+// WithEnv returns config overridden by the env.
+func (c Config) WithEnv() Config {
+	if level := os.Getenv(EnvLevel); level != "" {
+		c.Level = level
+	}
+	if format := os.Getenv(EnvFormat); format != "" {
+		c.Format = format
+	}
+	if path := os.Getenv(EnvPath); path != "" {
+		c.Path = path
+	}
+	return c
+}
+
+// LoadConfig reads the config at path, if any, overridden by the env.
+func LoadConfig(path string) Config {
+	var config Config
+	if content, err := os.ReadFile(path); err == nil {
+		_ = json.Unmarshal(content, &config)
+	}
+	return config.WithEnv()
+}
+
+var (
+	loggerLock sync.Mutex
+	logConfig  Config
+	phase      = os.Getenv(EnvPhase)
+	runID      = readRunID()
+	logFile    io.WriteCloser
+	logPath    string
+)
+
+// InitLogger sets up logrus as configured, logging to the default path unless set
+// otherwise. When the log file can't be opened, as on a read-only or not yet
+// mounted /var/log, it falls back to stderr rather than failing.
+func InitLogger(config Config) {
+	loggerLock.Lock()
+	defer loggerLock.Unlock()
+
+	if config.Path == "" {
+		config.Path = domain.DefaultLogPath
+	}
+	logConfig = config
+
+	var warnings []string
+	level, err := logrus.ParseLevel(config.Level)
+	if config.Level == "" {
+		level = logrus.InfoLevel
+	} else if err != nil {
+		warnings = append(warnings, fmt.Sprintf("unknown log level %q, logging at info level", config.Level))
+		level = logrus.InfoLevel
+	}
+	logrus.SetLevel(level)
+
+	if config.Format != "" && config.Format != FormatText && config.Format != FormatJSON {
+		warnings = append(warnings, fmt.Sprintf("unknown log format %q, logging as text", config.Format))
+	}
+	logrus.SetFormatter(formatter())
+
+	output, err := openOutput(config.Path, config.Rotate)
+	if err != nil {
+		warnings = append(warnings, fmt.Sprintf("failed to open the log file, logging to stderr: %v", err))
+	}
+	logrus.SetOutput(output)
+
+	for _, warning := range warnings {
+		logrus.Warn(warning)
+	}
+}
+
+// SetPhase tags the following entries with the phase the process runs.
+func SetPhase(name string) {
+	loggerLock.Lock()
+	defer loggerLock.Unlock()
+
+	phase = name
+	logrus.SetFormatter(formatter())
+}
+
+func formatter() logrus.Formatter {
+	var base logrus.Formatter = &logrus.TextFormatter{}
+	if logConfig.Format == FormatJSON {
+		base = &logrus.JSONFormatter{}
+	}
+	return CanonicalLogger{
+		Version:   version.Version,
+		Role:      logConfig.Role,
+		Phase:     phase,
+		RunID:     runID,
+		Formatter: base,
+	}
+}
+
+// openOutput opens the log file at path for appending, through a rotating writer
+// when rotate is set. The rotated files are left uncompressed, so the lines a
+// subcommand still appends to a file just rotated away are kept.
+func openOutput(path string, rotate bool) (io.Writer, error) {
+	if path == PathStderr {
+		return os.Stderr, nil
+	}
+	if _, ok := logFile.(*lumberjack.Logger); logFile != nil && logPath == path && ok == rotate {
+		return logFile, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return os.Stderr, err
+	}
 	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
 	if err != nil {
-		panic(err)
+		return os.Stderr, err
 	}
 
-	logfile := &lumberjack.Logger{
-		Filename:   f.Name(),
+	if logFile != nil {
+		_ = logFile.Close()
+	}
+	logPath = path
+	if !rotate {
+		logFile = f
+		return logFile, nil
+	}
+
+	_ = f.Close()
+	logFile = &lumberjack.Logger{
+		Filename:   path,
 		MaxSize:    10,
 		MaxBackups: 5,
-		Compress:   true,
 	}
+	return logFile, nil
+}
 
-	logrus.SetOutput(logfile)
-	logrus.SetFormatter(CanonicalLogger{
-		Version:   version.Version,
-		Formatter: logrus.StandardLogger().Formatter,
-	})
+// readRunID identifies the boot, so the entries of all the provider processes of a
+// boot share it. A random ID stands in when the kernel doesn't tell.
+func readRunID() string {
+	if content, err := os.ReadFile("/proc/sys/kernel/random/boot_id"); err == nil {
+		if id := strings.TrimSpace(string(content)); id != "" {
+			return id
+		}
+	}
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
 }
 
 type CanonicalLogger struct {
 	Version   string
+	Role      string
+	Phase     string
+	RunID     string
 	Formatter logrus.Formatter
 }
 
 func (l CanonicalLogger) Format(entry *logrus.Entry) ([]byte, error) {
 	entry.Data["version"] = l.Version
+	if l.Role != "" {
+		entry.Data["role"] = l.Role
+	}
+	if l.Phase != "" {
+		if _, ok := entry.Data["phase"]; !ok {
+			entry.Data["phase"] = l.Phase
+		}
+	}
+	if l.RunID != "" {
+		entry.Data["run_id"] = l.RunID
+	}
+	entry.Message = Redact(entry.Message)
+	for key, value := range entry.Data {
+		if s, ok := value.(string); ok {
+			entry.Data[key] = Redact(s)
+		}
+	}
 	return l.Formatter.Format(entry)
 }
+
+// credentialsPattern matches the user info of URLs, such as proxy credentials.
+var credentialsPattern = regexp.MustCompile(`://[^/@\s]+@`)
+
+var (
+	secretsLock sync.RWMutex
+	secrets     []string
+	secretFiles = map[string]time.Time{}
+)
+
+// AddSecret registers a value, such as a join token, that Redact hides wherever it
+// shows up.
+func AddSecret(secret string) {
+	if secret = strings.TrimSpace(secret); secret == "" {
+		return
+	}
+	secretsLock.Lock()
+	defer secretsLock.Unlock()
+	secrets = append(secrets, secret)
+}
+
+// AddSecretFile registers a file, such as the join token of a node, whose content
+// Redact hides. It is for processes that outlive the writes of the file, like the
+// log-output of a script, so the file is read again whenever it changes.
+func AddSecretFile(path string) {
+	secretsLock.Lock()
+	secretFiles[path] = time.Time{}
+	secretsLock.Unlock()
+	loadSecretFiles()
+}
+
+// loadSecretFiles registers the content of the secret files changed since they
+// were last read. The former content stays registered, as it may still show up.
+func loadSecretFiles() {
+	secretsLock.Lock()
+	defer secretsLock.Unlock()
+	for path, loaded := range secretFiles {
+		info, err := os.Stat(path)
+		if err != nil || !info.ModTime().After(loaded) {
+			continue
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		secretFiles[path] = info.ModTime()
+		if secret := strings.TrimSpace(string(content)); secret != "" {
+			secrets = append(secrets, secret)
+		}
+	}
+}
+
+// Redact hides the credentials of any URL and the registered secrets in s.
+func Redact(s string) string {
+	s = credentialsPattern.ReplaceAllString(s, "://REDACTED@")
+	loadSecretFiles()
+
+	secretsLock.RLock()
+	defer secretsLock.RUnlock()
+	for _, secret := range secrets {
+		s = strings.ReplaceAll(s, secret, "REDACTED")
+	}
+	return s
+}
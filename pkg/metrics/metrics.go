@@ -0,0 +1,166 @@
+package metrics
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/kairos-io/provider-canonical/pkg/bundle"
+	"github.com/kairos-io/provider-canonical/pkg/domain"
+	"github.com/kairos-io/provider-canonical/pkg/status"
+	"github.com/pkg/errors"
+	"github.com/twpayne/go-vfs/v4"
+)
+
+// FileName is the textfile the metrics are written to, for the textfile collector
+// of the node exporter.
+const FileName = "canonical_provider.prom"
+
+// Write renders the metrics of the node in dir, replacing the previous ones at
+// once so the collector never reads a partial file.
+func Write(root vfs.FS, dir string, current status.Status) error {
+	if err := vfs.MkdirAll(root, dir, 0755); err != nil {
+		return errors.Wrap(err, "failed to create the metrics dir")
+	}
+
+	// the collector only reads *.prom files, so it skips the temporary file
+	path := filepath.Join(dir, FileName)
+	tmp := path + ".tmp"
+	if err := root.WriteFile(tmp, []byte(Render(root, current)), 0644); err != nil {
+		return errors.Wrap(err, "failed to write the metrics")
+	}
+	return errors.Wrap(root.Rename(tmp, path), "failed to write the metrics")
+}
+
+// Render renders the metrics of the phases of current, of the certificates of the
+// cluster and of the k8s revisions in the Prometheus text format.
+func Render(root vfs.FS, current status.Status) string {
+	var b builder
+
+	phases := slices.Sorted(maps.Keys(current.Phases))
+
+	b.family("canonical_provider_phase_running", "Whether the provisioning phase is running.")
+	for _, name := range phases {
+		b.sample("canonical_provider_phase_running", phaseLabel(name), boolValue(current.Phases[name].State == status.StateRunning))
+	}
+
+	b.family("canonical_provider_phase_success", "Whether the last run of the provisioning phase succeeded.")
+	for _, name := range phases {
+		phase := current.Phases[name]
+		if phase.State == status.StateSucceeded || phase.State == status.StateFailed {
+			b.sample("canonical_provider_phase_success", phaseLabel(name), boolValue(phase.State == status.StateSucceeded))
+		}
+	}
+
+	b.family("canonical_provider_phase_duration_seconds", "Duration of the last run of the provisioning phase.")
+	for _, name := range phases {
+		phase := current.Phases[name]
+		if phase.State != status.StateRunning && phase.StartedAt != nil && phase.FinishedAt != nil && !phase.FinishedAt.Before(*phase.StartedAt) {
+			b.sample("canonical_provider_phase_duration_seconds", phaseLabel(name), phase.FinishedAt.Sub(*phase.StartedAt).Seconds())
+		}
+	}
+
+	b.family("canonical_provider_phase_last_finished_timestamp_seconds", "Time the last run of the provisioning phase finished.")
+	for _, name := range phases {
+		if finished := current.Phases[name].FinishedAt; finished != nil {
+			b.sample("canonical_provider_phase_last_finished_timestamp_seconds", phaseLabel(name), float64(finished.Unix()))
+		}
+	}
+
+	b.family("canonical_provider_phase_retries", "Retries of the last run of the provisioning phase.")
+	for _, name := range phases {
+		b.sample("canonical_provider_phase_retries", phaseLabel(name), float64(current.Phases[name].Retries))
+	}
+
+	b.family("canonical_provider_upgrade_lock_waiting", "Whether the node waits on the upgrade lock held by another node.")
+	b.sample("canonical_provider_upgrade_lock_waiting", "", boolValue(current.Phases[status.PhaseUpgrade].State == status.StateWaiting))
+
+	b.family("canonical_provider_certificate_expiry_timestamp_seconds", "Time the certificate of the cluster expires.")
+	for _, cert := range certificateExpiries(root) {
+		b.sample("canonical_provider_certificate_expiry_timestamp_seconds", fmt.Sprintf(`certificate=%q`, cert.path), float64(cert.notAfter))
+	}
+
+	b.family("canonical_provider_k8s_installed_revision", "Revision of the installed k8s snap.")
+	if revision, ok := parseRevision(status.InstalledK8sRevision(root)); ok {
+		b.sample("canonical_provider_k8s_installed_revision", "", revision)
+	}
+
+	b.family("canonical_provider_k8s_desired_revision", "Revision of the k8s snap of the airgap bundle.")
+	if desired, err := bundle.ReadRevision(root, "k8s"); err == nil {
+		if revision, ok := parseRevision(desired); ok {
+			b.sample("canonical_provider_k8s_desired_revision", "", revision)
+		}
+	}
+
+	return b.String()
+}
+
+type certificateExpiry struct {
+	path     string
+	notAfter int64
+}
+
+// certificateExpiries returns the expiry of the first certificate of each PEM file
+// of the cluster PKI, skipping those that can't be parsed.
+func certificateExpiries(root vfs.FS) []certificateExpiry {
+	var expiries []certificateExpiry
+	_ = vfs.Walk(root, domain.KubeCertificateDirPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !strings.HasSuffix(path, ".crt") {
+			return nil
+		}
+		content, err := root.ReadFile(path)
+		if err != nil {
+			return nil
+		}
+		block, _ := pem.Decode(content)
+		if block == nil || block.Type != "CERTIFICATE" {
+			return nil
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil
+		}
+		expiries = append(expiries, certificateExpiry{path: path, notAfter: cert.NotAfter.Unix()})
+		return nil
+	})
+	return expiries
+}
+
+// parseRevision parses a store revision, leaving out the x-prefixed revisions of
+// local snaps.
+func parseRevision(revision string) (float64, bool) {
+	value, err := strconv.ParseUint(revision, 10, 64)
+	return float64(value), err == nil
+}
+
+func phaseLabel(name string) string {
+	return fmt.Sprintf(`phase=%q`, name)
+}
+
+func boolValue(value bool) float64 {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+type builder struct {
+	strings.Builder
+}
+
+func (b *builder) family(name, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+}
+
+func (b *builder) sample(name, labels string, value float64) {
+	if labels != "" {
+		name += "{" + labels + "}"
+	}
+	fmt.Fprintf(b, "%s %s\n", name, strconv.FormatFloat(value, 'g', -1, 64))
+}
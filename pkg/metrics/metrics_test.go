@@ -0,0 +1,119 @@
+package metrics
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/kairos-io/provider-canonical/pkg/status"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4/vfst"
+)
+
+func TestRender(t *testing.T) {
+	g := NewWithT(t)
+
+	testFS, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/etc/kubernetes/pki/ca.crt":           testCertificate(t, time.Date(2036, 1, 2, 3, 4, 5, 0, time.UTC)),
+		"/etc/kubernetes/pki/ca.key":           "key",
+		"/etc/kubernetes/pki/front-proxy.crt":  "not a certificate",
+		"/opt/canonical/revision/k8s.revision": "2400\n",
+		"/snap/k8s/2345":                       &vfst.Dir{Perm: 0755},
+		"/snap/k8s/current":                    &vfst.Symlink{Target: "2345"},
+	})
+	g.Expect(err).NotTo(HaveOccurred())
+	defer cleanup()
+
+	started := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	finished := started.Add(90 * time.Second)
+	current := status.Status{
+		Version: status.Version,
+		Phases: map[string]status.Phase{
+			status.PhaseJoin:     {State: status.StateSucceeded, StartedAt: &started, FinishedAt: &finished, Retries: 3},
+			status.PhaseProxy:    {State: status.StateFailed, StartedAt: &started, FinishedAt: &started, Error: "restart failed"},
+			status.PhaseUpgrade:  {State: status.StateWaiting},
+			status.PhasePreSetup: {State: status.StateRunning, StartedAt: &finished},
+		},
+	}
+
+	g.Expect(Render(testFS, current)).To(Equal(`# HELP canonical_provider_phase_running Whether the provisioning phase is running.
+# TYPE canonical_provider_phase_running gauge
+canonical_provider_phase_running{phase="join"} 0
+canonical_provider_phase_running{phase="pre-setup"} 1
+canonical_provider_phase_running{phase="proxy"} 0
+canonical_provider_phase_running{phase="upgrade"} 0
+# HELP canonical_provider_phase_success Whether the last run of the provisioning phase succeeded.
+# TYPE canonical_provider_phase_success gauge
+canonical_provider_phase_success{phase="join"} 1
+canonical_provider_phase_success{phase="proxy"} 0
+# HELP canonical_provider_phase_duration_seconds Duration of the last run of the provisioning phase.
+# TYPE canonical_provider_phase_duration_seconds gauge
+canonical_provider_phase_duration_seconds{phase="join"} 90
+canonical_provider_phase_duration_seconds{phase="proxy"} 0
+# HELP canonical_provider_phase_last_finished_timestamp_seconds Time the last run of the provisioning phase finished.
+# TYPE canonical_provider_phase_last_finished_timestamp_seconds gauge
+canonical_provider_phase_last_finished_timestamp_seconds{phase="join"} 1.767323135e+09
+canonical_provider_phase_last_finished_timestamp_seconds{phase="proxy"} 1.767323045e+09
+# HELP canonical_provider_phase_retries Retries of the last run of the provisioning phase.
+# TYPE canonical_provider_phase_retries gauge
+canonical_provider_phase_retries{phase="join"} 3
+canonical_provider_phase_retries{phase="pre-setup"} 0
+canonical_provider_phase_retries{phase="proxy"} 0
+canonical_provider_phase_retries{phase="upgrade"} 0
+# HELP canonical_provider_upgrade_lock_waiting Whether the node waits on the upgrade lock held by another node.
+# TYPE canonical_provider_upgrade_lock_waiting gauge
+canonical_provider_upgrade_lock_waiting 1
+# HELP canonical_provider_certificate_expiry_timestamp_seconds Time the certificate of the cluster expires.
+# TYPE canonical_provider_certificate_expiry_timestamp_seconds gauge
+canonical_provider_certificate_expiry_timestamp_seconds{certificate="/etc/kubernetes/pki/ca.crt"} 2.082855845e+09
+# HELP canonical_provider_k8s_installed_revision Revision of the installed k8s snap.
+# TYPE canonical_provider_k8s_installed_revision gauge
+canonical_provider_k8s_installed_revision 2345
+# HELP canonical_provider_k8s_desired_revision Revision of the k8s snap of the airgap bundle.
+# TYPE canonical_provider_k8s_desired_revision gauge
+canonical_provider_k8s_desired_revision 2400
+`))
+}
+
+func TestWrite(t *testing.T) {
+	g := NewWithT(t)
+
+	testFS, cleanup, err := vfst.NewTestFS(map[string]interface{}{"/var": &vfst.Dir{Perm: 0755}})
+	g.Expect(err).NotTo(HaveOccurred())
+	defer cleanup()
+
+	g.Expect(Write(testFS, "/var/lib/node_exporter/textfile_collector", status.Status{})).To(Succeed())
+	vfst.RunTests(t, testFS, "",
+		vfst.TestPath("/var/lib/node_exporter/textfile_collector/canonical_provider.prom",
+			vfst.TestModePerm(0644),
+			vfst.TestContentsString(Render(testFS, status.Status{})),
+		),
+		vfst.TestPath("/var/lib/node_exporter/textfile_collector/canonical_provider.prom.tmp", vfst.TestDoesNotExist),
+	)
+}
+
+func testCertificate(t *testing.T, notAfter time.Time) string {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "kubernetes-ca"},
+		NotBefore:    notAfter.AddDate(-10, 0, 0),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
@@ -0,0 +1,228 @@
+package preflight
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/netip"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/kairos-io/kairos-sdk/clusterplugin"
+	"github.com/kairos-io/provider-canonical/pkg/bundle"
+	"github.com/kairos-io/provider-canonical/pkg/domain"
+	"github.com/kairos-io/provider-canonical/pkg/utils"
+)
+
+const (
+	// k8sdPort serves the k8sd API of every node, a join target to read the clock of.
+	k8sdPort = "6400"
+
+	clockWarnSkew  = 5 * time.Minute
+	clockFatalSkew = time.Hour
+)
+
+// lookupHost, interfaceAddrs, hostAddrs, serverTime and snapArchitectures are
+// swapped in tests.
+var (
+	lookupHost        = net.LookupHost
+	interfaceAddrs    = net.InterfaceAddrs
+	hostAddrs         = utils.HostAddrs
+	serverTime        = fetchServerTime
+	snapArchitectures = bundle.SnapArchitectures
+)
+
+func checkSwap(env Env) []Finding {
+	content, err := env.Root.ReadFile("/proc/swaps")
+	if err != nil {
+		return nil
+	}
+
+	var devices []string
+	for _, line := range strings.Split(string(content), "\n")[1:] {
+		if fields := strings.Fields(line); len(fields) > 0 {
+			devices = append(devices, fields[0])
+		}
+	}
+	if len(devices) == 0 {
+		return nil
+	}
+
+	if env.AllowSwap {
+		return []Finding{infof("swap is enabled on %s, tolerated by the kubelet", strings.Join(devices, ", "))}
+	}
+	return []Finding{fatalf("swap is enabled on %s, the kubelet refuses to start with swap on", strings.Join(devices, ", "))}
+}
+
+func checkBrNetfilter(env Env) []Finding {
+	for _, path := range []string{"/proc/sys/net/bridge", "/sys/module/br_netfilter"} {
+		if _, err := env.Root.Stat(path); err == nil {
+			return nil
+		}
+	}
+
+	release, err := env.Root.ReadFile("/proc/sys/kernel/osrelease")
+	if err != nil {
+		return []Finding{warnf("br_netfilter is not loaded")}
+	}
+	modulesDir := filepath.Join("/lib/modules", strings.TrimSpace(string(release)))
+	for _, index := range []string{"modules.dep", "modules.builtin"} {
+		content, err := env.Root.ReadFile(filepath.Join(modulesDir, index))
+		if err == nil && strings.Contains(string(content), "/br_netfilter.ko") {
+			return []Finding{warnf("br_netfilter is available but not loaded yet")}
+		}
+	}
+	return []Finding{fatalf("br_netfilter is neither loaded nor available in %s", modulesDir)}
+}
+
+// checkHostname rejects hostnames that can't identify a node and warns when the
+// hostname resolves to addresses of another host, a sign of a duplicate hostname.
+func checkHostname(env Env) []Finding {
+	content, err := env.Root.ReadFile("/proc/sys/kernel/hostname")
+	if err != nil {
+		return []Finding{warnf("failed to read the hostname: %v", err)}
+	}
+
+	hostname := strings.TrimSpace(string(content))
+	if hostname == "" || hostname == "localhost" || strings.HasPrefix(hostname, "localhost.") {
+		return []Finding{fatalf("hostname %q does not identify this node", hostname)}
+	}
+
+	resolved, err := lookupHost(hostname)
+	if err != nil || len(resolved) == 0 {
+		return nil
+	}
+
+	local := map[netip.Addr]bool{}
+	if addrs, err := interfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if prefix, err := netip.ParsePrefix(addr.String()); err == nil {
+				local[prefix.Addr().Unmap()] = true
+			}
+		}
+	}
+
+	var foreign []string
+	for _, r := range resolved {
+		addr, err := netip.ParseAddr(r)
+		if err != nil || addr.IsLoopback() || local[addr.Unmap()] {
+			continue
+		}
+		foreign = append(foreign, r)
+	}
+	if len(foreign) > 0 && len(foreign) == len(resolved) {
+		return []Finding{warnf("hostname %s resolves to %s, not an address of this node; another node may use the same hostname", hostname, strings.Join(foreign, ", "))}
+	}
+	return nil
+}
+
+// checkAdvertiseAddress resolves the advertise address again, as the provider
+// only logs when the interface or CIDR of the node matches no address.
+func checkAdvertiseAddress(env Env) []Finding {
+	if !env.Advertise.Enabled() {
+		return nil
+	}
+
+	addrs, err := hostAddrs(env.Advertise.Interface)
+	if err != nil {
+		return []Finding{fatalf("failed to resolve the advertise address: %v", err)}
+	}
+	selected, err := env.Advertise.Select(addrs)
+	if err != nil {
+		return []Finding{fatalf("failed to resolve the advertise address: %v", err)}
+	}
+
+	advertised := make([]string, 0, len(selected))
+	for _, addr := range selected {
+		advertised = append(advertised, addr.String())
+	}
+	return []Finding{infof("advertising %s", strings.Join(advertised, ", "))}
+}
+
+// checkArchitecture rejects a k8s snap in the bundle that is built for another
+// architecture, which would only fail once the snaps are installed.
+func checkArchitecture(env Env) []Finding {
+	revision, err := bundle.ReadRevision(env.Root, "k8s")
+	if err != nil {
+		return []Finding{warnf("skipping the snap architecture check: %v", err)}
+	}
+
+	snap := filepath.Join(domain.SnapBundleDir, "k8s_"+revision+".snap")
+	archs, err := snapArchitectures(env.Root, snap)
+	if err != nil {
+		return []Finding{warnf("skipping the snap architecture check: %v", err)}
+	}
+
+	if arch := bundle.HostArchitecture(); !bundle.SupportsArchitecture(archs, arch) {
+		return []Finding{fatalf("%s is built for %s, this node is %s", snap, strings.Join(archs, ", "), arch)}
+	}
+	return nil
+}
+
+// checkClock compares the clock with the cluster a node joins, and otherwise with
+// the snap bundle, which can't have been built in the future.
+func checkClock(env Env) []Finding {
+	now := env.Now()
+
+	if env.Role != string(clusterplugin.RoleInit) && env.ControlPlaneHost != "" {
+		remote, err := serverTime(env.ControlPlaneHost)
+		if err != nil {
+			return []Finding{infof("could not compare the clock with %s: %v", env.ControlPlaneHost, err)}
+		}
+		return clockSkewFindings(now.Sub(remote), env.ControlPlaneHost)
+	}
+
+	var newest time.Time
+	entries, err := env.Root.ReadDir(domain.SnapBundleDir)
+	if err != nil {
+		return nil
+	}
+	for _, entry := range entries {
+		if info, err := entry.Info(); err == nil && info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+	}
+	if !newest.IsZero() && now.Before(newest.Add(-clockFatalSkew)) {
+		return []Finding{fatalf("the clock (%s) is behind the snap bundle build time (%s)", now.UTC().Format(time.RFC3339), newest.UTC().Format(time.RFC3339))}
+	}
+	return nil
+}
+
+func clockSkewFindings(skew time.Duration, host string) []Finding {
+	if skew < 0 {
+		skew = -skew
+	}
+	switch {
+	case skew > clockFatalSkew:
+		return []Finding{fatalf("the clock is %s off from %s, certificates won't validate", skew.Round(time.Second), host)}
+	case skew > clockWarnSkew:
+		return []Finding{warnf("the clock is %s off from %s", skew.Round(time.Second), host)}
+	default:
+		return nil
+	}
+}
+
+// fetchServerTime reads the Date header of the k8sd API of host. Nothing is sent
+// but the request line, so the certificate is not verified.
+func fetchServerTime(host string) (time.Time, error) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+	}
+	resp, err := client.Head("https://" + net.JoinHostPort(host, k8sdPort) + "/")
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer resp.Body.Close()
+
+	return http.ParseTime(resp.Header.Get("Date"))
+}
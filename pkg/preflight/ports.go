@@ -0,0 +1,141 @@
+package preflight
+
+import (
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kairos-io/kairos-sdk/clusterplugin"
+	"github.com/twpayne/go-vfs/v4"
+)
+
+const (
+	tcpStateListen = "0A"
+)
+
+// controlPlanePorts and workerPorts are the TCP ports the k8s snap listens on.
+var (
+	controlPlanePorts = map[int]string{
+		6443:  "kube-apiserver",
+		6400:  "k8sd",
+		9000:  "k8s-dqlite",
+		10250: "kubelet",
+		10256: "kube-proxy",
+		10257: "kube-controller-manager",
+		10259: "kube-scheduler",
+	}
+	workerPorts = map[int]string{
+		6400:  "k8sd",
+		10250: "kubelet",
+		10256: "kube-proxy",
+	}
+)
+
+// k8sProcessPrefixes match the command names of the k8s snap services, which hold
+// their ports after a failed attempt and must not be reported as conflicts.
+var k8sProcessPrefixes = []string{"k8s", "kube", "containerd", "cilium", "etcd"}
+
+func checkPorts(env Env) []Finding {
+	ports := workerPorts
+	if env.Role == string(clusterplugin.RoleInit) || env.Role == string(clusterplugin.RoleControlPlane) {
+		ports = controlPlanePorts
+	}
+
+	listeners := listeningSockets(env.Root)
+	if len(listeners) == 0 {
+		return nil
+	}
+	owners := socketOwners(env.Root)
+
+	var findings []Finding
+	for _, port := range sortedPorts(ports) {
+		inode, ok := listeners[port]
+		if !ok {
+			continue
+		}
+		owner, known := owners[inode]
+		if known && isK8sProcess(owner) {
+			continue
+		}
+		if !known {
+			owner = "an unknown process"
+		}
+		findings = append(findings, fatalf("port %d needed by %s is already in use by %s", port, ports[port], owner))
+	}
+	return findings
+}
+
+// listeningSockets maps the listening TCP ports to their socket inode.
+func listeningSockets(root vfs.FS) map[int]string {
+	listeners := map[int]string{}
+	for _, path := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		content, err := root.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(content), "\n")[1:] {
+			fields := strings.Fields(line)
+			if len(fields) < 10 || fields[3] != tcpStateListen {
+				continue
+			}
+			local := fields[1]
+			port, err := strconv.ParseUint(local[strings.LastIndex(local, ":")+1:], 16, 16)
+			if err != nil {
+				continue
+			}
+			listeners[int(port)] = fields[9]
+		}
+	}
+	return listeners
+}
+
+// socketOwners maps socket inodes to the command name of a process holding them.
+func socketOwners(root vfs.FS) map[string]string {
+	owners := map[string]string{}
+
+	procs, err := root.ReadDir("/proc")
+	if err != nil {
+		return owners
+	}
+	for _, proc := range procs {
+		if _, err := strconv.Atoi(proc.Name()); err != nil {
+			continue
+		}
+		procDir := filepath.Join("/proc", proc.Name())
+		fds, err := root.ReadDir(filepath.Join(procDir, "fd"))
+		if err != nil {
+			continue
+		}
+		comm, err := root.ReadFile(filepath.Join(procDir, "comm"))
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			target, err := root.Readlink(filepath.Join(procDir, "fd", fd.Name()))
+			if err != nil || !strings.HasPrefix(target, "socket:[") {
+				continue
+			}
+			owners[strings.TrimSuffix(strings.TrimPrefix(target, "socket:["), "]")] = strings.TrimSpace(string(comm))
+		}
+	}
+	return owners
+}
+
+func isK8sProcess(comm string) bool {
+	for _, prefix := range k8sProcessPrefixes {
+		if strings.HasPrefix(comm, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedPorts(ports map[int]string) []int {
+	sorted := make([]int, 0, len(ports))
+	for port := range ports {
+		sorted = append(sorted, port)
+	}
+	sort.Ints(sorted)
+	return sorted
+}
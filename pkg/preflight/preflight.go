@@ -0,0 +1,133 @@
+package preflight
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/kairos-io/provider-canonical/pkg/domain"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/twpayne/go-vfs/v4"
+)
+
+type Severity string
+
+const (
+	SeverityInfo    Severity = "info"
+	SeverityWarning Severity = "warning"
+	// SeverityFatal findings keep the node from bootstrapping or joining.
+	SeverityFatal Severity = "fatal"
+)
+
+// ReportVersion is bumped whenever the report layout changes incompatibly.
+const ReportVersion = 1
+
+// Env is what the checks know about the node they run on.
+type Env struct {
+	Root vfs.FS
+	Role string
+	// ControlPlaneHost is the address of the cluster a joining node joins.
+	ControlPlaneHost string
+	// AllowSwap is set when the kubelet is configured to tolerate swap.
+	AllowSwap bool
+	// Advertise selects the advertise address among the host addresses, if set.
+	Advertise domain.AdvertiseSelector
+	Now       func() time.Time
+}
+
+type Finding struct {
+	Check    string   `json:"check"`
+	Severity Severity `json:"severity"`
+	Message  string   `json:"message"`
+}
+
+type Report struct {
+	Version  int       `json:"version"`
+	Time     time.Time `json:"time"`
+	Role     string    `json:"role"`
+	Findings []Finding `json:"findings"`
+}
+
+// Fatal reports whether any finding blocks the bootstrap or join.
+func (r Report) Fatal() bool {
+	for _, finding := range r.Findings {
+		if finding.Severity == SeverityFatal {
+			return true
+		}
+	}
+	return false
+}
+
+// Check inspects one aspect of the host, returning no findings when all is well.
+type Check struct {
+	Name string
+	Run  func(env Env) []Finding
+}
+
+// Checks is the catalogue of host checks run before a bootstrap or join.
+var Checks = []Check{
+	{Name: "ports", Run: checkPorts},
+	{Name: "swap", Run: checkSwap},
+	{Name: "br_netfilter", Run: checkBrNetfilter},
+	{Name: "hostname", Run: checkHostname},
+	{Name: "advertise_address", Run: checkAdvertiseAddress},
+	{Name: "architecture", Run: checkArchitecture},
+	{Name: "clock", Run: checkClock},
+}
+
+// Run runs every check of the catalogue and logs its findings.
+func Run(env Env) Report {
+	if env.Now == nil {
+		env.Now = time.Now
+	}
+
+	report := Report{
+		Version:  ReportVersion,
+		Time:     env.Now().UTC(),
+		Role:     env.Role,
+		Findings: []Finding{},
+	}
+
+	for _, check := range Checks {
+		for _, finding := range check.Run(env) {
+			finding.Check = check.Name
+			report.Findings = append(report.Findings, finding)
+
+			switch finding.Severity {
+			case SeverityFatal:
+				logrus.Errorf("preflight %s: %s", finding.Check, finding.Message)
+			case SeverityWarning:
+				logrus.Warnf("preflight %s: %s", finding.Check, finding.Message)
+			default:
+				logrus.Infof("preflight %s: %s", finding.Check, finding.Message)
+			}
+		}
+	}
+	return report
+}
+
+// WriteReport writes the report as JSON to path.
+func WriteReport(root vfs.FS, path string, report Report) error {
+	content, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = vfs.MkdirAll(root, filepath.Dir(path), 0755); err != nil {
+		return errors.Wrap(err, "failed to create preflight report dir")
+	}
+	return root.WriteFile(path, append(content, '\n'), 0644)
+}
+
+func fatalf(format string, args ...interface{}) Finding {
+	return Finding{Severity: SeverityFatal, Message: fmt.Sprintf(format, args...)}
+}
+
+func warnf(format string, args ...interface{}) Finding {
+	return Finding{Severity: SeverityWarning, Message: fmt.Sprintf(format, args...)}
+}
+
+func infof(format string, args ...interface{}) Finding {
+	return Finding{Severity: SeverityInfo, Message: fmt.Sprintf(format, args...)}
+}
@@ -0,0 +1,299 @@
+package preflight
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kairos-io/provider-canonical/pkg/bundle"
+	"github.com/kairos-io/provider-canonical/pkg/domain"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4"
+	"github.com/twpayne/go-vfs/v4/vfst"
+)
+
+const procNetTCP = `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000:192B 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1001 1 0000000000000000 100 0 0 10 0
+   1: 00000000:1900 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1002 1 0000000000000000 100 0 0 10 0
+   2: 0100007F:2800 0100007F:D2F0 01 00000000:00000000 00:00000000 00000000     0        0 1003 1 0000000000000000 100 0 0 10 0
+`
+
+func TestCheckPorts(t *testing.T) {
+	g := NewWithT(t)
+
+	testFS, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/proc/net/tcp":  procNetTCP,
+		"/proc/10/comm":  "kube-apiserver\n",
+		"/proc/10/fd/3":  &vfst.Symlink{Target: "socket:[1001]"},
+		"/proc/20/comm":  "nginx\n",
+		"/proc/20/fd/7":  &vfst.Symlink{Target: "socket:[1002]"},
+		"/proc/20/fd/8":  &vfst.Symlink{Target: "/dev/null"},
+		"/proc/self/fd/": &vfst.Dir{Perm: 0755},
+	})
+	g.Expect(err).NotTo(HaveOccurred())
+	defer cleanup()
+
+	t.Run("reports ports held by other processes on control planes", func(t *testing.T) {
+		findings := checkPorts(Env{Root: testFS, Role: "controlplane"})
+		g.Expect(findings).To(Equal([]Finding{
+			fatalf("port 6400 needed by k8sd is already in use by nginx"),
+		}))
+	})
+
+	t.Run("checks the worker ports on workers", func(t *testing.T) {
+		findings := checkPorts(Env{Root: testFS, Role: "worker"})
+		g.Expect(findings).To(HaveLen(1))
+
+		g.Expect(testFS.RemoveAll("/proc/20")).To(Succeed())
+		findings = checkPorts(Env{Root: testFS, Role: "worker"})
+		g.Expect(findings).To(Equal([]Finding{
+			fatalf("port 6400 needed by k8sd is already in use by an unknown process"),
+		}))
+	})
+}
+
+func TestCheckSwap(t *testing.T) {
+	g := NewWithT(t)
+
+	testFS, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/proc/swaps": "Filename\tType\tSize\tUsed\tPriority\n/dev/sda2 partition\t2097148\t0\t-2\n",
+	})
+	g.Expect(err).NotTo(HaveOccurred())
+	defer cleanup()
+
+	g.Expect(checkSwap(Env{Root: testFS})[0].Severity).To(Equal(SeverityFatal))
+	g.Expect(checkSwap(Env{Root: testFS, AllowSwap: true})[0].Severity).To(Equal(SeverityInfo))
+
+	g.Expect(testFS.WriteFile("/proc/swaps", []byte("Filename\tType\tSize\tUsed\tPriority\n"), 0444)).To(Succeed())
+	g.Expect(checkSwap(Env{Root: testFS})).To(BeEmpty())
+}
+
+func TestCheckBrNetfilter(t *testing.T) {
+	g := NewWithT(t)
+
+	for name, tc := range map[string]struct {
+		files    map[string]interface{}
+		severity Severity
+	}{
+		"loaded": {
+			files:    map[string]interface{}{"/proc/sys/net/bridge/bridge-nf-call-iptables": "1"},
+			severity: "",
+		},
+		"available": {
+			files: map[string]interface{}{
+				"/proc/sys/kernel/osrelease":                "6.8.0-45-generic\n",
+				"/lib/modules/6.8.0-45-generic/modules.dep": "kernel/net/bridge/br_netfilter.ko.zst: kernel/net/bridge/bridge.ko.zst\n",
+			},
+			severity: SeverityWarning,
+		},
+		"missing": {
+			files: map[string]interface{}{
+				"/proc/sys/kernel/osrelease":                "6.8.0-45-generic\n",
+				"/lib/modules/6.8.0-45-generic/modules.dep": "kernel/net/bridge/bridge.ko.zst:\n",
+			},
+			severity: SeverityFatal,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			testFS, cleanup, err := vfst.NewTestFS(tc.files)
+			g.Expect(err).NotTo(HaveOccurred())
+			defer cleanup()
+
+			findings := checkBrNetfilter(Env{Root: testFS})
+			if tc.severity == "" {
+				g.Expect(findings).To(BeEmpty())
+			} else {
+				g.Expect(findings).To(HaveLen(1))
+				g.Expect(findings[0].Severity).To(Equal(tc.severity))
+			}
+		})
+	}
+}
+
+func TestCheckHostname(t *testing.T) {
+	g := NewWithT(t)
+
+	originalLookupHost, originalInterfaceAddrs := lookupHost, interfaceAddrs
+	t.Cleanup(func() {
+		lookupHost, interfaceAddrs = originalLookupHost, originalInterfaceAddrs
+	})
+	interfaceAddrs = func() ([]net.Addr, error) {
+		_, local, _ := net.ParseCIDR("10.0.0.5/24")
+		local.IP = net.ParseIP("10.0.0.5")
+		return []net.Addr{local}, nil
+	}
+
+	testFS, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/proc/sys/kernel/hostname": "node-1\n",
+	})
+	g.Expect(err).NotTo(HaveOccurred())
+	defer cleanup()
+
+	t.Run("accepts a hostname resolving to this node", func(t *testing.T) {
+		lookupHost = func(string) ([]string, error) { return []string{"10.0.0.5"}, nil }
+		g.Expect(checkHostname(Env{Root: testFS})).To(BeEmpty())
+
+		lookupHost = func(string) ([]string, error) { return nil, errors.New("no such host") }
+		g.Expect(checkHostname(Env{Root: testFS})).To(BeEmpty())
+	})
+
+	t.Run("warns when the hostname resolves to another node", func(t *testing.T) {
+		lookupHost = func(string) ([]string, error) { return []string{"10.0.0.9"}, nil }
+		findings := checkHostname(Env{Root: testFS})
+		g.Expect(findings).To(HaveLen(1))
+		g.Expect(findings[0].Severity).To(Equal(SeverityWarning))
+	})
+
+	t.Run("rejects localhost", func(t *testing.T) {
+		g.Expect(testFS.WriteFile("/proc/sys/kernel/hostname", []byte("localhost\n"), 0644)).To(Succeed())
+		findings := checkHostname(Env{Root: testFS})
+		g.Expect(findings).To(HaveLen(1))
+		g.Expect(findings[0].Severity).To(Equal(SeverityFatal))
+	})
+}
+
+func TestCheckAdvertiseAddress(t *testing.T) {
+	g := NewWithT(t)
+
+	originalHostAddrs := hostAddrs
+	t.Cleanup(func() { hostAddrs = originalHostAddrs })
+	hostAddrs = func(string) ([]netip.Addr, error) {
+		return []netip.Addr{netip.MustParseAddr("192.168.1.5")}, nil
+	}
+
+	g.Expect(checkAdvertiseAddress(Env{})).To(BeEmpty())
+
+	advertise, err := domain.ParseAdvertiseSelector("eth1", "192.168.0.0/16", "")
+	g.Expect(err).NotTo(HaveOccurred())
+	findings := checkAdvertiseAddress(Env{Advertise: advertise})
+	g.Expect(findings).To(HaveLen(1))
+	g.Expect(findings[0].Severity).To(Equal(SeverityInfo))
+	g.Expect(findings[0].Message).To(Equal("advertising 192.168.1.5"))
+
+	advertise, err = domain.ParseAdvertiseSelector("eth1", "10.20.0.0/16", "")
+	g.Expect(err).NotTo(HaveOccurred())
+	findings = checkAdvertiseAddress(Env{Advertise: advertise})
+	g.Expect(findings).To(HaveLen(1))
+	g.Expect(findings[0].Severity).To(Equal(SeverityFatal))
+	g.Expect(findings[0].Message).To(ContainSubstring("no usable address on interface eth1 within 10.20.0.0/16"))
+}
+
+func TestCheckArchitecture(t *testing.T) {
+	g := NewWithT(t)
+
+	originalSnapArchitectures := snapArchitectures
+	t.Cleanup(func() { snapArchitectures = originalSnapArchitectures })
+
+	testFS, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/opt/canonical/revision/k8s.revision": "3120\n",
+	})
+	g.Expect(err).NotTo(HaveOccurred())
+	defer cleanup()
+
+	var path string
+	snapArchitectures = func(_ vfs.FS, snap string) ([]string, error) {
+		path = snap
+		return []string{bundle.HostArchitecture()}, nil
+	}
+	g.Expect(checkArchitecture(Env{Root: testFS})).To(BeEmpty())
+	g.Expect(path).To(Equal("/opt/canonical-k8s/k8s_3120.snap"))
+
+	snapArchitectures = func(vfs.FS, string) ([]string, error) { return []string{"s390x"}, nil }
+	if bundle.HostArchitecture() != "s390x" {
+		findings := checkArchitecture(Env{Root: testFS})
+		g.Expect(findings).To(HaveLen(1))
+		g.Expect(findings[0].Severity).To(Equal(SeverityFatal))
+		g.Expect(findings[0].Message).To(Equal("/opt/canonical-k8s/k8s_3120.snap is built for s390x, this node is " + bundle.HostArchitecture()))
+	}
+
+	snapArchitectures = func(vfs.FS, string) ([]string, error) { return nil, errors.New("not a squashfs image") }
+	findings := checkArchitecture(Env{Root: testFS})
+	g.Expect(findings).To(HaveLen(1))
+	g.Expect(findings[0].Severity).To(Equal(SeverityWarning))
+
+	emptyFS, cleanupEmpty, err := vfst.NewEmptyTestFS()
+	g.Expect(err).NotTo(HaveOccurred())
+	defer cleanupEmpty()
+	findings = checkArchitecture(Env{Root: emptyFS})
+	g.Expect(findings[0].Message).To(HavePrefix("skipping the snap architecture check: revision file for k8s not found"))
+}
+
+func TestCheckClock(t *testing.T) {
+	g := NewWithT(t)
+
+	originalServerTime := serverTime
+	t.Cleanup(func() { serverTime = originalServerTime })
+
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	env := Env{Role: "worker", ControlPlaneHost: "10.0.0.1", Now: func() time.Time { return now }}
+
+	serverTime = func(string) (time.Time, error) { return now.Add(-time.Minute), nil }
+	g.Expect(checkClock(env)).To(BeEmpty())
+
+	serverTime = func(string) (time.Time, error) { return now.Add(10 * time.Minute), nil }
+	g.Expect(checkClock(env)[0].Severity).To(Equal(SeverityWarning))
+
+	serverTime = func(string) (time.Time, error) { return now.Add(48 * time.Hour), nil }
+	g.Expect(checkClock(env)[0].Severity).To(Equal(SeverityFatal))
+
+	serverTime = func(string) (time.Time, error) { return time.Time{}, errors.New("connection refused") }
+	g.Expect(checkClock(env)[0].Severity).To(Equal(SeverityInfo))
+
+	t.Run("compares with the snap bundle on the init node", func(t *testing.T) {
+		testFS, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+			"/opt/canonical-k8s/k8s_3120.snap": "snap",
+		})
+		g.Expect(err).NotTo(HaveOccurred())
+		defer cleanup()
+
+		built := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+		g.Expect(testFS.Chtimes("/opt/canonical-k8s/k8s_3120.snap", built, built)).To(Succeed())
+
+		env := Env{Root: testFS, Role: "init", Now: func() time.Time { return now }}
+		g.Expect(checkClock(env)).To(BeEmpty())
+
+		env.Now = func() time.Time { return time.Unix(0, 0) }
+		g.Expect(checkClock(env)[0].Severity).To(Equal(SeverityFatal))
+	})
+}
+
+func TestRun(t *testing.T) {
+	g := NewWithT(t)
+
+	originalChecks := Checks
+	t.Cleanup(func() { Checks = originalChecks })
+	Checks = []Check{
+		{Name: "ok", Run: func(Env) []Finding { return nil }},
+		{Name: "swap", Run: func(Env) []Finding { return []Finding{fatalf("swap is on")} }},
+	}
+
+	testFS, cleanup, err := vfst.NewTestFS(map[string]interface{}{})
+	g.Expect(err).NotTo(HaveOccurred())
+	defer cleanup()
+
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	report := Run(Env{Root: testFS, Role: "init", Now: func() time.Time { return now }})
+	g.Expect(report.Fatal()).To(BeTrue())
+	g.Expect(report.Findings).To(Equal([]Finding{{Check: "swap", Severity: SeverityFatal, Message: "swap is on"}}))
+
+	path := filepath.Join("/run/provider-canonical", "preflight.json")
+	g.Expect(WriteReport(testFS, path, report)).To(Succeed())
+	vfst.RunTests(t, testFS, "",
+		vfst.TestPath(path, vfst.TestContentsString(`{
+  "version": 1,
+  "time": "2026-03-01T12:00:00Z",
+  "role": "init",
+  "findings": [
+    {
+      "check": "swap",
+      "severity": "fatal",
+      "message": "swap is on"
+    }
+  ]
+}
+`)),
+	)
+}
@@ -1,16 +1,109 @@
 package provider
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
 	apiv1 "github.com/canonical/k8s-snap-api/api/v1"
 	"github.com/kairos-io/kairos-sdk/clusterplugin"
 	"github.com/kairos-io/provider-canonical/pkg/domain"
+	"github.com/kairos-io/provider-canonical/pkg/fs"
+	"github.com/kairos-io/provider-canonical/pkg/images"
+	"github.com/kairos-io/provider-canonical/pkg/log"
 	"github.com/kairos-io/provider-canonical/pkg/stages"
+	"github.com/kairos-io/provider-canonical/pkg/utils"
 	yip "github.com/mudler/yip/pkg/schema"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/twpayne/go-vfs/v4"
 	"gopkg.in/yaml.v3"
 )
 
+// writeJoinFiles hands the token and its refresh source over to the join step
+// through root only files, until the node has joined. They are written here rather
+// than by a stage, as the rendered stages end up in the logs.
+func writeJoinFiles(clusterCtx *domain.ClusterContext) {
+	if clusterCtx.ClusterToken == "" || utils.FileExists(fs.OSFS, "/opt/canonical/canonical.join") {
+		return
+	}
+
+	if err := writeRootOnlyFile(domain.JoinTokenPath, []byte(clusterCtx.ClusterToken)); err != nil {
+		logrus.Errorf("failed to write the join token: %v", err)
+	}
+
+	if !clusterCtx.TokenRefresh.Enabled() {
+		if err := fs.OSFS.Remove(domain.TokenRefreshConfigPath); err != nil && !os.IsNotExist(err) {
+			logrus.Errorf("failed to remove the token refresh config: %v", err)
+		}
+		return
+	}
+	config, _ := json.Marshal(clusterCtx.TokenRefresh)
+	if err := writeRootOnlyFile(domain.TokenRefreshConfigPath, config); err != nil {
+		logrus.Errorf("failed to write the token refresh config: %v", err)
+	}
+}
+
+func writeRootOnlyFile(path string, content []byte) error {
+	if err := vfs.MkdirAll(fs.OSFS, filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := fs.OSFS.WriteFile(path, content, 0600); err != nil {
+		return err
+	}
+	return fs.OSFS.Chmod(path, 0600)
+}
+
+// hostAddrs is swapped in tests.
+var hostAddrs = utils.HostAddrs
+
+// configureLogging applies the log config of the provider options, and hands it
+// over to the subcommands run by the stages and scripts. The provider is the one
+// process rotating the log file.
+func configureLogging(cluster clusterplugin.Cluster) {
+	config := getLogConfig(cluster)
+	rotating := config.WithEnv()
+	rotating.Rotate = true
+	log.InitLogger(rotating)
+
+	content, _ := json.Marshal(config)
+	if err := vfs.MkdirAll(fs.OSFS, filepath.Dir(domain.LogConfigPath), 0755); err != nil {
+		logrus.Errorf("failed to write the log config: %v", err)
+		return
+	}
+	if err := fs.OSFS.WriteFile(domain.LogConfigPath, content, 0644); err != nil {
+		logrus.Errorf("failed to write the log config: %v", err)
+	}
+}
+
+// getLogConfig reads the log_level, log_format and log_path options. The xtrace
+// of the scripts is logged at debug level, so it is only in the provider log with
+// log_level debug, and otherwise only in the per-script log files of
+// script_log_files.
+func getLogConfig(cluster clusterplugin.Cluster) log.Config {
+	return log.Config{
+		Level:  cluster.ProviderOptions["log_level"],
+		Format: cluster.ProviderOptions["log_format"],
+		Path:   cluster.ProviderOptions["log_path"],
+		Role:   string(cluster.Role),
+	}
+}
+
 func ClusterProvider(cluster clusterplugin.Cluster) yip.YipConfig {
+	configureLogging(cluster)
+	log.SetPhase("provision")
 	clusterCtx := CreateClusterContext(cluster)
+	log.AddSecret(clusterCtx.ClusterToken)
+	if clusterCtx.NodeRole != clusterplugin.RoleInit {
+		writeJoinFiles(clusterCtx)
+	}
 
 	cfg := yip.YipConfig{
 		Name: "Canonical K8s Cluster Provider",
@@ -32,9 +125,9 @@ func CreateClusterContext(cluster clusterplugin.Cluster) *domain.ClusterContext
 	}
 
 	if address, ok := cluster.ProviderOptions["advertise_address"]; ok && address != "" {
-		clusterContext.CustomAdvertiseAddress = address
+		setAdvertiseAddressCtx(clusterContext, address)
 	} else {
-		clusterContext.CustomAdvertiseAddress = "''"
+		resolveAdvertiseAddressCtx(clusterContext, cluster.ProviderOptions)
 	}
 
 	if cluster.LocalImagesPath == "" {
@@ -43,9 +136,448 @@ func CreateClusterContext(cluster clusterplugin.Cluster) *domain.ClusterContext
 		clusterContext.LocalImagesPath = cluster.LocalImagesPath
 	}
 
+	clusterContext.ProxyCACert = getProxyCACert(cluster)
+	setInitModeCtx(clusterContext, cluster.ProviderOptions)
+	clusterContext.Backup = getBackupConfig(cluster.ProviderOptions)
+	clusterContext.ImageSignature = getImageSignatureConfig(cluster.ProviderOptions)
+	setTokenRefreshCtx(clusterContext, cluster.ProviderOptions)
+	clusterContext.Retry = getRetryPolicy(cluster.ProviderOptions)
+	setClusterFeaturesCtx(clusterContext, cluster.ProviderOptions)
+	clusterContext.ConfigHash = configHash(cluster)
+	clusterContext.MetricsDir = getMetricsDir(cluster.ProviderOptions)
+	clusterContext.ScriptLogFiles = cluster.ProviderOptions["script_log_files"] == "true"
+	setSnapSourceCtx(clusterContext, cluster.ProviderOptions)
+	setNodeRegistrationCtx(clusterContext, cluster.ProviderOptions)
+
 	return clusterContext
 }
 
+// setClusterFeaturesCtx parses the cluster_features option. Invalid features keep
+// the init node from bootstrapping, while the other nodes don't use them.
+func setClusterFeaturesCtx(clusterCtx *domain.ClusterContext, providerOptions map[string]string) {
+	features, err := domain.ParseClusterFeatures(providerOptions["cluster_features"])
+	switch {
+	case err == nil:
+		clusterCtx.Features = features
+	case clusterCtx.NodeRole == string(clusterplugin.RoleInit):
+		clusterCtx.ConfigErrors = append(clusterCtx.ConfigErrors, errors.Wrap(err, "invalid cluster_features"))
+	default:
+		logrus.Warnf("ignoring the cluster_features of a joining node: %v", err)
+	}
+}
+
+func getMetricsDir(providerOptions map[string]string) string {
+	if dir := providerOptions["metrics_dir"]; dir != "" {
+		return dir
+	}
+	return domain.DefaultMetricsDir
+}
+
+// configHash identifies the config of the node, leaving the join token out as it
+// is a secret and changes when refreshed.
+func configHash(cluster clusterplugin.Cluster) string {
+	content, _ := json.Marshal(struct {
+		Role             clusterplugin.Role
+		ControlPlaneHost string
+		Options          string
+		ProviderOptions  map[string]string
+		Env              map[string]string
+		LocalImagesPath  string
+	}{
+		Role:             cluster.Role,
+		ControlPlaneHost: cluster.ControlPlaneHost,
+		Options:          cluster.Options,
+		ProviderOptions:  cluster.ProviderOptions,
+		Env:              cluster.Env,
+		LocalImagesPath:  cluster.LocalImagesPath,
+	})
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// setAdvertiseAddressCtx accepts a single advertise address or a dual-stack pair,
+// of which the first one is advertised and both are used as the kubelet node IPs.
+func setAdvertiseAddressCtx(clusterCtx *domain.ClusterContext, address string) {
+	addrs, err := domain.ParseAddressPair(address)
+	if err != nil {
+		logrus.Warnf("advertise_address %q is not an IP address or a dual-stack pair: %v", address, err)
+		clusterCtx.CustomAdvertiseAddress = address
+		return
+	}
+
+	clusterCtx.CustomAdvertiseAddress = addrs[0].String()
+	for _, addr := range addrs {
+		clusterCtx.NodeIPs = append(clusterCtx.NodeIPs, addr.String())
+	}
+}
+
+// resolveAdvertiseAddressCtx resolves the advertise addresses from the addresses of
+// the advertise_interface and within the advertise_cidr, preferring the family of
+// advertise_ip_family. Nothing is advertised when nothing matches, and preflight
+// keeps the node from bootstrapping or joining with the wrong address.
+func resolveAdvertiseAddressCtx(clusterCtx *domain.ClusterContext, providerOptions map[string]string) {
+	selector, err := domain.ParseAdvertiseSelector(providerOptions["advertise_interface"], providerOptions["advertise_cidr"], providerOptions["advertise_ip_family"])
+	if err != nil {
+		logrus.Errorf("failed to resolve the advertise address: %v", err)
+		return
+	}
+	if !selector.Enabled() {
+		return
+	}
+	clusterCtx.Advertise = selector
+
+	hostAddrs, err := hostAddrs(selector.Interface)
+	if err != nil {
+		logrus.Errorf("failed to resolve the advertise address: %v", err)
+		return
+	}
+	addrs, err := selector.Select(hostAddrs)
+	if err != nil {
+		logrus.Errorf("failed to resolve the advertise address: %v", err)
+		return
+	}
+
+	clusterCtx.CustomAdvertiseAddress = addrs[0].String()
+	for _, addr := range addrs {
+		clusterCtx.NodeIPs = append(clusterCtx.NodeIPs, addr.String())
+	}
+	logrus.Infof("resolved advertise address %s", strings.Join(clusterCtx.NodeIPs, ","))
+}
+
+// getProxyCACert resolves the proxy CA bundle from the provider options, either
+// inline or as a file on the node, falling back to the PROXY_CA_CERT env.
+func getProxyCACert(cluster clusterplugin.Cluster) string {
+	bundle := cluster.ProviderOptions["proxy_ca_cert"]
+
+	if path := cluster.ProviderOptions["proxy_ca_cert_file"]; bundle == "" && path != "" {
+		content, err := fs.OSFS.ReadFile(path)
+		if err != nil {
+			logrus.Warnf("failed to read proxy_ca_cert_file %s: %v", path, err)
+			return ""
+		}
+		bundle = string(content)
+	}
+
+	if bundle == "" {
+		bundle = cluster.Env["PROXY_CA_CERT"]
+	}
+	if bundle == "" {
+		return ""
+	}
+
+	if err := utils.ValidateCertificateBundle(bundle); err != nil {
+		logrus.Warnf("ignoring invalid proxy CA certificate: %v", err)
+		return ""
+	}
+	return bundle
+}
+
+// setInitModeCtx selects how the init node creates its cluster. The provider
+// options are shared by the whole cluster, so the joining nodes ignore it. An
+// unknown mode keeps the init node from bootstrapping, as a mistyped restore must
+// not come up as a new cluster.
+func setInitModeCtx(clusterCtx *domain.ClusterContext, providerOptions map[string]string) {
+	clusterCtx.InitMode = domain.InitModeBootstrap
+	if clusterCtx.NodeRole != string(clusterplugin.RoleInit) {
+		return
+	}
+
+	switch mode := providerOptions["init_mode"]; mode {
+	case "", domain.InitModeBootstrap:
+	case domain.InitModeRestore:
+		clusterCtx.InitMode = domain.InitModeRestore
+		clusterCtx.RestoreBackupPath = providerOptions["restore_backup_path"]
+		if clusterCtx.RestoreBackupPath == "" {
+			clusterCtx.ConfigErrors = append(clusterCtx.ConfigErrors, errors.New("init_mode restore requires restore_backup_path"))
+		}
+	default:
+		clusterCtx.ConfigErrors = append(clusterCtx.ConfigErrors, errors.Errorf("unknown init_mode %q", mode))
+	}
+}
+
+// setSnapSourceCtx selects the airgap bundle unless the snaps are to be installed
+// from the store.
+func setSnapSourceCtx(clusterCtx *domain.ClusterContext, providerOptions map[string]string) {
+	clusterCtx.SnapSource = domain.SnapSourceAirgap
+
+	switch source := providerOptions["snap_source"]; source {
+	case "", domain.SnapSourceAirgap:
+	case domain.SnapSourceStore:
+		clusterCtx.SnapSource = domain.SnapSourceStore
+	default:
+		logrus.Warnf("unknown snap_source %q, installing from the airgap bundle", source)
+	}
+
+	channel := providerOptions["k8s_channel"]
+	if channel != "" && clusterCtx.SnapSource != domain.SnapSourceStore {
+		logrus.Warnf("ignoring k8s_channel %s, it only applies to snap_source store", channel)
+		return
+	}
+	clusterCtx.K8sChannel = channel
+}
+
+// setNodeRegistrationCtx keeps the valid node labels and taints, the invalid ones
+// are logged and left out rather than failing the kubelet registration.
+func setNodeRegistrationCtx(clusterCtx *domain.ClusterContext, providerOptions map[string]string) {
+	labels, err := domain.ParseNodeLabels(providerOptions["node_labels"])
+	if err != nil {
+		logrus.Errorf("ignoring node labels: %v", err)
+	}
+	clusterCtx.NodeLabels = labels
+
+	taints, err := domain.ParseNodeTaints(providerOptions["node_taints"])
+	if err != nil {
+		logrus.Errorf("ignoring node taints: %v", err)
+	}
+	clusterCtx.NodeTaints = taints
+}
+
+func getBackupConfig(providerOptions map[string]string) domain.BackupConfig {
+	backupConfig := domain.BackupConfig{
+		Schedule:  providerOptions["backup_schedule"],
+		Retention: domain.DefaultBackupRetention,
+		Dir:       domain.DefaultBackupDir,
+	}
+
+	if retention := providerOptions["backup_retention"]; retention != "" {
+		if value, err := strconv.Atoi(retention); err == nil && value > 0 {
+			backupConfig.Retention = value
+		} else {
+			logrus.Warnf("invalid backup_retention %q, keeping %d backups", retention, domain.DefaultBackupRetention)
+		}
+	}
+
+	if dir := providerOptions["backup_dir"]; dir != "" {
+		backupConfig.Dir = dir
+	}
+	return backupConfig
+}
+
+// getImageSignatureConfig resolves the image signing key, inline or as a file on
+// the node. Signatures are enforced by default once a key is configured.
+func getImageSignatureConfig(providerOptions map[string]string) domain.ImageSignatureConfig {
+	config := domain.ImageSignatureConfig{
+		PublicKey: providerOptions["image_signature_key"],
+	}
+
+	if path := providerOptions["image_signature_key_file"]; config.PublicKey == "" && path != "" {
+		content, err := fs.OSFS.ReadFile(path)
+		if err != nil {
+			logrus.Warnf("failed to read image_signature_key_file %s: %v", path, err)
+		}
+		config.PublicKey = string(content)
+	}
+
+	if config.PublicKey != "" {
+		if _, err := images.ParsePublicKey(config.PublicKey); err != nil {
+			logrus.Errorf("invalid image signing key: %v", err)
+		}
+	}
+
+	switch policy := providerOptions["image_signature_policy"]; policy {
+	case domain.SignaturePolicyEnforce, domain.SignaturePolicyWarn, domain.SignaturePolicyOff:
+		config.Policy = policy
+	case "":
+		config.Policy = domain.SignaturePolicyOff
+		if config.PublicKey != "" {
+			config.Policy = domain.SignaturePolicyEnforce
+		}
+	default:
+		logrus.Warnf("unknown image_signature_policy %q, enforcing image signatures", policy)
+		config.Policy = domain.SignaturePolicyEnforce
+	}
+
+	if config.Policy != domain.SignaturePolicyOff && config.PublicKey == "" {
+		logrus.Warnf("image_signature_policy %s has no image_signature_key to verify with", config.Policy)
+	}
+	return config
+}
+
+// setTokenRefreshCtx selects where a joining node gets a new join token from.
+// Refresh is disabled unless a source is configured, or the node is a Palette
+// edge host. An invalid source keeps a joining node from joining, while the init
+// node, which never refreshes a token, only warns about it.
+func setTokenRefreshCtx(clusterCtx *domain.ClusterContext, providerOptions map[string]string) {
+	config, err := getTokenRefreshConfig(providerOptions)
+	if err != nil {
+		config = domain.TokenRefreshConfig{Source: domain.TokenRefreshDisabled}
+		if clusterCtx.NodeRole == string(clusterplugin.RoleInit) {
+			logrus.Warnf("invalid token refresh config: %v", err)
+		} else {
+			clusterCtx.ConfigErrors = append(clusterCtx.ConfigErrors, err)
+		}
+	}
+	clusterCtx.TokenRefresh = config
+}
+
+func getTokenRefreshConfig(providerOptions map[string]string) (domain.TokenRefreshConfig, error) {
+	disabled := domain.TokenRefreshConfig{Source: domain.TokenRefreshDisabled}
+
+	config := domain.TokenRefreshConfig{
+		Source:               providerOptions["token_refresh"],
+		URL:                  providerOptions["token_refresh_url"],
+		CAFile:               providerOptions["token_refresh_ca_file"],
+		ClientCertFile:       providerOptions["token_refresh_client_cert_file"],
+		ClientKeyFile:        providerOptions["token_refresh_client_key_file"],
+		Insecure:             providerOptions["token_refresh_insecure"] == "true",
+		ResolveJoinAddresses: providerOptions["token_refresh_resolve_join_addresses"] == "true",
+		Expiry:               providerOptions["token_refresh_expiry"],
+		File:                 providerOptions["token_refresh_file"],
+	}
+
+	switch config.Source {
+	case "":
+		// Palette edge hosts refreshed their token from the edge API before the
+		// token refresh options existed, so they keep doing so unless disabled.
+		if !utils.FileExists(fs.OSFS, domain.PaletteUserdataPath) {
+			return disabled, nil
+		}
+		palette, err := getPaletteTokenRefreshConfig()
+		if err != nil {
+			logrus.Warnf("disabling token refresh: %v", err)
+			return disabled, nil
+		}
+		return palette, nil
+	case domain.TokenRefreshDisabled:
+		return disabled, nil
+	case domain.TokenRefreshPalette:
+		return getPaletteTokenRefreshConfig()
+	case domain.TokenRefreshFile:
+		if config.File == "" {
+			return disabled, errors.New("token_refresh file requires token_refresh_file")
+		}
+		return domain.TokenRefreshConfig{Source: config.Source, File: config.File}, nil
+	case domain.TokenRefreshHTTPS:
+		u, err := url.Parse(config.URL)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return disabled, errors.Errorf("token_refresh https requires an https token_refresh_url, got %q", config.URL)
+		}
+		if config.Insecure {
+			logrus.Warn("token_refresh_insecure is set, the token refresh endpoint certificate is not verified")
+		}
+		headers, err := parseHeaders(providerOptions["token_refresh_headers"])
+		if err != nil {
+			return disabled, errors.Wrap(err, "invalid token_refresh_headers")
+		}
+		config.Headers = headers
+		config.File = ""
+		return config, nil
+	default:
+		return disabled, errors.Errorf("unknown token_refresh %q", config.Source)
+	}
+}
+
+// getPaletteTokenRefreshConfig asks the Palette edge API of the host for a new
+// token, as the join script did before token_refresh existed. The API listens on
+// the local UI port of the edge host and is reached through the join addresses.
+func getPaletteTokenRefreshConfig() (domain.TokenRefreshConfig, error) {
+	content, err := fs.OSFS.ReadFile(domain.PaletteUserdataPath)
+	if err != nil {
+		return domain.TokenRefreshConfig{}, errors.Wrap(err, "token_refresh palette requires the Palette userdata")
+	}
+
+	var userdata struct {
+		Stylus struct {
+			Site struct {
+				Name string `yaml:"name"`
+			} `yaml:"site"`
+			LocalUI struct {
+				Port int `yaml:"port"`
+			} `yaml:"localUI"`
+		} `yaml:"stylus"`
+	}
+	if err := yaml.Unmarshal(content, &userdata); err != nil {
+		return domain.TokenRefreshConfig{}, errors.Wrapf(err, "failed to parse %s", domain.PaletteUserdataPath)
+	}
+	if userdata.Stylus.Site.Name == "" {
+		return domain.TokenRefreshConfig{}, errors.Errorf("%s has no stylus.site.name", domain.PaletteUserdataPath)
+	}
+
+	port := userdata.Stylus.LocalUI.Port
+	if port == 0 {
+		port = domain.PaletteLocalUIPort
+	}
+
+	config := domain.TokenRefreshConfig{
+		Source:               domain.TokenRefreshHTTPS,
+		URL:                  fmt.Sprintf("https://%s:%d%s", domain.PaletteEdgeAPIHost, port, domain.PaletteTokenRefreshPath),
+		Headers:              map[string]string{"Publisher-Host-Id": userdata.Stylus.Site.Name},
+		ResolveJoinAddresses: true,
+	}
+
+	caFile := filepath.Join(domain.PaletteMTLSDir, "spectro-ca.crt")
+	certFile := filepath.Join(domain.PaletteMTLSDir, "spectro-client.crt")
+	keyFile := filepath.Join(domain.PaletteMTLSDir, "spectro-client.key")
+	if utils.FileExists(fs.OSFS, caFile) && utils.FileExists(fs.OSFS, certFile) && utils.FileExists(fs.OSFS, keyFile) {
+		config.CAFile, config.ClientCertFile, config.ClientKeyFile = caFile, certFile, keyFile
+	} else {
+		logrus.Warnf("no mTLS certificates in %s, the Palette edge API certificate is not verified", domain.PaletteMTLSDir)
+		config.Insecure = true
+	}
+	return config, nil
+}
+
+// getRetryPolicy bounds the retries of the scripts. Invalid values keep their
+// default rather than failing the provisioning.
+func getRetryPolicy(providerOptions map[string]string) domain.RetryPolicy {
+	policy := domain.DefaultRetryPolicy()
+
+	if attempts := providerOptions["retry_max_attempts"]; attempts != "" {
+		if value, err := strconv.Atoi(attempts); err == nil && value >= 0 {
+			policy.MaxAttempts = value
+		} else {
+			logrus.Warnf("invalid retry_max_attempts %q, keeping %d", attempts, policy.MaxAttempts)
+		}
+	}
+
+	setRetryDuration(providerOptions, "retry_initial_delay", &policy.InitialDelay)
+	setRetryDuration(providerOptions, "retry_max_delay", &policy.MaxDelay)
+	setRetryDuration(providerOptions, "retry_phase_deadline", &policy.PhaseDeadline)
+
+	if policy.InitialDelay < time.Second {
+		logrus.Warnf("retry_initial_delay %s is below a second, using %s", policy.InitialDelay, domain.DefaultRetryInitialDelay)
+		policy.InitialDelay = domain.DefaultRetryInitialDelay
+	}
+	if policy.MaxDelay < policy.InitialDelay {
+		logrus.Warnf("retry_max_delay %s is below retry_initial_delay, using %s", policy.MaxDelay, policy.InitialDelay)
+		policy.MaxDelay = policy.InitialDelay
+	}
+	return policy
+}
+
+func setRetryDuration(providerOptions map[string]string, option string, value *time.Duration) {
+	raw := providerOptions[option]
+	if raw == "" {
+		return
+	}
+	duration, err := time.ParseDuration(raw)
+	if err != nil || duration < 0 {
+		logrus.Warnf("invalid %s %q, keeping %s", option, raw, *value)
+		return
+	}
+	*value = duration
+}
+
+// parseHeaders parses one "Name: value" HTTP header per line.
+func parseHeaders(headers string) (map[string]string, error) {
+	result := map[string]string{}
+	for _, line := range strings.Split(headers, "\n") {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.ContainsAny(name, " \t") {
+			return nil, errors.Errorf("%q is not a Name: value header", line)
+		}
+		result[name] = strings.TrimSpace(value)
+	}
+	if len(result) == 0 {
+		return nil, nil
+	}
+	return result, nil
+}
+
 func getFinalStages(clusterCtx *domain.ClusterContext) []yip.Stage {
 	var finalStages []yip.Stage
 
@@ -56,6 +588,9 @@ func getFinalStages(clusterCtx *domain.ClusterContext) []yip.Stage {
 
 	finalStages = append(finalStages, stages.GetPreSetupStages(clusterCtx)...)
 
+	finalStages = append(finalStages, stages.GetPreflightStage(clusterCtx))
+	finalStages = append(finalStages, stages.GetProviderParamsStage(clusterCtx))
+
 	switch clusterCtx.NodeRole {
 	case clusterplugin.RoleInit:
 		finalStages = append(finalStages, stages.GetInitStage(clusterCtx)...)
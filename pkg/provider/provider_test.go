@@ -1,12 +1,24 @@
 package provider
 
 import (
+	"net/netip"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
+	"text/template"
+	"time"
 
 	apiv1 "github.com/canonical/k8s-snap-api/api/v1"
 	"github.com/kairos-io/kairos-sdk/clusterplugin"
 	"github.com/kairos-io/provider-canonical/pkg/domain"
+	"github.com/kairos-io/provider-canonical/pkg/fs"
+	"github.com/kairos-io/provider-canonical/pkg/log"
+	"github.com/kairos-io/provider-canonical/pkg/stages"
 	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4"
+	"github.com/twpayne/go-vfs/v4/vfst"
 	"gopkg.in/yaml.v3"
 )
 
@@ -28,7 +40,7 @@ func TestCreateClusterContext(t *testing.T) {
 		g.Expect(ctx.ClusterToken).To(Equal("token123"))
 		g.Expect(ctx.UserOptions).To(Equal("options"))
 		g.Expect(ctx.EnvConfig).To(HaveKeyWithValue("key", "value"))
-		g.Expect(ctx.CustomAdvertiseAddress).To(Equal("''"))
+		g.Expect(ctx.CustomAdvertiseAddress).To(BeEmpty())
 		g.Expect(ctx.LocalImagesPath).To(Equal(domain.DefaultLocalImagesDir))
 	})
 
@@ -42,6 +54,46 @@ func TestCreateClusterContext(t *testing.T) {
 		g.Expect(ctx.CustomAdvertiseAddress).To(Equal("192.168.1.1"))
 	})
 
+	t.Run("sets the first of a dual-stack advertise address pair", func(t *testing.T) {
+		cluster := clusterplugin.Cluster{
+			ProviderOptions: map[string]string{
+				"advertise_address": "fd00::5, 10.0.0.5",
+			},
+		}
+		ctx := CreateClusterContext(cluster)
+		g.Expect(ctx.CustomAdvertiseAddress).To(Equal("fd00::5"))
+		g.Expect(ctx.NodeIPs).To(Equal([]string{"fd00::5", "10.0.0.5"}))
+	})
+
+	t.Run("resolves the advertise address from an interface", func(t *testing.T) {
+		originalHostAddrs := hostAddrs
+		defer func() { hostAddrs = originalHostAddrs }()
+		hostAddrs = func(iface string) ([]netip.Addr, error) {
+			g.Expect(iface).To(Equal("eth1"))
+			return []netip.Addr{netip.MustParseAddr("fe80::1"), netip.MustParseAddr("10.20.3.4"), netip.MustParseAddr("fd00:20::4")}, nil
+		}
+
+		ctx := CreateClusterContext(clusterplugin.Cluster{
+			ProviderOptions: map[string]string{
+				"advertise_interface": "eth1",
+				"advertise_ip_family": "ipv6",
+			},
+		})
+		g.Expect(ctx.CustomAdvertiseAddress).To(Equal("fd00:20::4"))
+		g.Expect(ctx.NodeIPs).To(Equal([]string{"fd00:20::4"}))
+		g.Expect(ctx.Advertise.Interface).To(Equal("eth1"))
+
+		ctx = CreateClusterContext(clusterplugin.Cluster{
+			ProviderOptions: map[string]string{
+				"advertise_interface": "eth1",
+				"advertise_cidr":      "192.168.0.0/16",
+			},
+		})
+		g.Expect(ctx.CustomAdvertiseAddress).To(BeEmpty())
+		g.Expect(ctx.NodeIPs).To(BeEmpty())
+		g.Expect(ctx.Advertise.Enabled()).To(BeTrue())
+	})
+
 	t.Run("sets custom local images path when provided", func(t *testing.T) {
 		cluster := clusterplugin.Cluster{
 			LocalImagesPath: "/custom/path",
@@ -49,6 +101,361 @@ func TestCreateClusterContext(t *testing.T) {
 		ctx := CreateClusterContext(cluster)
 		g.Expect(ctx.LocalImagesPath).To(Equal("/custom/path"))
 	})
+
+	t.Run("ignores an invalid proxy CA certificate", func(t *testing.T) {
+		cluster := clusterplugin.Cluster{
+			ProviderOptions: map[string]string{
+				"proxy_ca_cert": "not a certificate",
+			},
+		}
+		ctx := CreateClusterContext(cluster)
+		g.Expect(ctx.ProxyCACert).To(BeEmpty())
+	})
+
+	t.Run("sets restore init mode when provided", func(t *testing.T) {
+		cluster := clusterplugin.Cluster{
+			Role: clusterplugin.RoleInit,
+			ProviderOptions: map[string]string{
+				"init_mode":           "restore",
+				"restore_backup_path": "/backups/canonical-backup-20260101T000000Z.tar.gz",
+			},
+		}
+		ctx := CreateClusterContext(cluster)
+		g.Expect(ctx.InitMode).To(Equal(domain.InitModeRestore))
+		g.Expect(ctx.RestoreBackupPath).To(Equal("/backups/canonical-backup-20260101T000000Z.tar.gz"))
+	})
+
+	t.Run("rejects a restore without a backup archive", func(t *testing.T) {
+		ctx := CreateClusterContext(clusterplugin.Cluster{
+			Role: clusterplugin.RoleInit,
+			ProviderOptions: map[string]string{
+				"init_mode": "restore",
+			},
+		})
+		g.Expect(ctx.InitMode).To(Equal(domain.InitModeRestore))
+		g.Expect(ctx.ConfigErrors).To(ConsistOf(MatchError("init_mode restore requires restore_backup_path")))
+
+		initStages := stages.GetInitStage(ctx)
+		g.Expect(initStages[1].Name).To(Equal("Reject Canonical Restore"))
+		g.Expect(initStages[1].Commands[0]).To(ContainSubstring("--state failed --error 'init_mode restore requires restore_backup_path'"))
+	})
+
+	t.Run("rejects an unknown init mode", func(t *testing.T) {
+		ctx := CreateClusterContext(clusterplugin.Cluster{
+			Role: clusterplugin.RoleInit,
+			ProviderOptions: map[string]string{
+				"init_mode": "restor",
+			},
+		})
+		g.Expect(ctx.ConfigErrors).To(ConsistOf(MatchError(`unknown init_mode "restor"`)))
+
+		initStages := stages.GetInitStage(ctx)
+		g.Expect(initStages[1].Name).To(Equal("Reject Canonical Bootstrap"))
+	})
+
+	t.Run("ignores the init mode on joining nodes", func(t *testing.T) {
+		for _, role := range []clusterplugin.Role{clusterplugin.RoleControlPlane, clusterplugin.RoleWorker} {
+			for _, mode := range []string{"restore", "restor"} {
+				ctx := CreateClusterContext(clusterplugin.Cluster{
+					Role:             role,
+					ControlPlaneHost: "10.0.0.1",
+					ProviderOptions: map[string]string{
+						"init_mode": mode,
+					},
+				})
+				g.Expect(ctx.InitMode).To(Equal(domain.InitModeBootstrap), string(role))
+				g.Expect(ctx.RestoreBackupPath).To(BeEmpty(), string(role))
+				g.Expect(ctx.ConfigErrors).To(BeEmpty(), string(role))
+			}
+		}
+	})
+
+	t.Run("sets backup config from provider options", func(t *testing.T) {
+		cluster := clusterplugin.Cluster{
+			ProviderOptions: map[string]string{
+				"backup_schedule":  "daily",
+				"backup_retention": "3",
+			},
+		}
+		ctx := CreateClusterContext(cluster)
+		g.Expect(ctx.Backup.Enabled()).To(BeTrue())
+		g.Expect(ctx.Backup.Schedule).To(Equal("daily"))
+		g.Expect(ctx.Backup.Retention).To(Equal(3))
+		g.Expect(ctx.Backup.Dir).To(Equal(domain.DefaultBackupDir))
+	})
+
+	t.Run("keeps default backup retention when invalid", func(t *testing.T) {
+		cluster := clusterplugin.Cluster{
+			ProviderOptions: map[string]string{
+				"backup_retention": "-1",
+			},
+		}
+		ctx := CreateClusterContext(cluster)
+		g.Expect(ctx.Backup.Enabled()).To(BeFalse())
+		g.Expect(ctx.Backup.Retention).To(Equal(domain.DefaultBackupRetention))
+	})
+
+	t.Run("enforces image signatures once a key is configured", func(t *testing.T) {
+		ctx := CreateClusterContext(clusterplugin.Cluster{})
+		g.Expect(ctx.ImageSignature.Policy).To(Equal(domain.SignaturePolicyOff))
+
+		cluster := clusterplugin.Cluster{
+			ProviderOptions: map[string]string{
+				"image_signature_key": "-----BEGIN PUBLIC KEY-----",
+			},
+		}
+		ctx = CreateClusterContext(cluster)
+		g.Expect(ctx.ImageSignature.Policy).To(Equal(domain.SignaturePolicyEnforce))
+		g.Expect(ctx.ImageSignature.PublicKey).To(Equal("-----BEGIN PUBLIC KEY-----"))
+
+		cluster.ProviderOptions["image_signature_policy"] = "warn"
+		ctx = CreateClusterContext(cluster)
+		g.Expect(ctx.ImageSignature.Policy).To(Equal(domain.SignaturePolicyWarn))
+	})
+
+	t.Run("installs from the airgap bundle unless the store is selected", func(t *testing.T) {
+		ctx := CreateClusterContext(clusterplugin.Cluster{
+			ProviderOptions: map[string]string{"k8s_channel": "1.32-classic/stable"},
+		})
+		g.Expect(ctx.SnapSource).To(Equal(domain.SnapSourceAirgap))
+		g.Expect(ctx.K8sChannel).To(BeEmpty())
+
+		ctx = CreateClusterContext(clusterplugin.Cluster{
+			ProviderOptions: map[string]string{
+				"snap_source": "store",
+				"k8s_channel": "1.32-classic/stable",
+			},
+		})
+		g.Expect(ctx.SnapSource).To(Equal(domain.SnapSourceStore))
+		g.Expect(ctx.K8sChannel).To(Equal("1.32-classic/stable"))
+	})
+
+	t.Run("keeps the valid node labels and taints", func(t *testing.T) {
+		ctx := CreateClusterContext(clusterplugin.Cluster{
+			ProviderOptions: map[string]string{
+				"node_labels": "env=prod,node-role.kubernetes.io/worker=",
+				"node_taints": "dedicated=gpu:NoSchedule,spot:Never",
+			},
+		})
+		g.Expect(ctx.NodeLabels).To(Equal([]string{"env=prod"}))
+		g.Expect(ctx.NodeTaints).To(Equal([]string{"dedicated=gpu:NoSchedule"}))
+	})
+
+	t.Run("parses the cluster features", func(t *testing.T) {
+		ctx := CreateClusterContext(clusterplugin.Cluster{
+			ProviderOptions: map[string]string{
+				"cluster_features": "gateway:\n  enabled: true\n",
+			},
+		})
+		g.Expect(*ctx.Features.Gateway.Enabled).To(BeTrue())
+	})
+
+	t.Run("rejects invalid cluster features on the init node only", func(t *testing.T) {
+		cluster := clusterplugin.Cluster{
+			Role: clusterplugin.RoleInit,
+			ProviderOptions: map[string]string{
+				"cluster_features": "load-balancer:\n  enabled: true\n",
+			},
+		}
+		ctx := CreateClusterContext(cluster)
+		g.Expect(ctx.Features).To(Equal(domain.ClusterFeatures{}))
+		g.Expect(ctx.ConfigErrors).To(ConsistOf(MatchError(ContainSubstring("invalid cluster_features: load-balancer requires cidrs"))))
+
+		cluster.Role = clusterplugin.RoleWorker
+		g.Expect(CreateClusterContext(cluster).ConfigErrors).To(BeEmpty())
+	})
+
+	t.Run("hashes the config without the join token", func(t *testing.T) {
+		cluster := clusterplugin.Cluster{
+			Role:            clusterplugin.RoleWorker,
+			ClusterToken:    "token",
+			Options:         "options",
+			ProviderOptions: map[string]string{"retry_max_attempts": "5"},
+		}
+		hash := CreateClusterContext(cluster).ConfigHash
+		g.Expect(hash).To(HaveLen(64))
+
+		cluster.ClusterToken = "refreshed"
+		g.Expect(CreateClusterContext(cluster).ConfigHash).To(Equal(hash))
+
+		cluster.ProviderOptions = map[string]string{"retry_max_attempts": "6"}
+		g.Expect(CreateClusterContext(cluster).ConfigHash).NotTo(Equal(hash))
+	})
+}
+
+func TestGetTokenRefreshConfig(t *testing.T) {
+	g := NewWithT(t)
+
+	useTestFS := func(t *testing.T, root map[string]interface{}) {
+		testFS, cleanup, err := vfst.NewTestFS(root)
+		g.Expect(err).NotTo(HaveOccurred())
+		t.Cleanup(cleanup)
+
+		originalFS := fs.OSFS
+		fs.OSFS = testFS
+		t.Cleanup(func() { fs.OSFS = originalFS })
+	}
+
+	userdata := "stylus:\n  site:\n    name: edge-1\n  localUI:\n    port: 5443\n"
+
+	t.Run("is disabled by default", func(t *testing.T) {
+		useTestFS(t, map[string]interface{}{"/run": &vfst.Dir{Perm: 0755}})
+
+		config, err := getTokenRefreshConfig(map[string]string{})
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(config.Enabled()).To(BeFalse())
+	})
+
+	t.Run("reads the https source", func(t *testing.T) {
+		config, err := getTokenRefreshConfig(map[string]string{
+			"token_refresh":                        "https",
+			"token_refresh_url":                    "https://refresh.example.com:5080/v1/cluster-token",
+			"token_refresh_ca_file":                "/oem/mtls/ca.crt",
+			"token_refresh_headers":                "Publisher-Host-Id: edge-1\nAuthorization: Bearer abc:def\n",
+			"token_refresh_resolve_join_addresses": "true",
+			"token_refresh_file":                   "/ignored",
+		})
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(config).To(Equal(domain.TokenRefreshConfig{
+			Source:               domain.TokenRefreshHTTPS,
+			URL:                  "https://refresh.example.com:5080/v1/cluster-token",
+			CAFile:               "/oem/mtls/ca.crt",
+			Headers:              map[string]string{"Publisher-Host-Id": "edge-1", "Authorization": "Bearer abc:def"},
+			ResolveJoinAddresses: true,
+		}))
+	})
+
+	t.Run("refreshes from the Palette edge API on Palette edge hosts", func(t *testing.T) {
+		useTestFS(t, map[string]interface{}{
+			domain.PaletteUserdataPath:                    userdata,
+			domain.PaletteMTLSDir + "/spectro-ca.crt":     "ca",
+			domain.PaletteMTLSDir + "/spectro-client.crt": "cert",
+			domain.PaletteMTLSDir + "/spectro-client.key": "key",
+		})
+
+		config, err := getTokenRefreshConfig(map[string]string{})
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(config).To(Equal(domain.TokenRefreshConfig{
+			Source:               domain.TokenRefreshHTTPS,
+			URL:                  "https://internal.spectrocloud.com:5443/v1/internal/edgehosts/current/actions/cluster-token",
+			CAFile:               domain.PaletteMTLSDir + "/spectro-ca.crt",
+			ClientCertFile:       domain.PaletteMTLSDir + "/spectro-client.crt",
+			ClientKeyFile:        domain.PaletteMTLSDir + "/spectro-client.key",
+			Headers:              map[string]string{"Publisher-Host-Id": "edge-1"},
+			ResolveJoinAddresses: true,
+		}))
+
+		config, err = getTokenRefreshConfig(map[string]string{"token_refresh": "disabled"})
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(config.Enabled()).To(BeFalse())
+	})
+
+	t.Run("skips verification of the Palette edge API without mTLS certificates", func(t *testing.T) {
+		useTestFS(t, map[string]interface{}{domain.PaletteUserdataPath: "stylus:\n  site:\n    name: edge-1\n"})
+
+		config, err := getTokenRefreshConfig(map[string]string{"token_refresh": "palette"})
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(config.URL).To(Equal("https://internal.spectrocloud.com:5080/v1/internal/edgehosts/current/actions/cluster-token"))
+		g.Expect(config.Insecure).To(BeTrue())
+		g.Expect(config.CAFile).To(BeEmpty())
+	})
+
+	t.Run("rejects the palette source outside of a Palette edge host", func(t *testing.T) {
+		useTestFS(t, map[string]interface{}{"/run": &vfst.Dir{Perm: 0755}})
+
+		_, err := getTokenRefreshConfig(map[string]string{"token_refresh": "palette"})
+		g.Expect(err).To(MatchError(ContainSubstring("token_refresh palette requires the Palette userdata")))
+	})
+
+	t.Run("rejects an invalid source", func(t *testing.T) {
+		for _, tc := range []struct {
+			options map[string]string
+			message string
+		}{
+			{map[string]string{"token_refresh": "https", "token_refresh_url": "http://refresh.example.com"}, `token_refresh https requires an https token_refresh_url, got "http://refresh.example.com"`},
+			{map[string]string{"token_refresh": "https", "token_refresh_url": "https://refresh.example.com", "token_refresh_headers": "no header"}, `invalid token_refresh_headers: "no header" is not a Name: value header`},
+			{map[string]string{"token_refresh": "file"}, "token_refresh file requires token_refresh_file"},
+			{map[string]string{"token_refresh": "spectro"}, `unknown token_refresh "spectro"`},
+		} {
+			config, err := getTokenRefreshConfig(tc.options)
+			g.Expect(err).To(MatchError(tc.message))
+			g.Expect(config.Source).To(Equal(domain.TokenRefreshDisabled))
+		}
+	})
+
+	t.Run("only rejects an invalid source on joining nodes", func(t *testing.T) {
+		options := map[string]string{"token_refresh": "spectro"}
+		for role, rejected := range map[clusterplugin.Role]bool{
+			clusterplugin.RoleInit:         false,
+			clusterplugin.RoleControlPlane: true,
+			clusterplugin.RoleWorker:       true,
+		} {
+			ctx := &domain.ClusterContext{NodeRole: string(role)}
+			setTokenRefreshCtx(ctx, options)
+			g.Expect(ctx.TokenRefresh.Enabled()).To(BeFalse())
+			g.Expect(len(ctx.ConfigErrors) > 0).To(Equal(rejected), string(role))
+		}
+	})
+
+	t.Run("writes the config of a joining node next to its token", func(t *testing.T) {
+		testFS, cleanup, err := vfst.NewTestFS(map[string]interface{}{"/run": &vfst.Dir{Perm: 0755}})
+		g.Expect(err).NotTo(HaveOccurred())
+		defer cleanup()
+
+		originalFS := fs.OSFS
+		fs.OSFS = testFS
+		defer func() { fs.OSFS = originalFS }()
+
+		writeJoinFiles(&domain.ClusterContext{
+			ClusterToken: "token",
+			TokenRefresh: domain.TokenRefreshConfig{Source: domain.TokenRefreshFile, File: "/var/lib/agent/token"},
+		})
+		vfst.RunTests(t, testFS, "",
+			vfst.TestPath(domain.TokenRefreshConfigPath,
+				vfst.TestModePerm(0600),
+				vfst.TestContentsString(`{"source":"file","file":"/var/lib/agent/token"}`),
+			),
+		)
+
+		writeJoinFiles(&domain.ClusterContext{ClusterToken: "token", TokenRefresh: domain.TokenRefreshConfig{Source: domain.TokenRefreshDisabled}})
+		vfst.RunTests(t, testFS, "", vfst.TestPath(domain.TokenRefreshConfigPath, vfst.TestDoesNotExist))
+	})
+}
+
+func TestGetRetryPolicy(t *testing.T) {
+	g := NewWithT(t)
+
+	t.Run("defaults to unbounded attempts within an hour", func(t *testing.T) {
+		g.Expect(getRetryPolicy(map[string]string{})).To(Equal(domain.DefaultRetryPolicy()))
+	})
+
+	t.Run("reads the retry options", func(t *testing.T) {
+		g.Expect(getRetryPolicy(map[string]string{
+			"retry_max_attempts":   "5",
+			"retry_initial_delay":  "2s",
+			"retry_max_delay":      "1m",
+			"retry_phase_deadline": "0",
+		})).To(Equal(domain.RetryPolicy{
+			MaxAttempts:   5,
+			InitialDelay:  2 * time.Second,
+			MaxDelay:      time.Minute,
+			PhaseDeadline: 0,
+		}))
+	})
+
+	t.Run("keeps the defaults of invalid options", func(t *testing.T) {
+		g.Expect(getRetryPolicy(map[string]string{
+			"retry_max_attempts":   "-1",
+			"retry_initial_delay":  "100ms",
+			"retry_max_delay":      "soon",
+			"retry_phase_deadline": "-1h",
+		})).To(Equal(domain.DefaultRetryPolicy()))
+	})
+
+	t.Run("raises the max delay to the initial delay", func(t *testing.T) {
+		policy := getRetryPolicy(map[string]string{"retry_initial_delay": "10m"})
+		g.Expect(policy.MaxDelay).To(Equal(10 * time.Minute))
+	})
 }
 
 func TestGetFinalStages(t *testing.T) {
@@ -127,3 +534,94 @@ func TestGetFinalStages(t *testing.T) {
 		g.Expect(ctx.ClusterCidr).To(Equal(podCIDR))
 	})
 }
+
+func TestClusterProvider(t *testing.T) {
+	g := NewWithT(t)
+
+	token := `eyJ0b2tlbiI6ImFiYyJ9'; touch /tmp/pwned; echo '$(reboot) {{ .Values }}`
+	address := "10.0.0.5; rm -rf / #"
+	controlPlaneHost := "10.0.0.1 `reboot`"
+
+	t.Setenv(log.EnvPath, log.PathStderr)
+	testFS, cleanup, err := vfst.NewTestFS(map[string]interface{}{"/run": &vfst.Dir{Perm: 0755}})
+	g.Expect(err).NotTo(HaveOccurred())
+	defer cleanup()
+
+	originalFS := fs.OSFS
+	fs.OSFS = testFS
+	defer func() { fs.OSFS = originalFS }()
+
+	cfg := ClusterProvider(clusterplugin.Cluster{
+		Role:             clusterplugin.RoleWorker,
+		ClusterToken:     token,
+		ControlPlaneHost: controlPlaneHost,
+		Options:          "pod-cidr: 10.244.0.0/16\nservice-cidr: 10.96.0.0/12\n",
+		ProviderOptions:  map[string]string{"advertise_address": address},
+	})
+
+	var params string
+	for _, stage := range cfg.Stages["boot.before"] {
+		for _, command := range append(stage.Commands, stage.If) {
+			g.Expect(command).NotTo(ContainSubstring(token))
+			g.Expect(command).NotTo(ContainSubstring(address))
+			g.Expect(command).NotTo(ContainSubstring("{{"))
+		}
+		for _, file := range stage.Files {
+			g.Expect(file.Content).NotTo(ContainSubstring(token))
+			if file.Path == domain.ProviderParamsPath {
+				g.Expect(file.Permissions).To(Equal(uint32(0600)))
+				params = file.Content
+			}
+		}
+	}
+	g.Expect(params).NotTo(BeEmpty())
+
+	t.Run("hands the log config over to the subcommands", func(t *testing.T) {
+		vfst.RunTests(t, testFS, "",
+			vfst.TestPath(domain.LogConfigPath,
+				vfst.TestModePerm(0644),
+				vfst.TestContentsString(`{"role":"worker"}`),
+			),
+		)
+	})
+
+	t.Run("hands the join token over through a root only file", func(t *testing.T) {
+		vfst.RunTests(t, testFS, "",
+			vfst.TestPath(domain.JoinTokenPath,
+				vfst.TestModePerm(0600),
+				vfst.TestContentsString(token),
+			),
+		)
+
+		g.Expect(testFS.WriteFile(domain.JoinTokenPath, nil, 0600)).To(Succeed())
+		g.Expect(vfs.MkdirAll(testFS, "/opt/canonical", 0755)).To(Succeed())
+		g.Expect(testFS.WriteFile("/opt/canonical/canonical.join", nil, 0644)).To(Succeed())
+
+		ClusterProvider(clusterplugin.Cluster{
+			Role:         clusterplugin.RoleWorker,
+			ClusterToken: token,
+			Options:      "pod-cidr: 10.244.0.0/16\nservice-cidr: 10.96.0.0/12\n",
+		})
+		vfst.RunTests(t, testFS, "", vfst.TestPath(domain.JoinTokenPath, vfst.TestContentsString("")))
+	})
+
+	t.Run("passes the values through yip and bash unchanged", func(t *testing.T) {
+		bash, err := exec.LookPath("bash")
+		if err != nil {
+			t.Skip("bash is not available")
+		}
+
+		// yip renders file contents as templates before writing them
+		tmpl, err := template.New("params").Parse(params)
+		g.Expect(err).NotTo(HaveOccurred())
+		var rendered strings.Builder
+		g.Expect(tmpl.Execute(&rendered, map[string]interface{}{"Values": map[string]interface{}{}})).To(Succeed())
+
+		paramsPath := filepath.Join(t.TempDir(), "params")
+		g.Expect(os.WriteFile(paramsPath, []byte(rendered.String()), 0600)).To(Succeed())
+
+		output, err := exec.Command(bash, "-c", `. "$1" && printf '%s\n' "$ADVERTISE_ADDRESS" "$NODE_ROLE"`, "bash", paramsPath).Output()
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(string(output)).To(Equal(address + "\nworker\n"))
+	})
+}
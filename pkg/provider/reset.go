@@ -3,12 +3,14 @@ package provider
 import (
 	"encoding/json"
 	"fmt"
+	"os"
 	"os/exec"
 	"path/filepath"
 
 	"github.com/kairos-io/kairos-sdk/bus"
 	"github.com/kairos-io/kairos-sdk/clusterplugin"
 	"github.com/kairos-io/provider-canonical/pkg/domain"
+	"github.com/kairos-io/provider-canonical/pkg/log"
 	"github.com/mudler/go-pluggable"
 	"github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v3"
@@ -39,7 +41,11 @@ func HandleClusterReset(event *pluggable.Event) pluggable.EventResponse {
 		return response
 	}
 
+	log.InitLogger(getLogConfig(*config.Cluster).WithEnv())
+	log.SetPhase("reset")
+
 	cmd := exec.Command(filepath.Join(domain.CanonicalScriptDir, "reset.sh"), string(config.Cluster.Role))
+	cmd.Env = append(os.Environ(), "METRICS_DIR="+getMetricsDir(config.Cluster.ProviderOptions))
 	output, _ := cmd.CombinedOutput()
 
 	logrus.Info("reset node script output: ", string(output))
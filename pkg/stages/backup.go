@@ -0,0 +1,94 @@
+package stages
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/kairos-io/provider-canonical/pkg/domain"
+	"github.com/kairos-io/provider-canonical/pkg/fs"
+	"github.com/kairos-io/provider-canonical/pkg/utils"
+	yip "github.com/mudler/yip/pkg/schema"
+)
+
+const (
+	systemdUnitDir = "/etc/systemd/system"
+
+	backupServiceName = "provider-canonical-backup.service"
+	backupTimerName   = "provider-canonical-backup.timer"
+)
+
+// getBackupScheduleStages installs a systemd timer running the provider backup
+// subcommand on the configured schedule, or removes a previously installed one
+// once backups have been disabled.
+func getBackupScheduleStages(clusterCtx *domain.ClusterContext) []yip.Stage {
+	if !clusterCtx.Backup.Enabled() {
+		return getBackupScheduleRemovalStages()
+	}
+
+	return []yip.Stage{
+		{
+			Name: "Generate Datastore Backup Timer",
+			Files: []yip.File{
+				{
+					Path:        filepath.Join(systemdUnitDir, backupServiceName),
+					Permissions: 0644,
+					Content:     backupServiceUnit(clusterCtx.Backup),
+				},
+				{
+					Path:        filepath.Join(systemdUnitDir, backupTimerName),
+					Permissions: 0644,
+					Content:     backupTimerUnit(clusterCtx.Backup),
+				},
+			},
+		},
+		{
+			Name: "Enable Datastore Backup Timer",
+			Commands: []string{
+				"systemctl daemon-reload",
+				fmt.Sprintf("systemctl enable --now %s", backupTimerName),
+			},
+		},
+	}
+}
+
+func getBackupScheduleRemovalStages() []yip.Stage {
+	timerPath := filepath.Join(systemdUnitDir, backupTimerName)
+	if !utils.FileExists(fs.OSFS, timerPath) {
+		return nil
+	}
+
+	return []yip.Stage{
+		{
+			Name: "Remove Datastore Backup Timer",
+			Commands: []string{
+				fmt.Sprintf("systemctl disable --now %s", backupTimerName),
+				fmt.Sprintf("rm -f %s %s", timerPath, filepath.Join(systemdUnitDir, backupServiceName)),
+				"systemctl daemon-reload",
+			},
+		},
+	}
+}
+
+func backupServiceUnit(backup domain.BackupConfig) string {
+	return fmt.Sprintf(`[Unit]
+Description=Canonical K8s datastore backup
+After=snap.k8s.k8sd.service
+
+[Service]
+Type=oneshot
+ExecStart=%s backup --dir=%s --retention=%d
+`, domain.ProviderBinaryPath, backup.Dir, backup.Retention)
+}
+
+func backupTimerUnit(backup domain.BackupConfig) string {
+	return fmt.Sprintf(`[Unit]
+Description=Scheduled Canonical K8s datastore backup
+
+[Timer]
+OnCalendar=%s
+Persistent=true
+
+[Install]
+WantedBy=timers.target
+`, backup.Schedule)
+}
@@ -0,0 +1,64 @@
+package stages
+
+import (
+	"testing"
+
+	"github.com/kairos-io/provider-canonical/pkg/domain"
+	"github.com/kairos-io/provider-canonical/pkg/fs"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4/vfst"
+)
+
+func TestGetBackupScheduleStages(t *testing.T) {
+	g := NewWithT(t)
+
+	t.Run("generates the backup service and timer when a schedule is set", func(t *testing.T) {
+		clusterCtx := &domain.ClusterContext{
+			Backup: domain.BackupConfig{
+				Schedule:  "*-*-* 02:00:00",
+				Retention: 3,
+				Dir:       "/var/lib/provider-canonical/backups",
+			},
+		}
+
+		stages := getBackupScheduleStages(clusterCtx)
+
+		g.Expect(stages).To(HaveLen(2))
+		g.Expect(stages[0].Files).To(HaveLen(2))
+		g.Expect(stages[0].Files[0].Path).To(Equal("/etc/systemd/system/provider-canonical-backup.service"))
+		g.Expect(stages[0].Files[0].Content).To(ContainSubstring(
+			"ExecStart=/usr/local/system/providers/agent-provider-canonical backup --dir=/var/lib/provider-canonical/backups --retention=3"))
+		g.Expect(stages[0].Files[1].Path).To(Equal("/etc/systemd/system/provider-canonical-backup.timer"))
+		g.Expect(stages[0].Files[1].Content).To(ContainSubstring("OnCalendar=*-*-* 02:00:00"))
+		g.Expect(stages[1].Commands).To(ContainElement("systemctl enable --now provider-canonical-backup.timer"))
+	})
+
+	t.Run("returns no stages when backups were never enabled", func(t *testing.T) {
+		testFS, cleanup, err := vfst.NewTestFS(map[string]interface{}{})
+		g.Expect(err).NotTo(HaveOccurred())
+		defer cleanup()
+
+		originalFS := fs.OSFS
+		fs.OSFS = testFS
+		defer func() { fs.OSFS = originalFS }()
+
+		g.Expect(getBackupScheduleStages(&domain.ClusterContext{})).To(BeEmpty())
+	})
+
+	t.Run("removes a previously installed timer when backups are disabled", func(t *testing.T) {
+		testFS, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+			"/etc/systemd/system/provider-canonical-backup.timer": "",
+		})
+		g.Expect(err).NotTo(HaveOccurred())
+		defer cleanup()
+
+		originalFS := fs.OSFS
+		fs.OSFS = testFS
+		defer func() { fs.OSFS = originalFS }()
+
+		stages := getBackupScheduleStages(&domain.ClusterContext{})
+
+		g.Expect(stages).To(HaveLen(1))
+		g.Expect(stages[0].Commands).To(ContainElement("systemctl disable --now provider-canonical-backup.timer"))
+	})
+}
@@ -0,0 +1,110 @@
+package stages
+
+import (
+	stderrors "errors"
+	"slices"
+
+	apiv1 "github.com/canonical/k8s-snap-api/api/v1"
+	"github.com/kairos-io/provider-canonical/pkg/domain"
+	"github.com/pkg/errors"
+)
+
+// applyClusterFeatures merges the cluster features into the cluster config of the
+// cluster options. A feature setting the cluster options already set differently is
+// rejected rather than picked, as is a merged config that enables a feature on top
+// of a disabled network. The config is left untouched when anything is rejected.
+func applyClusterFeatures(config *apiv1.UserFacingClusterConfig, features domain.ClusterFeatures) error {
+	merged := *config
+	var errs []error
+
+	if network := features.Network; network != nil {
+		errs = append(errs, mergeValue("network.enabled", &merged.Network.Enabled, network.Enabled))
+	}
+	if ingress := features.Ingress; ingress != nil {
+		errs = append(errs,
+			mergeValue("ingress.enabled", &merged.Ingress.Enabled, ingress.Enabled),
+			mergeValue("ingress.default-tls-secret", &merged.Ingress.DefaultTLSSecret, ingress.DefaultTLSSecret),
+			mergeValue("ingress.enable-proxy-protocol", &merged.Ingress.EnableProxyProtocol, ingress.EnableProxyProtocol),
+		)
+	}
+	if gateway := features.Gateway; gateway != nil {
+		errs = append(errs, mergeValue("gateway.enabled", &merged.Gateway.Enabled, gateway.Enabled))
+	}
+	if lb := features.LoadBalancer; lb != nil {
+		errs = append(errs,
+			mergeValue("load-balancer.enabled", &merged.LoadBalancer.Enabled, lb.Enabled),
+			mergeList("load-balancer.cidrs", &merged.LoadBalancer.CIDRs, lb.CIDRs),
+		)
+		if l2 := lb.L2; l2 != nil {
+			errs = append(errs,
+				mergeValue("load-balancer.l2-mode", &merged.LoadBalancer.L2Mode, l2.Enabled),
+				mergeList("load-balancer.l2-interfaces", &merged.LoadBalancer.L2Interfaces, l2.Interfaces),
+			)
+		}
+		if bgp := lb.BGP; bgp != nil {
+			errs = append(errs,
+				mergeValue("load-balancer.bgp-mode", &merged.LoadBalancer.BGPMode, bgp.Enabled),
+				mergeValue("load-balancer.bgp-local-asn", &merged.LoadBalancer.BGPLocalASN, bgp.LocalASN),
+				mergeValue("load-balancer.bgp-peer-address", &merged.LoadBalancer.BGPPeerAddress, bgp.PeerAddress),
+				mergeValue("load-balancer.bgp-peer-asn", &merged.LoadBalancer.BGPPeerASN, bgp.PeerASN),
+				mergeValue("load-balancer.bgp-peer-port", &merged.LoadBalancer.BGPPeerPort, bgp.PeerPort),
+			)
+		}
+	}
+	if storage := features.LocalStorage; storage != nil {
+		errs = append(errs,
+			mergeValue("local-storage.enabled", &merged.LocalStorage.Enabled, storage.Enabled),
+			mergeValue("local-storage.local-path", &merged.LocalStorage.LocalPath, storage.LocalPath),
+			mergeValue("local-storage.reclaim-policy", &merged.LocalStorage.ReclaimPolicy, storage.ReclaimPolicy),
+			mergeValue("local-storage.default", &merged.LocalStorage.Default, storage.Default),
+		)
+	}
+	if metricsServer := features.MetricsServer; metricsServer != nil {
+		errs = append(errs, mergeValue("metrics-server.enabled", &merged.MetricsServer.Enabled, metricsServer.Enabled))
+	}
+
+	// the network defaults to enabled, the other features to disabled
+	if merged.Network.Enabled != nil && !*merged.Network.Enabled {
+		if merged.Ingress.GetEnabled() {
+			errs = append(errs, errors.New("ingress requires the network feature"))
+		}
+		if merged.Gateway.GetEnabled() {
+			errs = append(errs, errors.New("gateway requires the network feature"))
+		}
+		if merged.LoadBalancer.GetEnabled() {
+			errs = append(errs, errors.New("load-balancer requires the network feature"))
+		}
+	}
+
+	if err := stderrors.Join(errs...); err != nil {
+		return err
+	}
+	*config = merged
+	return nil
+}
+
+// mergeValue sets the config value to the feature value, unless the feature leaves
+// it unset. A different value already in the config is a conflict.
+func mergeValue[T comparable](name string, config **T, feature *T) error {
+	if feature == nil {
+		return nil
+	}
+	if *config != nil && **config != *feature {
+		return errors.Errorf("cluster feature %s %v conflicts with %v in the cluster options", name, *feature, **config)
+	}
+	value := *feature
+	*config = &value
+	return nil
+}
+
+func mergeList(name string, config **[]string, feature []string) error {
+	if len(feature) == 0 {
+		return nil
+	}
+	if *config != nil && !slices.Equal(**config, feature) {
+		return errors.Errorf("cluster feature %s %v conflicts with %v in the cluster options", name, feature, **config)
+	}
+	value := append([]string(nil), feature...)
+	*config = &value
+	return nil
+}
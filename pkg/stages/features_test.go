@@ -0,0 +1,56 @@
+package stages
+
+import (
+	"testing"
+
+	apiv1 "github.com/canonical/k8s-snap-api/api/v1"
+	"github.com/kairos-io/provider-canonical/pkg/domain"
+	. "github.com/onsi/gomega"
+)
+
+func TestApplyClusterFeatures(t *testing.T) {
+	g := NewWithT(t)
+	enabled, disabled := true, false
+
+	t.Run("keeps the matching settings of the cluster options", func(t *testing.T) {
+		path := "/var/lib/local-storage"
+		config := apiv1.UserFacingClusterConfig{
+			LocalStorage: apiv1.LocalStorageConfig{Enabled: &enabled, LocalPath: &path},
+		}
+		err := applyClusterFeatures(&config, domain.ClusterFeatures{
+			LocalStorage: &domain.LocalStorageFeature{Enabled: &enabled, LocalPath: &path, Default: &disabled},
+		})
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(*config.LocalStorage.LocalPath).To(Equal(path))
+		g.Expect(*config.LocalStorage.Default).To(BeFalse())
+	})
+
+	t.Run("rejects conflicts without touching the config", func(t *testing.T) {
+		cidrs := []string{"10.0.0.0/28"}
+		policy := "Delete"
+		config := apiv1.UserFacingClusterConfig{
+			LoadBalancer: apiv1.LoadBalancerConfig{Enabled: &enabled, CIDRs: &cidrs},
+			LocalStorage: apiv1.LocalStorageConfig{ReclaimPolicy: &policy},
+		}
+		retain := "Retain"
+		err := applyClusterFeatures(&config, domain.ClusterFeatures{
+			Gateway:      &domain.ToggleFeature{Enabled: &enabled},
+			LoadBalancer: &domain.LoadBalancerFeature{Enabled: &enabled, CIDRs: []string{"10.0.1.0/28"}},
+			LocalStorage: &domain.LocalStorageFeature{ReclaimPolicy: &retain},
+		})
+		g.Expect(err).To(MatchError(ContainSubstring("cluster feature load-balancer.cidrs [10.0.1.0/28] conflicts with [10.0.0.0/28]")))
+		g.Expect(err).To(MatchError(ContainSubstring("cluster feature local-storage.reclaim-policy Retain conflicts with Delete")))
+		g.Expect(config.Gateway.Enabled).To(BeNil())
+		g.Expect(*config.LoadBalancer.CIDRs).To(Equal([]string{"10.0.0.0/28"}))
+	})
+
+	t.Run("rejects features on top of a network disabled in the cluster options", func(t *testing.T) {
+		config := apiv1.UserFacingClusterConfig{
+			Network: apiv1.NetworkConfig{Enabled: &disabled},
+		}
+		err := applyClusterFeatures(&config, domain.ClusterFeatures{
+			Gateway: &domain.ToggleFeature{Enabled: &enabled},
+		})
+		g.Expect(err).To(MatchError("gateway requires the network feature"))
+	})
+}
@@ -7,8 +7,10 @@ import (
 	apiv1 "github.com/canonical/k8s-snap-api/api/v1"
 	"github.com/kairos-io/provider-canonical/pkg/domain"
 	"github.com/kairos-io/provider-canonical/pkg/fs"
+	"github.com/kairos-io/provider-canonical/pkg/status"
 	"github.com/kairos-io/provider-canonical/pkg/utils"
 	yip "github.com/mudler/yip/pkg/schema"
+	"github.com/pkg/errors"
 	"gopkg.in/yaml.v3"
 )
 
@@ -17,30 +19,54 @@ func GetInitStage(clusterCtx *domain.ClusterContext) []yip.Stage {
 	var canonicalConfig apiv1.BootstrapConfig
 	_ = yaml.Unmarshal([]byte(clusterCtx.UserOptions), &canonicalConfig)
 
-	canonicalConfig.ExtraSANs = appendIfNotPresent(canonicalConfig.ExtraSANs, clusterCtx.ControlPlaneHost)
+	canonicalConfig.ExtraSANs = getExtraSANs(canonicalConfig.ExtraSANs, clusterCtx)
 
 	enableDns := true
 	allocateNodeCidrs := "true"
 
+	if canonicalConfig.ExtraNodeKubeControllerManagerArgs == nil {
+		canonicalConfig.ExtraNodeKubeControllerManagerArgs = map[string]*string{}
+	}
+	if canonicalConfig.ExtraNodeKubeletArgs == nil {
+		canonicalConfig.ExtraNodeKubeletArgs = map[string]*string{}
+	}
+
+	rejected := append([]error(nil), clusterCtx.ConfigErrors...)
 	canonicalConfig.ClusterConfig.DNS.Enabled = &enableDns
+	if err := applyClusterFeatures(&canonicalConfig.ClusterConfig, clusterCtx.Features); err != nil {
+		rejected = append(rejected, errors.Wrap(err, "conflicting cluster_features"))
+	}
 	canonicalConfig.ExtraNodeKubeControllerManagerArgs["--allocate-node-cidrs"] = &allocateNodeCidrs
 	canonicalConfig.ExtraNodeKubeControllerManagerArgs["--cluster-cidr"] = canonicalConfig.PodCIDR
+	setNodeCidrMaskArgs(canonicalConfig.ExtraNodeKubeControllerManagerArgs, getClusterNetwork(clusterCtx, &rejected), &rejected)
+	setKubeletNodeIPArg(canonicalConfig.ExtraNodeKubeletArgs, clusterCtx)
+	setControlPlaneRegistrationArgs(canonicalConfig.ExtraNodeKubeletArgs, clusterCtx, canonicalConfig.ControlPlaneTaints)
 
 	config, _ := yaml.Marshal(canonicalConfig)
 
-	stages = append(stages,
-		getConfigFileStage(string(config)),
-		getBootstrapStage(clusterCtx.CustomAdvertiseAddress),
-		getUpgradeStage(clusterCtx))
+	stages = append(stages, getConfigFileStage(string(config)))
+	switch {
+	case len(rejected) > 0 && clusterCtx.InitMode == domain.InitModeRestore:
+		stages = append(stages, getRejectedStage("Reject Canonical Restore", status.PhaseRestore, "/opt/canonical/canonical.bootstrap", clusterCtx, rejected))
+	case len(rejected) > 0:
+		stages = append(stages, getRejectedStage("Reject Canonical Bootstrap", status.PhaseBootstrap, "/opt/canonical/canonical.bootstrap", clusterCtx, rejected))
+	case clusterCtx.InitMode == domain.InitModeRestore:
+		stages = append(stages, getRestoreStage())
+	default:
+		stages = append(stages, getBootstrapStage())
+	}
+	stages = append(stages, getUpgradeStage())
 
 	if utils.DirExists(fs.OSFS, domain.KubeComponentsArgsPath) {
-		stages = append(stages, getBootstrapReconfigureStage(canonicalConfig)...)
+		stages = append(stages, trackPhase(status.PhaseReconfigure, clusterCtx, getBootstrapReconfigureStage(canonicalConfig))...)
 	}
 
 	if certStage := getApiserverCertRegenerateStage(canonicalConfig.ExtraSANs); certStage != nil {
-		stages = append(stages, *certStage...)
+		stages = append(stages, trackPhase(status.PhaseCertRegeneration, clusterCtx, *certStage)...)
 	}
 
+	stages = append(stages, getBackupScheduleStages(clusterCtx)...)
+
 	return stages
 }
 
@@ -48,12 +74,25 @@ func getConfigFileStage(bootstrapConfig string) yip.Stage {
 	return utils.GetFileStage("Generate Bootstrap Config", "/opt/canonical/bootstrap-config.yaml", bootstrapConfig, 0640)
 }
 
-func getBootstrapStage(advertiseAddress string) yip.Stage {
+func getBootstrapStage() yip.Stage {
 	return yip.Stage{
 		Name: "Run Canonical Bootstrap",
-		If:   fmt.Sprintf("[ ! -f %s ]", "/opt/canonical/canonical.bootstrap"),
+		If:   fmt.Sprintf("[ ! -f %s ] && %s", "/opt/canonical/canonical.bootstrap", preflightPassed()),
+		Commands: []string{
+			fmt.Sprintf("bash %s", filepath.Join(domain.CanonicalScriptDir, "bootstrap.sh")),
+		},
+	}
+}
+
+// getRestoreStage rebuilds the cluster from the backup archive of the provider
+// parameters instead of bootstrapping a new one. It shares the bootstrap marker,
+// which restore.sh only writes once the restored cluster reports ready.
+func getRestoreStage() yip.Stage {
+	return yip.Stage{
+		Name: "Run Canonical Restore",
+		If:   fmt.Sprintf("[ ! -f %s ] && %s", "/opt/canonical/canonical.bootstrap", preflightPassed()),
 		Commands: []string{
-			fmt.Sprintf("bash %s %s", filepath.Join(domain.CanonicalScriptDir, "bootstrap.sh"), advertiseAddress),
+			fmt.Sprintf("bash %s", filepath.Join(domain.CanonicalScriptDir, "restore.sh")),
 		},
 	}
 }
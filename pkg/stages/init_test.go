@@ -1,9 +1,13 @@
 package stages
 
 import (
+	"errors"
 	"testing"
 
+	apiv1 "github.com/canonical/k8s-snap-api/api/v1"
+	"github.com/kairos-io/provider-canonical/pkg/domain"
 	. "github.com/onsi/gomega"
+	"gopkg.in/yaml.v3"
 )
 
 func TestAppendIfNotPresent(t *testing.T) {
@@ -21,3 +25,113 @@ func TestAppendIfNotPresent(t *testing.T) {
 		g.Expect(result).To(Equal([]string{"a", "b", "c"}))
 	})
 }
+
+func TestGetInitStage(t *testing.T) {
+	g := NewWithT(t)
+
+	userOptions := `
+pod-cidr: 10.244.0.0/16
+service-cidr: 10.96.0.0/12
+extra-node-kube-controller-manager-args:
+  --profiling: "false"
+`
+
+	t.Run("bootstraps a new cluster by default", func(t *testing.T) {
+		clusterCtx := &domain.ClusterContext{
+			InitMode:    domain.InitModeBootstrap,
+			UserOptions: userOptions,
+		}
+
+		stages := GetInitStage(clusterCtx)
+
+		g.Expect(stages[1].Name).To(Equal("Run Canonical Bootstrap"))
+	})
+
+	t.Run("restores from a backup archive in restore mode", func(t *testing.T) {
+		clusterCtx := &domain.ClusterContext{
+			InitMode:          domain.InitModeRestore,
+			RestoreBackupPath: "/var/lib/provider-canonical/backups/canonical-backup-20260101T000000Z.tar.gz",
+			UserOptions:       userOptions,
+		}
+
+		stages := GetInitStage(clusterCtx)
+
+		g.Expect(stages[1].Name).To(Equal("Run Canonical Restore"))
+		g.Expect(stages[1].If).To(Equal("[ ! -f /opt/canonical/canonical.bootstrap ] && [ ! -f /run/provider-canonical/preflight.failed ]"))
+		g.Expect(stages[1].Commands).To(Equal([]string{
+			"bash /opt/canonical/scripts/restore.sh",
+		}))
+		g.Expect(GetProviderParamsStage(clusterCtx).Files[0].Content).To(ContainSubstring("RESTORE_BACKUP_PATH='/var/lib/provider-canonical/backups/canonical-backup-20260101T000000Z.tar.gz'"))
+		for _, stage := range stages {
+			g.Expect(stage.Name).NotTo(Equal("Run Canonical Bootstrap"))
+		}
+	})
+
+	t.Run("merges the cluster features into the cluster config", func(t *testing.T) {
+		enabled, disabled := true, false
+		clusterCtx := &domain.ClusterContext{
+			InitMode:    domain.InitModeBootstrap,
+			UserOptions: userOptions + "cluster-config:\n  gateway:\n    enabled: true\n",
+			Features: domain.ClusterFeatures{
+				Gateway:       &domain.ToggleFeature{Enabled: &enabled},
+				MetricsServer: &domain.ToggleFeature{Enabled: &disabled},
+				LoadBalancer: &domain.LoadBalancerFeature{
+					Enabled: &enabled,
+					CIDRs:   []string{"10.0.0.0/28"},
+					L2:      &domain.L2Mode{Interfaces: []string{"eth0"}},
+				},
+			},
+		}
+
+		var config apiv1.BootstrapConfig
+		g.Expect(yaml.Unmarshal([]byte(GetInitStage(clusterCtx)[0].Files[0].Content), &config)).To(Succeed())
+		g.Expect(*config.ClusterConfig.DNS.Enabled).To(BeTrue())
+		g.Expect(*config.ClusterConfig.Gateway.Enabled).To(BeTrue())
+		g.Expect(*config.ClusterConfig.MetricsServer.Enabled).To(BeFalse())
+		g.Expect(*config.ClusterConfig.LoadBalancer.CIDRs).To(Equal([]string{"10.0.0.0/28"}))
+		g.Expect(*config.ClusterConfig.LoadBalancer.L2Interfaces).To(Equal([]string{"eth0"}))
+	})
+
+	t.Run("rejects cluster features contradicting the cluster config", func(t *testing.T) {
+		enabled := true
+		clusterCtx := &domain.ClusterContext{
+			InitMode:    domain.InitModeBootstrap,
+			UserOptions: userOptions + "cluster-config:\n  network:\n    enabled: false\n  metrics-server:\n    enabled: false\n",
+			Features: domain.ClusterFeatures{
+				Ingress:       &domain.IngressFeature{Enabled: &enabled},
+				MetricsServer: &domain.ToggleFeature{Enabled: &enabled},
+			},
+		}
+
+		stages := GetInitStage(clusterCtx)
+		var config apiv1.BootstrapConfig
+		g.Expect(yaml.Unmarshal([]byte(stages[0].Files[0].Content), &config)).To(Succeed())
+		g.Expect(config.ClusterConfig.Ingress.Enabled).To(BeNil())
+		g.Expect(*config.ClusterConfig.MetricsServer.Enabled).To(BeFalse())
+
+		g.Expect(stages[1].Name).To(Equal("Reject Canonical Bootstrap"))
+		g.Expect(stages[1].If).To(Equal("[ ! -f /opt/canonical/canonical.bootstrap ]"))
+		g.Expect(stages[1].Commands).To(HaveLen(1))
+		g.Expect(stages[1].Commands[0]).To(HavePrefix("/usr/local/system/providers/agent-provider-canonical record-phase --phase bootstrap --state failed --error 'conflicting cluster_features: "))
+		g.Expect(stages[1].Commands[0]).To(ContainSubstring("cluster feature metrics-server.enabled true conflicts with false in the cluster options; ingress requires the network feature'"))
+		for _, stage := range stages {
+			g.Expect(stage.Name).NotTo(Equal("Run Canonical Bootstrap"))
+		}
+	})
+
+	t.Run("rejects invalid provider options in restore mode", func(t *testing.T) {
+		clusterCtx := &domain.ClusterContext{
+			InitMode:          domain.InitModeRestore,
+			RestoreBackupPath: "/var/lib/provider-canonical/backups/canonical-backup-20260101T000000Z.tar.gz",
+			UserOptions:       userOptions,
+			ConfigErrors:      []error{errors.New("invalid cluster_features: load-balancer requires cidrs")},
+		}
+
+		stages := GetInitStage(clusterCtx)
+
+		g.Expect(stages[1].Name).To(Equal("Reject Canonical Restore"))
+		g.Expect(stages[1].Commands).To(Equal([]string{
+			"/usr/local/system/providers/agent-provider-canonical record-phase --phase restore --state failed --error 'invalid cluster_features: load-balancer requires cidrs'",
+		}))
+	})
+}
@@ -10,8 +10,10 @@ import (
 	apiv1 "github.com/canonical/k8s-snap-api/api/v1"
 	"github.com/kairos-io/provider-canonical/pkg/domain"
 	"github.com/kairos-io/provider-canonical/pkg/fs"
+	"github.com/kairos-io/provider-canonical/pkg/status"
 	"github.com/kairos-io/provider-canonical/pkg/utils"
 	yip "github.com/mudler/yip/pkg/schema"
+	"github.com/sirupsen/logrus"
 )
 
 func GetControlPlaneJoinStage(clusterCtx *domain.ClusterContext) []yip.Stage {
@@ -23,24 +25,36 @@ func GetControlPlaneJoinStage(clusterCtx *domain.ClusterContext) []yip.Stage {
 	_ = yaml.Unmarshal([]byte(clusterCtx.UserOptions), &bootstrapConfig)
 
 	allocateNodeCidrs := "true"
+	rejected := append([]error(nil), clusterCtx.ConfigErrors...)
 
-	canonicalConfig.ExtraSANS = appendIfNotPresent(canonicalConfig.ExtraSANS, clusterCtx.ControlPlaneHost)
+	if canonicalConfig.ExtraNodeKubeControllerManagerArgs == nil {
+		canonicalConfig.ExtraNodeKubeControllerManagerArgs = map[string]*string{}
+	}
+	if canonicalConfig.ExtraNodeKubeletArgs == nil {
+		canonicalConfig.ExtraNodeKubeletArgs = map[string]*string{}
+	}
+
+	canonicalConfig.ExtraSANS = getExtraSANs(canonicalConfig.ExtraSANS, clusterCtx)
 	canonicalConfig.ExtraNodeKubeControllerManagerArgs["--allocate-node-cidrs"] = &allocateNodeCidrs
 	canonicalConfig.ExtraNodeKubeControllerManagerArgs["--cluster-cidr"] = bootstrapConfig.PodCIDR
+	setNodeCidrMaskArgs(canonicalConfig.ExtraNodeKubeControllerManagerArgs, getClusterNetwork(clusterCtx, &rejected), &rejected)
+	setKubeletNodeIPArg(canonicalConfig.ExtraNodeKubeletArgs, clusterCtx)
+	setControlPlaneRegistrationArgs(canonicalConfig.ExtraNodeKubeletArgs, clusterCtx, bootstrapConfig.ControlPlaneTaints)
 
 	config, _ := yaml.Marshal(canonicalConfig)
 
 	stages = append(stages,
 		getJoinConfigFileStage(string(config)),
-		getJoinStage(clusterCtx),
-		getUpgradeStage(clusterCtx))
+		getJoinOrRejectedStage(clusterCtx, rejected),
+		getUpgradeStage())
 
 	if utils.DirExists(fs.OSFS, domain.KubeComponentsArgsPath) {
-		stages = append(stages, getControlPlaneReconfigureStage(canonicalConfig)...)
+		stages = append(stages, trackPhase(status.PhaseReconfigure, clusterCtx, getControlPlaneReconfigureStage(canonicalConfig))...)
 	}
 	if certStage := getApiserverCertRegenerateStage(canonicalConfig.ExtraSANS); certStage != nil {
-		stages = append(stages, *certStage...)
+		stages = append(stages, trackPhase(status.PhaseCertRegeneration, clusterCtx, *certStage)...)
 	}
+	stages = append(stages, getBackupScheduleStages(clusterCtx)...)
 	return stages
 }
 
@@ -49,15 +63,28 @@ func GetWorkerJoinStage(clusterCtx *domain.ClusterContext) []yip.Stage {
 	var canonicalConfig apiv1.WorkerJoinConfig
 	_ = yaml.Unmarshal([]byte(clusterCtx.UserOptions), &canonicalConfig)
 
+	if canonicalConfig.ExtraNodeKubeletArgs == nil {
+		canonicalConfig.ExtraNodeKubeletArgs = map[string]*string{}
+	}
+	setKubeletNodeIPArg(canonicalConfig.ExtraNodeKubeletArgs, clusterCtx)
+	setNodeRegistrationArgs(canonicalConfig.ExtraNodeKubeletArgs, clusterCtx)
+
+	// a worker only uses the CIDRs in its NO_PROXY, so an invalid network doesn't
+	// keep it from joining a cluster that bootstrapped with a valid one
+	if _, err := clusterCtx.ClusterNetwork(); err != nil {
+		logrus.Warnf("invalid cluster network, the NO_PROXY of the node may miss the cluster CIDRs: %v", err)
+	}
+	rejected := append([]error(nil), clusterCtx.ConfigErrors...)
+
 	config, _ := yaml.Marshal(canonicalConfig)
 
 	stages = append(stages,
 		getJoinConfigFileStage(string(config)),
-		getJoinStage(clusterCtx),
-		getUpgradeStage(clusterCtx))
+		getJoinOrRejectedStage(clusterCtx, rejected),
+		getUpgradeStage())
 
 	if utils.DirExists(fs.OSFS, domain.KubeComponentsArgsPath) {
-		stages = append(stages, getWorkerReconfigureStage(canonicalConfig)...)
+		stages = append(stages, trackPhase(status.PhaseReconfigure, clusterCtx, getWorkerReconfigureStage(canonicalConfig))...)
 	}
 	return stages
 }
@@ -66,12 +93,19 @@ func getJoinConfigFileStage(bootstrapConfig string) yip.Stage {
 	return utils.GetFileStage("Generate Join Config", "/opt/canonical/join-config.yaml", bootstrapConfig, 0640)
 }
 
-func getJoinStage(clusterCtx *domain.ClusterContext) yip.Stage {
+func getJoinOrRejectedStage(clusterCtx *domain.ClusterContext, rejected []error) yip.Stage {
+	if len(rejected) > 0 {
+		return getRejectedStage("Reject Canonical Join", status.PhaseJoin, "/opt/canonical/canonical.join", clusterCtx, rejected)
+	}
+	return getJoinStage()
+}
+
+func getJoinStage() yip.Stage {
 	return yip.Stage{
 		Name: "Run Canonical Join",
-		If:   fmt.Sprintf("[ ! -f %s ]", "/opt/canonical/canonical.join"),
+		If:   fmt.Sprintf("[ ! -f %s ] && %s", "/opt/canonical/canonical.join", preflightPassed()),
 		Commands: []string{
-			fmt.Sprintf("bash %s %s %s %s", filepath.Join(domain.CanonicalScriptDir, "join.sh"), clusterCtx.ClusterToken, clusterCtx.CustomAdvertiseAddress, clusterCtx.NodeRole),
+			fmt.Sprintf("bash %s", filepath.Join(domain.CanonicalScriptDir, "join.sh")),
 		},
 	}
 }
@@ -0,0 +1,121 @@
+package stages
+
+import (
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/kairos-io/provider-canonical/pkg/domain"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultIPv4NodeCidrMaskSize = 24
+	defaultIPv6NodeCidrMaskSize = 64
+
+	// kube-controller-manager refuses node CIDR masks more than 16 bits longer
+	// than the cluster CIDR
+	maxNodeCidrMaskDiff = 16
+)
+
+// getClusterNetwork returns the validated cluster network, adding the error to
+// rejected when the CIDRs are invalid, as the node would come up with args
+// inconsistent with them.
+func getClusterNetwork(clusterCtx *domain.ClusterContext, rejected *[]error) domain.ClusterNetwork {
+	network, err := clusterCtx.ClusterNetwork()
+	if err != nil {
+		*rejected = append(*rejected, errors.Wrap(err, "invalid cluster network"))
+		return domain.ClusterNetwork{}
+	}
+	return network
+}
+
+// setNodeCidrMaskArgs sets the controller-manager node CIDR mask size of each pod
+// CIDR family of IPv6-only and dual-stack clusters, unless set by the user. IPv4
+// clusters keep the controller-manager default. A pod CIDR too small to split into
+// node ranges of that size is added to rejected, as the controller-manager would
+// fail to allocate any.
+func setNodeCidrMaskArgs(args map[string]*string, network domain.ClusterNetwork, rejected *[]error) {
+	setMask := func(arg string, podCidr netip.Prefix) {
+		if _, ok := args[arg]; ok {
+			return
+		}
+		mask := nodeCidrMaskSize(podCidr)
+		if mask <= podCidr.Bits() {
+			*rejected = append(*rejected, errors.Errorf("pod CIDR %s leaves no room for /%d node ranges, use a larger pod CIDR or set %s", podCidr, mask, arg))
+			return
+		}
+		setArgIfAbsent(args, arg, strconv.Itoa(mask))
+	}
+
+	switch network.Stack() {
+	case domain.StackIPv6:
+		setMask("--node-cidr-mask-size", network.PodCIDRs[0])
+	case domain.StackDual:
+		for _, prefix := range network.PodCIDRs {
+			if prefix.Addr().Is6() {
+				setMask("--node-cidr-mask-size-ipv6", prefix)
+			} else {
+				setMask("--node-cidr-mask-size-ipv4", prefix)
+			}
+		}
+	}
+}
+
+func nodeCidrMaskSize(podCidr netip.Prefix) int {
+	mask := defaultIPv4NodeCidrMaskSize
+	if podCidr.Addr().Is6() {
+		mask = defaultIPv6NodeCidrMaskSize
+	}
+	if mask-podCidr.Bits() > maxNodeCidrMaskDiff {
+		mask = podCidr.Bits() + maxNodeCidrMaskDiff
+	}
+	return mask
+}
+
+// setKubeletNodeIPArg sets the kubelet node IPs from the advertise addresses of
+// IPv6 and dual-stack nodes, and of nodes resolving them from an interface or CIDR,
+// unless set by the user. The kubelet picks the right IPv4 address on its own.
+func setKubeletNodeIPArg(args map[string]*string, clusterCtx *domain.ClusterContext) {
+	if len(clusterCtx.NodeIPs) == 0 {
+		return
+	}
+	if len(clusterCtx.NodeIPs) == 1 && !strings.Contains(clusterCtx.NodeIPs[0], ":") && !clusterCtx.Advertise.Enabled() {
+		return
+	}
+	setArgIfAbsent(args, "--node-ip", strings.Join(clusterCtx.NodeIPs, ","))
+}
+
+// getExtraSANs returns the API server SANs of a control plane node: the control
+// plane host without brackets or port, and all its dual-stack node IPs.
+func getExtraSANs(sans []string, clusterCtx *domain.ClusterContext) []string {
+	if host := normalizeSAN(clusterCtx.ControlPlaneHost); host != "" {
+		sans = appendIfNotPresent(sans, host)
+	}
+	if len(clusterCtx.NodeIPs) > 1 {
+		for _, ip := range clusterCtx.NodeIPs {
+			sans = appendIfNotPresent(sans, ip)
+		}
+	}
+	return sans
+}
+
+func normalizeSAN(host string) string {
+	host = strings.TrimSpace(host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return addr.String()
+	}
+	return host
+}
+
+func setArgIfAbsent(args map[string]*string, key, value string) {
+	if _, ok := args[key]; ok {
+		return
+	}
+	args[key] = &value
+}
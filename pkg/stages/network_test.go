@@ -0,0 +1,153 @@
+package stages
+
+import (
+	"testing"
+
+	apiv1 "github.com/canonical/k8s-snap-api/api/v1"
+	"github.com/kairos-io/provider-canonical/pkg/domain"
+	. "github.com/onsi/gomega"
+	"gopkg.in/yaml.v3"
+)
+
+func TestClusterNetworkLayouts(t *testing.T) {
+	g := NewWithT(t)
+
+	initConfig := func(clusterCtx *domain.ClusterContext) apiv1.BootstrapConfig {
+		stages := GetInitStage(clusterCtx)
+		var config apiv1.BootstrapConfig
+		g.Expect(yaml.Unmarshal([]byte(stages[0].Files[0].Content), &config)).To(Succeed())
+		return config
+	}
+
+	t.Run("ipv4 keeps the controller-manager and kubelet defaults", func(t *testing.T) {
+		config := initConfig(&domain.ClusterContext{
+			ClusterCidr:            "10.244.0.0/16",
+			ServiceCidr:            "10.96.0.0/12",
+			ControlPlaneHost:       "10.0.0.10:6443",
+			CustomAdvertiseAddress: "10.0.0.5",
+			NodeIPs:                []string{"10.0.0.5"},
+			UserOptions:            "pod-cidr: 10.244.0.0/16\nservice-cidr: 10.96.0.0/12\n",
+		})
+
+		g.Expect(*config.ExtraNodeKubeControllerManagerArgs["--cluster-cidr"]).To(Equal("10.244.0.0/16"))
+		g.Expect(config.ExtraNodeKubeControllerManagerArgs).NotTo(HaveKey("--node-cidr-mask-size"))
+		g.Expect(config.ExtraNodeKubeControllerManagerArgs).NotTo(HaveKey("--node-cidr-mask-size-ipv4"))
+		g.Expect(config.ExtraNodeKubeletArgs).NotTo(HaveKey("--node-ip"))
+		g.Expect(config.ExtraSANs).To(Equal([]string{"10.0.0.10"}))
+	})
+
+	t.Run("ipv4 sets the node ip resolved from an interface", func(t *testing.T) {
+		config := initConfig(&domain.ClusterContext{
+			CustomAdvertiseAddress: "10.20.3.4",
+			NodeIPs:                []string{"10.20.3.4"},
+			Advertise:              domain.AdvertiseSelector{Interface: "eth1", Family: domain.StackIPv4},
+		})
+
+		g.Expect(*config.ExtraNodeKubeletArgs["--node-ip"]).To(Equal("10.20.3.4"))
+	})
+
+	t.Run("ipv6 sets the node cidr mask and node ip", func(t *testing.T) {
+		config := initConfig(&domain.ClusterContext{
+			ClusterCidr:            "fd00:10:244::/56",
+			ServiceCidr:            "fd98::/108",
+			ControlPlaneHost:       "[fd00::10]:6443",
+			CustomAdvertiseAddress: "fd00::5",
+			NodeIPs:                []string{"fd00::5"},
+			UserOptions:            "pod-cidr: fd00:10:244::/56\nservice-cidr: fd98::/108\n",
+		})
+
+		g.Expect(*config.ExtraNodeKubeControllerManagerArgs["--cluster-cidr"]).To(Equal("fd00:10:244::/56"))
+		g.Expect(*config.ExtraNodeKubeControllerManagerArgs["--node-cidr-mask-size"]).To(Equal("64"))
+		g.Expect(*config.ExtraNodeKubeletArgs["--node-ip"]).To(Equal("fd00::5"))
+		g.Expect(config.ExtraSANs).To(Equal([]string{"fd00::10"}))
+	})
+
+	t.Run("dual-stack sets per family node cidr masks and both node ips", func(t *testing.T) {
+		config := initConfig(&domain.ClusterContext{
+			ClusterCidr:            "10.244.0.0/16,fd00:10:244::/40",
+			ServiceCidr:            "10.96.0.0/12,fd98::/108",
+			ControlPlaneHost:       "cluster.example.com",
+			CustomAdvertiseAddress: "10.0.0.5",
+			NodeIPs:                []string{"10.0.0.5", "fd00::5"},
+			UserOptions:            "pod-cidr: 10.244.0.0/16,fd00:10:244::/40\nservice-cidr: 10.96.0.0/12,fd98::/108\n",
+		})
+
+		g.Expect(*config.ExtraNodeKubeControllerManagerArgs["--cluster-cidr"]).To(Equal("10.244.0.0/16,fd00:10:244::/40"))
+		g.Expect(*config.ExtraNodeKubeControllerManagerArgs["--node-cidr-mask-size-ipv4"]).To(Equal("24"))
+		// capped to 16 bits past the /40 pod CIDR
+		g.Expect(*config.ExtraNodeKubeControllerManagerArgs["--node-cidr-mask-size-ipv6"]).To(Equal("56"))
+		g.Expect(*config.ExtraNodeKubeletArgs["--node-ip"]).To(Equal("10.0.0.5,fd00::5"))
+		g.Expect(config.ExtraSANs).To(Equal([]string{"cluster.example.com", "10.0.0.5", "fd00::5"}))
+	})
+
+	t.Run("rejects pod CIDRs too small for the node ranges", func(t *testing.T) {
+		stages := GetInitStage(&domain.ClusterContext{
+			ClusterCidr: "10.244.0.0/26,fd00:10:244::/64",
+			ServiceCidr: "10.96.0.0/12,fd98::/108",
+			UserOptions: "pod-cidr: 10.244.0.0/26,fd00:10:244::/64\n",
+		})
+
+		g.Expect(stages[1].Name).To(Equal("Reject Canonical Bootstrap"))
+		g.Expect(stages[1].Commands[0]).To(ContainSubstring("pod CIDR 10.244.0.0/26 leaves no room for /24 node ranges, use a larger pod CIDR or set --node-cidr-mask-size-ipv4"))
+		g.Expect(stages[1].Commands[0]).To(ContainSubstring("pod CIDR fd00:10:244::/64 leaves no room for /64 node ranges, use a larger pod CIDR or set --node-cidr-mask-size-ipv6"))
+
+		config := initConfig(&domain.ClusterContext{
+			ClusterCidr: "10.244.0.0/26,fd00:10:244::/64",
+			ServiceCidr: "10.96.0.0/12,fd98::/108",
+			UserOptions: `pod-cidr: 10.244.0.0/26,fd00:10:244::/64
+extra-node-kube-controller-manager-args:
+  --node-cidr-mask-size-ipv4: "28"
+  --node-cidr-mask-size-ipv6: "80"
+`,
+		})
+		g.Expect(*config.ExtraNodeKubeControllerManagerArgs["--node-cidr-mask-size-ipv4"]).To(Equal("28"))
+	})
+
+	t.Run("keeps user provided node cidr mask and node ip args", func(t *testing.T) {
+		config := initConfig(&domain.ClusterContext{
+			ClusterCidr: "10.244.0.0/16,fd00:10:244::/56",
+			ServiceCidr: "10.96.0.0/12,fd98::/108",
+			NodeIPs:     []string{"10.0.0.5", "fd00::5"},
+			UserOptions: `pod-cidr: 10.244.0.0/16,fd00:10:244::/56
+service-cidr: 10.96.0.0/12,fd98::/108
+extra-node-kube-controller-manager-args:
+  --node-cidr-mask-size-ipv6: "72"
+extra-node-kubelet-args:
+  --node-ip: "10.0.0.7,fd00::7"
+`,
+		})
+
+		g.Expect(*config.ExtraNodeKubeControllerManagerArgs["--node-cidr-mask-size-ipv6"]).To(Equal("72"))
+		g.Expect(*config.ExtraNodeKubeletArgs["--node-ip"]).To(Equal("10.0.0.7,fd00::7"))
+	})
+
+	t.Run("worker join sets the dual-stack node ips", func(t *testing.T) {
+		stages := GetWorkerJoinStage(&domain.ClusterContext{
+			NodeRole: "worker",
+			NodeIPs:  []string{"10.0.0.6", "fd00::6"},
+		})
+		var config apiv1.WorkerJoinConfig
+		g.Expect(yaml.Unmarshal([]byte(stages[0].Files[0].Content), &config)).To(Succeed())
+		g.Expect(*config.ExtraNodeKubeletArgs["--node-ip"]).To(Equal("10.0.0.6,fd00::6"))
+	})
+
+	t.Run("rejects the bootstrap and join of an invalid network", func(t *testing.T) {
+		mismatched := &domain.ClusterContext{
+			NodeRole:    "control-plane",
+			ClusterCidr: "10.244.0.0/16",
+			ServiceCidr: "fd98::/108",
+		}
+
+		stages := GetControlPlaneJoinStage(mismatched)
+		g.Expect(stages[1].Name).To(Equal("Reject Canonical Join"))
+		g.Expect(stages[1].Commands[0]).To(ContainSubstring(`--phase join --state failed --error 'invalid cluster network: pod CIDR "10.244.0.0/16" and service CIDR "fd98::/108" are not of the same IP families'`))
+
+		// a worker only uses the CIDRs in its NO_PROXY
+		stages = GetWorkerJoinStage(mismatched)
+		g.Expect(stages[1].Name).To(Equal("Run Canonical Join"))
+
+		stages = GetInitStage(&domain.ClusterContext{ClusterCidr: "10.244.0.0/33"})
+		g.Expect(stages[1].Name).To(Equal("Reject Canonical Bootstrap"))
+		g.Expect(stages[1].Commands[0]).To(ContainSubstring("--error 'invalid cluster network: invalid pod CIDR"))
+	})
+}
@@ -0,0 +1,64 @@
+package stages
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kairos-io/provider-canonical/pkg/domain"
+	yip "github.com/mudler/yip/pkg/schema"
+)
+
+// GetProviderParamsStage writes the node parameters the bootstrap, restore, join
+// and upgrade scripts read, along with their retry policy. None of the values may
+// be trusted to be shell safe, so they are passed as a root only file of quoted
+// assignments rather than on the command lines of the stages. The join token has
+// a file of its own, see domain.JoinTokenPath.
+func GetProviderParamsStage(clusterCtx *domain.ClusterContext) yip.Stage {
+	params := map[string]string{
+		"NODE_ROLE":         clusterCtx.NodeRole,
+		"ADVERTISE_ADDRESS": clusterCtx.CustomAdvertiseAddress,
+		"CONFIG_HASH":       clusterCtx.ConfigHash,
+		"METRICS_DIR":       clusterCtx.MetricsDir,
+		"SCRIPT_LOG_FILES":  strconv.FormatBool(clusterCtx.ScriptLogFiles),
+	}
+	for name, value := range retryParams(clusterCtx.Retry) {
+		params[name] = value
+	}
+	if clusterCtx.InitMode == domain.InitModeRestore {
+		params["RESTORE_BACKUP_PATH"] = clusterCtx.RestoreBackupPath
+	}
+
+	return yip.Stage{
+		Name: "Set provider parameters",
+		Files: []yip.File{
+			{
+				Path:        domain.ProviderParamsPath,
+				Permissions: 0600,
+				Content:     shellEnvFileContent(params),
+			},
+		},
+	}
+}
+
+// retryParams passes the retry policy to the scripts in whole seconds.
+func retryParams(policy domain.RetryPolicy) map[string]string {
+	seconds := func(d time.Duration) string {
+		return strconv.Itoa(int(d.Round(time.Second) / time.Second))
+	}
+	return map[string]string{
+		"RETRY_MAX_ATTEMPTS":   strconv.Itoa(policy.MaxAttempts),
+		"RETRY_INITIAL_DELAY":  seconds(policy.InitialDelay),
+		"RETRY_MAX_DELAY":      seconds(policy.MaxDelay),
+		"RETRY_PHASE_DEADLINE": seconds(policy.PhaseDeadline),
+	}
+}
+
+// shellQuote single quotes value for the shell. Braces are closed into quotes of
+// their own, as yip renders file contents and commands as templates and would
+// otherwise expand or choke on a "{{" in the value.
+func shellQuote(value string) string {
+	value = strings.ReplaceAll(value, "'", `'\''`)
+	value = strings.ReplaceAll(value, "{", `{''`)
+	return "'" + value + "'"
+}
@@ -0,0 +1,40 @@
+package stages
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kairos-io/provider-canonical/pkg/domain"
+	. "github.com/onsi/gomega"
+)
+
+func TestGetProviderParamsStage(t *testing.T) {
+	g := NewWithT(t)
+
+	t.Run("passes the retry policy in seconds", func(t *testing.T) {
+		stage := GetProviderParamsStage(&domain.ClusterContext{
+			NodeRole:   "worker",
+			ConfigHash: "b5bb9d8014a0f9b1d61e21e796d78dcc",
+			MetricsDir: domain.DefaultMetricsDir,
+			Retry: domain.RetryPolicy{
+				MaxAttempts:   5,
+				InitialDelay:  1500 * time.Millisecond,
+				MaxDelay:      2 * time.Minute,
+				PhaseDeadline: time.Hour,
+			},
+		})
+
+		g.Expect(stage.Files).To(HaveLen(1))
+		g.Expect(stage.Files[0].Permissions).To(Equal(uint32(0600)))
+		g.Expect(stage.Files[0].Content).To(Equal(`ADVERTISE_ADDRESS=''
+CONFIG_HASH='b5bb9d8014a0f9b1d61e21e796d78dcc'
+METRICS_DIR='/var/lib/node_exporter/textfile_collector'
+NODE_ROLE='worker'
+RETRY_INITIAL_DELAY='2'
+RETRY_MAX_ATTEMPTS='5'
+RETRY_MAX_DELAY='120'
+RETRY_PHASE_DEADLINE='3600'
+SCRIPT_LOG_FILES='false'
+`))
+	})
+}
@@ -0,0 +1,71 @@
+package stages
+
+import (
+	stderrors "errors"
+	"fmt"
+	"strings"
+
+	"github.com/kairos-io/provider-canonical/pkg/domain"
+	"github.com/kairos-io/provider-canonical/pkg/status"
+	yip "github.com/mudler/yip/pkg/schema"
+	"github.com/sirupsen/logrus"
+)
+
+// trackPhase records stages as a phase of the node status: the phase starts with
+// the commands of the first stage, fails with any of the commands, which run
+// through run-phase, and succeeds after the last stage. The commands must not rely
+// on the shell, as run-phase runs them itself.
+func trackPhase(phase string, clusterCtx *domain.ClusterContext, stages []yip.Stage) []yip.Stage {
+	if len(stages) == 0 {
+		return stages
+	}
+
+	flags := phaseFlags(phase, clusterCtx)
+
+	tracked := make([]yip.Stage, len(stages))
+	for i, stage := range stages {
+		commands := make([]string, 0, len(stage.Commands)+2)
+		if i == 0 {
+			start := fmt.Sprintf("%s record-phase %s --state %s", domain.ProviderBinaryPath, flags, status.StateRunning)
+			if clusterCtx.ConfigHash != "" {
+				start += " --config-hash " + clusterCtx.ConfigHash
+			}
+			commands = append(commands, start)
+		}
+		for _, command := range stage.Commands {
+			commands = append(commands, fmt.Sprintf("%s run-phase %s -- %s", domain.ProviderBinaryPath, flags, command))
+		}
+		if i == len(stages)-1 {
+			commands = append(commands, fmt.Sprintf("%s record-phase %s --state %s", domain.ProviderBinaryPath, flags, status.StateSucceeded))
+		}
+		stage.Commands = commands
+		tracked[i] = stage
+	}
+	return tracked
+}
+
+func phaseFlags(phase string, clusterCtx *domain.ClusterContext) string {
+	flags := fmt.Sprintf("--phase %s", phase)
+	if clusterCtx.MetricsDir != "" {
+		flags += " --metrics-dir " + shellQuote(clusterCtx.MetricsDir)
+	}
+	return flags
+}
+
+// getRejectedStage stands in for the bootstrap, restore or join stage of a node
+// whose config is rejected. That config can't be changed once the node is part of
+// the cluster, so rather than carrying on without the rejected settings, the phase
+// is recorded as failed as long as the node hasn't bootstrapped or joined.
+func getRejectedStage(name, phase, marker string, clusterCtx *domain.ClusterContext, errs []error) yip.Stage {
+	err := stderrors.Join(errs...)
+	logrus.Errorf("refusing to %s: %v", phase, err)
+
+	message := strings.ReplaceAll(err.Error(), "\n", "; ")
+	return yip.Stage{
+		Name: name,
+		If:   fmt.Sprintf("[ ! -f %s ]", marker),
+		Commands: []string{
+			fmt.Sprintf("%s record-phase %s --state %s --error %s", domain.ProviderBinaryPath, phaseFlags(phase, clusterCtx), status.StateFailed, shellQuote(message)),
+		},
+	}
+}
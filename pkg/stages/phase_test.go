@@ -0,0 +1,51 @@
+package stages
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/kairos-io/provider-canonical/pkg/domain"
+	yip "github.com/mudler/yip/pkg/schema"
+	. "github.com/onsi/gomega"
+)
+
+func TestTrackPhase(t *testing.T) {
+	g := NewWithT(t)
+
+	t.Run("records the stages as a phase", func(t *testing.T) {
+		stages := trackPhase("proxy", &domain.ClusterContext{ConfigHash: "abc", MetricsDir: "/var/lib/node_exporter"}, []yip.Stage{
+			{Name: "Set proxy config files and envs", Files: []yip.File{{Path: "/etc/default/kubelet"}}},
+			{Name: "Reload systemd", Commands: []string{"systemctl daemon-reload", "systemctl restart snap.k8s.kubelet.service"}},
+		})
+
+		g.Expect(stages).To(HaveLen(2))
+		g.Expect(stages[0].Files).To(HaveLen(1))
+		g.Expect(stages[0].Commands).To(Equal([]string{
+			"/usr/local/system/providers/agent-provider-canonical record-phase --phase proxy --metrics-dir '/var/lib/node_exporter' --state running --config-hash abc",
+		}))
+		g.Expect(stages[1].Commands).To(Equal([]string{
+			"/usr/local/system/providers/agent-provider-canonical run-phase --phase proxy --metrics-dir '/var/lib/node_exporter' -- systemctl daemon-reload",
+			"/usr/local/system/providers/agent-provider-canonical run-phase --phase proxy --metrics-dir '/var/lib/node_exporter' -- systemctl restart snap.k8s.kubelet.service",
+			"/usr/local/system/providers/agent-provider-canonical record-phase --phase proxy --metrics-dir '/var/lib/node_exporter' --state succeeded",
+		}))
+	})
+
+	t.Run("leaves no stages untouched", func(t *testing.T) {
+		g.Expect(trackPhase("proxy", &domain.ClusterContext{}, []yip.Stage{})).To(BeEmpty())
+	})
+}
+
+func TestGetRejectedStage(t *testing.T) {
+	g := NewWithT(t)
+
+	stage := getRejectedStage("Reject Canonical Join", "join", "/opt/canonical/canonical.join", &domain.ClusterContext{MetricsDir: "/var/lib/node_exporter"}, []error{
+		errors.New("invalid pod cidr 'x'"),
+		errors.New("second\nthird"),
+	})
+
+	g.Expect(stage.Name).To(Equal("Reject Canonical Join"))
+	g.Expect(stage.If).To(Equal("[ ! -f /opt/canonical/canonical.join ]"))
+	g.Expect(stage.Commands).To(Equal([]string{
+		`/usr/local/system/providers/agent-provider-canonical record-phase --phase join --metrics-dir '/var/lib/node_exporter' --state failed --error 'invalid pod cidr '\''x'\''; second; third'`,
+	}))
+}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/kairos-io/provider-canonical/pkg/domain"
 	"github.com/kairos-io/provider-canonical/pkg/fs"
+	"github.com/kairos-io/provider-canonical/pkg/status"
 	"github.com/kairos-io/provider-canonical/pkg/utils"
 	yip "github.com/mudler/yip/pkg/schema"
 )
@@ -14,10 +15,12 @@ func GetPreSetupStages(clusterCtx *domain.ClusterContext) []yip.Stage {
 	var stages []yip.Stage
 
 	stages = append(stages, getProviderEnvironmentStage(clusterCtx)...)
-	stages = append(stages, getProxyStage(clusterCtx)...)
-	stages = append(stages, getPreCommandStages())
+	stages = append(stages, trackPhase(status.PhaseProxy, clusterCtx,
+		append(getProxyStage(clusterCtx), getProxyCAStages(clusterCtx)...))...)
+	stages = append(stages, trackPhase(status.PhasePreSetup, clusterCtx, []yip.Stage{getPreCommandStages()})...)
 	if utils.DirExists(fs.OSFS, clusterCtx.LocalImagesPath) {
-		stages = append(stages, getPreImportLocalImageStage(clusterCtx.LocalImagesPath))
+		stages = append(stages, trackPhase(status.PhaseImageImport, clusterCtx,
+			[]yip.Stage{getPreImportLocalImageStage(clusterCtx.LocalImagesPath, clusterCtx.ImageSignature)})...)
 	}
 	return stages
 }
@@ -31,11 +34,34 @@ func getPreCommandStages() yip.Stage {
 	}
 }
 
-func getPreImportLocalImageStage(localImagesPath string) yip.Stage {
-	return yip.Stage{
+// getPreImportLocalImageStage imports the local images, verifying their signatures
+// against the configured key unless the signature policy is off.
+func getPreImportLocalImageStage(localImagesPath string, signature domain.ImageSignatureConfig) yip.Stage {
+	stage := yip.Stage{
 		Name: "Run Import Local Images",
-		Commands: []string{
-			fmt.Sprintf("/bin/sh %s %s", filepath.Join(domain.CanonicalScriptDir, "import-images.sh"), localImagesPath),
-		},
 	}
+
+	if signature.Policy == "" || signature.Policy == domain.SignaturePolicyOff {
+		stage.Commands = []string{
+			fmt.Sprintf("%s import-images --source=%s", domain.ProviderBinaryPath, shellQuote(localImagesPath)),
+		}
+		return stage
+	}
+
+	keyPath := ""
+	if signature.PublicKey != "" {
+		keyPath = domain.ImageSigningKeyPath
+		stage.Files = []yip.File{
+			{
+				Path:        domain.ImageSigningKeyPath,
+				Permissions: 0644,
+				Content:     signature.PublicKey,
+			},
+		}
+	}
+	stage.Commands = []string{
+		fmt.Sprintf("%s import-images --source=%s --signature-policy=%s --public-key=%s",
+			domain.ProviderBinaryPath, shellQuote(localImagesPath), signature.Policy, keyPath),
+	}
+	return stage
 }
@@ -0,0 +1,50 @@
+package stages
+
+import (
+	"fmt"
+
+	apiv1 "github.com/canonical/k8s-snap-api/api/v1"
+	"github.com/kairos-io/kairos-sdk/clusterplugin"
+	"github.com/kairos-io/provider-canonical/pkg/domain"
+	yip "github.com/mudler/yip/pkg/schema"
+	"gopkg.in/yaml.v3"
+)
+
+// GetPreflightStage checks the host before a bootstrap or join, as long as the node
+// hasn't done it yet. Fatal findings leave the preflight failed marker behind, which
+// keeps the bootstrap, restore and join stages from running.
+func GetPreflightStage(clusterCtx *domain.ClusterContext) yip.Stage {
+	marker := "/opt/canonical/canonical.join"
+	command := fmt.Sprintf("%s preflight --role=%s", domain.ProviderBinaryPath, shellQuote(clusterCtx.NodeRole))
+
+	if clusterCtx.NodeRole == string(clusterplugin.RoleInit) {
+		marker = "/opt/canonical/canonical.bootstrap"
+	} else if clusterCtx.ControlPlaneHost != "" {
+		command += fmt.Sprintf(" --control-plane-host=%s", shellQuote(clusterCtx.ControlPlaneHost))
+	}
+	if kubeletAllowsSwap(clusterCtx.UserOptions) {
+		command += " --allow-swap"
+	}
+	if advertise := clusterCtx.Advertise; advertise.Enabled() {
+		command += fmt.Sprintf(" --advertise-interface=%s --advertise-cidr=%s --advertise-ip-family=%s", advertise.Interface, advertise.CIDRString(), advertise.Family)
+	}
+
+	return yip.Stage{
+		Name:     "Run Preflight Checks",
+		If:       fmt.Sprintf("[ ! -f %s ]", marker),
+		Commands: []string{command},
+	}
+}
+
+// preflightPassed is appended to the conditions of the stages gated by preflight.
+func preflightPassed() string {
+	return fmt.Sprintf("[ ! -f %s ]", domain.PreflightFailedPath)
+}
+
+func kubeletAllowsSwap(userOptions string) bool {
+	var config apiv1.WorkerJoinConfig
+	_ = yaml.Unmarshal([]byte(userOptions), &config)
+
+	failSwapOn := config.ExtraNodeKubeletArgs["--fail-swap-on"]
+	return failSwapOn != nil && *failSwapOn == "false"
+}
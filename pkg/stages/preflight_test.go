@@ -0,0 +1,52 @@
+package stages
+
+import (
+	"testing"
+
+	"github.com/kairos-io/provider-canonical/pkg/domain"
+	. "github.com/onsi/gomega"
+)
+
+func TestGetPreflightStage(t *testing.T) {
+	g := NewWithT(t)
+
+	t.Run("runs before the bootstrap of the init node", func(t *testing.T) {
+		stage := GetPreflightStage(&domain.ClusterContext{
+			NodeRole:         "init",
+			ControlPlaneHost: "10.0.0.1",
+		})
+
+		g.Expect(stage.If).To(Equal("[ ! -f /opt/canonical/canonical.bootstrap ]"))
+		g.Expect(stage.Commands).To(Equal([]string{
+			"/usr/local/system/providers/agent-provider-canonical preflight --role='init'",
+		}))
+	})
+
+	t.Run("compares with the control plane before a join", func(t *testing.T) {
+		stage := GetPreflightStage(&domain.ClusterContext{
+			NodeRole:         "worker",
+			ControlPlaneHost: "10.0.0.1",
+			UserOptions:      "extra-node-kubelet-args:\n  --fail-swap-on: \"false\"\n",
+		})
+
+		g.Expect(stage.If).To(Equal("[ ! -f /opt/canonical/canonical.join ]"))
+		g.Expect(stage.Commands).To(Equal([]string{
+			"/usr/local/system/providers/agent-provider-canonical preflight --role='worker' --control-plane-host='10.0.0.1' --allow-swap",
+		}))
+	})
+
+	t.Run("resolves the advertise address again", func(t *testing.T) {
+		advertise, err := domain.ParseAdvertiseSelector("eth1", "10.20.0.0/16", "")
+		g.Expect(err).NotTo(HaveOccurred())
+
+		stage := GetPreflightStage(&domain.ClusterContext{NodeRole: "init", Advertise: advertise})
+		g.Expect(stage.Commands).To(Equal([]string{
+			"/usr/local/system/providers/agent-provider-canonical preflight --role='init' --advertise-interface=eth1 --advertise-cidr=10.20.0.0/16 --advertise-ip-family=ipv4",
+		}))
+	})
+
+	t.Run("gates the join on the preflight outcome", func(t *testing.T) {
+		stage := getJoinStage()
+		g.Expect(stage.If).To(Equal("[ ! -f /opt/canonical/canonical.join ] && [ ! -f /run/provider-canonical/preflight.failed ]"))
+	})
+}
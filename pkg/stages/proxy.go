@@ -4,18 +4,46 @@ import (
 	"fmt"
 	"maps"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/kairos-io/provider-canonical/pkg/domain"
+	"github.com/kairos-io/provider-canonical/pkg/fs"
 	"github.com/kairos-io/provider-canonical/pkg/utils"
 	yip "github.com/mudler/yip/pkg/schema"
+	"github.com/sirupsen/logrus"
 )
 
 const (
 	envFilePrefix = "EnvironmentFile"
 	envFilePath   = "/run/provider-canonical/env"
+
+	// proxyEnvFilePath is the proxy environment of the k8s snap services. Unlike
+	// the provider environment in /run it survives reboots, so the services start
+	// with the proxy applied and are only restarted when it changes.
+	proxyEnvFilePath = "/etc/provider-canonical/proxy.env"
+
+	kubeletDefaultsPath = "/etc/default/kubelet"
+	environmentPath     = "/etc/environment"
+
+	// environmentBlockBegin and environmentBlockEnd enclose the proxy variables the
+	// provider writes to /etc/environment, so they can be replaced or removed
+	// without touching the variables of the user.
+	environmentBlockBegin = "# BEGIN provider-canonical proxy"
+	environmentBlockEnd   = "# END provider-canonical proxy"
 )
 
+// proxyEnvKeys are the environment variables the provider sets for the proxy.
+var proxyEnvKeys = []string{"HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY", "http_proxy", "https_proxy", "no_proxy"}
+
+// legacyProxyDropInContent is the drop-in of earlier releases, which pointed the
+// services at the provider environment.
+var legacyProxyDropInContent = fmt.Sprintf("[Service]\n%s=-%s", envFilePrefix, envFilePath)
+
+// systemdUnitDirs are the dirs systemd loads the unit files of services from.
+var systemdUnitDirs = []string{systemdUnitDir, "/run/systemd/system", "/usr/lib/systemd/system", "/lib/systemd/system"}
+
 // k8sSnapServices lists all snap.k8s services that need proxy drop-in configs.
 var k8sSnapServices = []string{
 	"snap.k8s.containerd.service",
@@ -32,18 +60,41 @@ var k8sSnapServices = []string{
 
 // getProxyDropInFiles generates systemd drop-in files for all k8s snap services.
 func getProxyDropInFiles() []yip.File {
-	content := fmt.Sprintf("[Service]\n%s=-%s", envFilePrefix, envFilePath)
 	files := make([]yip.File, 0, len(k8sSnapServices))
 	for _, svc := range k8sSnapServices {
 		files = append(files, yip.File{
-			Path:        filepath.Join("/etc/systemd/system", svc+".d", "http-proxy.conf"),
+			Path:        proxyDropInPath(svc),
 			Permissions: 0644,
-			Content:     content,
+			Content:     proxyDropInContent(),
 		})
 	}
 	return files
 }
 
+func proxyDropInPath(svc string) string {
+	return filepath.Join(systemdUnitDir, svc+".d", "http-proxy.conf")
+}
+
+func proxyDropInContent() string {
+	return fmt.Sprintf("[Service]\n%s=-%s", envFilePrefix, proxyEnvFilePath)
+}
+
+// proxyEnvFileContent renders the proxy environment as a systemd EnvironmentFile.
+func proxyEnvFileContent(clusterCtx *domain.ClusterContext) string {
+	env := getProxyEnvironments(clusterCtx)
+
+	var lines []string
+	for _, key := range proxyEnvKeys {
+		if value := env[key]; value != "" {
+			lines = append(lines, fmt.Sprintf("%s=%s", key, strconv.Quote(value)))
+		}
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// getProviderEnvironmentStage writes the environment the scripts load. It may hold
+// proxy credentials, so it is written as a root only file rather than through the
+// stage environment, which yip keeps world readable.
 func getProviderEnvironmentStage(clusterCtx *domain.ClusterContext) []yip.Stage {
 	stages := []yip.Stage{}
 
@@ -51,45 +102,247 @@ func getProviderEnvironmentStage(clusterCtx *domain.ClusterContext) []yip.Stage
 	if utils.IsProxyConfigured(clusterCtx.EnvConfig) {
 		maps.Copy(env, getProxyEnvironments(clusterCtx))
 	}
+	maps.Copy(env, snapSourceEnv(clusterCtx))
 
 	if len(env) > 0 {
 		stages = append(stages, yip.Stage{
-			Name:            "Set provider environment",
-			Environment:     env,
-			EnvironmentFile: envFilePath,
+			Name: "Set provider environment",
+			Files: []yip.File{
+				{
+					Path:        envFilePath,
+					Permissions: 0600,
+					Content:     shellEnvFileContent(env),
+				},
+			},
+		})
+	} else if utils.FileExists(fs.OSFS, envFilePath) {
+		stages = append(stages, yip.Stage{
+			Name:     "Remove provider environment",
+			Commands: []string{fmt.Sprintf("rm -f %s", envFilePath)},
 		})
 	}
 
 	return stages
 }
 
+// shellEnvFileContent renders env as single quoted shell assignments, sorted by key.
+func shellEnvFileContent(env map[string]string) string {
+	var lines []string
+	for _, key := range slices.Sorted(maps.Keys(env)) {
+		lines = append(lines, fmt.Sprintf("%s=%s", key, shellQuote(env[key])))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
 func getProxyStage(clusterCtx *domain.ClusterContext) []yip.Stage {
 	if !utils.IsProxyConfigured(clusterCtx.EnvConfig) {
-		return []yip.Stage{}
+		return getProxyCleanupStages()
 	}
 
 	files := []yip.File{
 		{
-			Path:        filepath.Join("/etc/default", "kubelet"),
-			Permissions: 0644,
+			Path:        kubeletDefaultsPath,
+			Permissions: 0600,
 			Content:     kubeletProxyEnv(clusterCtx),
 		},
+		{
+			Path:        proxyEnvFilePath,
+			Permissions: 0600,
+			Content:     proxyEnvFileContent(clusterCtx),
+		},
 	}
 	files = append(files, getProxyDropInFiles()...)
+	files = append(files, yip.File{
+		Path:        environmentPath,
+		Permissions: 0644,
+		Content:     environmentFileContent(publicProxyEnvironments(getProxyEnvironments(clusterCtx))),
+	})
 
-	return []yip.Stage{
+	stages := []yip.Stage{
 		{
-			Name:        "Set proxy config files and envs",
-			Environment: getProxyEnvironments(clusterCtx),
-			Files:       files,
+			Name:  "Set proxy config files and envs",
+			Files: files,
 		},
-		getProxyServiceReloadStage(),
 	}
+
+	services, daemonReload := getProxyChangedServices(files)
+	services = installedServices(services)
+	if len(services) > 0 {
+		stages = append(stages, getProxyServiceReloadStage(services, daemonReload))
+	}
+	return stages
+}
+
+// getProxyChangedServices compares the rendered proxy files with the ones on disk
+// and returns the services whose effective environment changes, and whether a
+// systemd daemon-reload is needed because a drop-in changed.
+func getProxyChangedServices(files []yip.File) ([]string, bool) {
+	changed := map[string]bool{}
+	for _, file := range files {
+		changed[file.Path] = !utils.FileContentMatches(fs.OSFS, file.Path, file.Content)
+	}
+
+	var services []string
+	daemonReload := false
+	for _, svc := range k8sSnapServices {
+		dropInChanged := changed[proxyDropInPath(svc)]
+		daemonReload = daemonReload || dropInChanged
+
+		if dropInChanged || changed[proxyEnvFilePath] ||
+			(svc == "snap.k8s.kubelet.service" && changed[kubeletDefaultsPath]) {
+			services = append(services, svc)
+		}
+	}
+	return services, daemonReload
 }
 
-func getProxyServiceReloadStage() yip.Stage {
-	commands := []string{"systemctl daemon-reload"}
+// getProxyCleanupStages removes the proxy config files and envs left behind by a
+// proxy that is no longer configured. Only files with the content the provider
+// writes are considered stale, and only the services they applied to are restarted.
+// Of /etc/environment only the block of the provider is removed.
+func getProxyCleanupStages() []yip.Stage {
+	var stale []string
+	var services []string
+
 	for _, svc := range k8sSnapServices {
+		path := proxyDropInPath(svc)
+		if utils.FileContentMatches(fs.OSFS, path, proxyDropInContent()) ||
+			utils.FileContentMatches(fs.OSFS, path, legacyProxyDropInContent) {
+			stale = append(stale, path)
+			services = append(services, svc)
+		}
+	}
+
+	if content, err := fs.OSFS.ReadFile(kubeletDefaultsPath); err == nil && isProxyEnvOnly(string(content)) {
+		stale = append(stale, kubeletDefaultsPath)
+		services = appendIfNotPresent(services, "snap.k8s.kubelet.service")
+	}
+
+	if utils.FileExists(fs.OSFS, proxyEnvFilePath) {
+		stale = append(stale, proxyEnvFilePath)
+	}
+
+	var commands []string
+	if len(stale) > 0 {
+		commands = append(commands, fmt.Sprintf("rm -f %s", strings.Join(stale, " ")))
+	}
+
+	if content, err := fs.OSFS.ReadFile(environmentPath); err == nil && hasEnvironmentBlock(string(content)) {
+		commands = append(commands, fmt.Sprintf("sed -i '/^%s$/,/^%s$/d' %s", environmentBlockBegin, environmentBlockEnd, environmentPath))
+	}
+
+	if len(commands) == 0 {
+		return []yip.Stage{}
+	}
+
+	stages := []yip.Stage{
+		{
+			Name:     "Remove stale proxy config files and envs",
+			Commands: commands,
+		},
+	}
+	if services = installedServices(services); len(services) > 0 {
+		stages = append(stages, getProxyServiceReloadStage(services, true))
+	}
+	return stages
+}
+
+// installedServices drops the services that have no unit file, such as the k8s
+// snap services on the first boot, before the snaps are installed. Restarting
+// them would fail the phase, and they start with the new config anyway.
+func installedServices(services []string) []string {
+	var installed []string
+	for _, svc := range services {
+		for _, dir := range systemdUnitDirs {
+			if utils.FileExists(fs.OSFS, filepath.Join(dir, svc)) {
+				installed = append(installed, svc)
+				break
+			}
+		}
+	}
+	return installed
+}
+
+// isProxyEnvOnly reports whether an env file only holds proxy variables, as the
+// kubelet defaults written by kubeletProxyEnv do.
+func isProxyEnvOnly(content string) bool {
+	found := false
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if !isProxyEnvLine(line) {
+			return false
+		}
+		found = true
+	}
+	return found
+}
+
+// environmentFileContent renders /etc/environment with env as the block of the
+// provider, replacing the block of an earlier run and keeping every other line.
+func environmentFileContent(env map[string]string) string {
+	content, _ := fs.OSFS.ReadFile(environmentPath)
+
+	var lines []string
+	inBlock := false
+	for _, line := range strings.Split(string(content), "\n") {
+		switch {
+		case line == environmentBlockBegin:
+			inBlock = true
+		case line == environmentBlockEnd:
+			inBlock = false
+		case !inBlock:
+			lines = append(lines, line)
+		}
+	}
+	// the block goes after the last line of the user, without blank lines in between
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	lines = append(lines, environmentBlockBegin)
+	for _, key := range proxyEnvKeys {
+		if value := env[key]; value != "" {
+			lines = append(lines, fmt.Sprintf("%s=%s", key, strconv.Quote(value)))
+		}
+	}
+	lines = append(lines, environmentBlockEnd)
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func hasEnvironmentBlock(content string) bool {
+	for _, line := range strings.Split(content, "\n") {
+		if line == environmentBlockBegin {
+			return true
+		}
+	}
+	return false
+}
+
+func isProxyEnvLine(line string) bool {
+	for _, key := range proxyEnvKeys {
+		if strings.HasPrefix(line, key+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+// getProxyServiceReloadStage restarts the services in dependency order: the
+// datastore first, then the apiserver, then everything else.
+func getProxyServiceReloadStage(services []string, daemonReload bool) yip.Stage {
+	ordered := slices.Clone(services)
+	slices.SortStableFunc(ordered, func(a, b string) int {
+		return restartPriority(a) - restartPriority(b)
+	})
+
+	var commands []string
+	if daemonReload {
+		commands = append(commands, "systemctl daemon-reload")
+	}
+	for _, svc := range ordered {
 		commands = append(commands, fmt.Sprintf("systemctl restart %s", svc))
 	}
 	return yip.Stage{
@@ -98,6 +351,17 @@ func getProxyServiceReloadStage() yip.Stage {
 	}
 }
 
+func restartPriority(svc string) int {
+	switch svc {
+	case "snap.k8s.k8s-dqlite.service", "snap.k8s.etcd.service":
+		return 0
+	case "snap.k8s.kube-apiserver.service":
+		return 1
+	default:
+		return 2
+	}
+}
+
 func getProxyEnvironments(clusterCtx *domain.ClusterContext) map[string]string {
 	proxyEnvs := clusterCtx.EnvConfig
 
@@ -111,6 +375,24 @@ func getProxyEnvironments(clusterCtx *domain.ClusterContext) map[string]string {
 	}
 }
 
+// publicProxyEnvironments is the proxy environment of /etc/environment. The file
+// is world readable, so it never gets the proxy credentials. A proxy that needs
+// them is left out rather than written without them, as the proxy would only
+// answer 407 to the login sessions using it; it still applies to the k8s services
+// through their root only environment.
+func publicProxyEnvironments(env map[string]string) map[string]string {
+	public := maps.Clone(env)
+	for _, key := range []string{"HTTP_PROXY", "HTTPS_PROXY"} {
+		if !utils.HasProxyCredentials(env[key]) {
+			continue
+		}
+		logrus.Warnf("%s has credentials, leaving it out of %s, it only applies to the k8s services", key, environmentPath)
+		delete(public, key)
+		delete(public, strings.ToLower(key))
+	}
+	return public
+}
+
 func kubeletProxyEnv(clusterCtx *domain.ClusterContext) string {
 	var proxy []string
 
@@ -0,0 +1,155 @@
+package stages
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/kairos-io/provider-canonical/pkg/domain"
+	"github.com/kairos-io/provider-canonical/pkg/fs"
+	"github.com/kairos-io/provider-canonical/pkg/utils"
+	yip "github.com/mudler/yip/pkg/schema"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	proxyCACertName = "provider-canonical-proxy-ca.crt"
+	managedHeader   = "# Managed by provider-canonical"
+)
+
+type trustStore struct {
+	dir     string
+	command string
+}
+
+// trustStores are the CA anchor dirs of the supported distributions, in detection
+// order. The last one is the fallback when none of the others exists.
+var trustStores = []trustStore{
+	{dir: "/etc/pki/trust/anchors", command: "update-ca-certificates"},            // openSUSE
+	{dir: "/etc/pki/ca-trust/source/anchors", command: "update-ca-trust extract"}, // RHEL, Fedora
+	{dir: "/usr/local/share/ca-certificates", command: "update-ca-certificates"},  // Debian, Ubuntu, Alpine
+}
+
+// proxyCAServices are the services that only pick up a change of the trusted CAs
+// on restart: snapd, and the k8s services, containerd pulling the images through
+// the proxy among them.
+var proxyCAServices = append([]string{"snapd.service"}, k8sSnapServices...)
+
+func getTrustStore() trustStore {
+	for _, store := range trustStores[:len(trustStores)-1] {
+		if utils.DirExists(fs.OSFS, store.dir) {
+			return store
+		}
+	}
+	return trustStores[len(trustStores)-1]
+}
+
+// getProxyCAStages installs the proxy CA into the system trust store and as the
+// default CA of containerd registry hosts. The trust store is only rebuilt, and the
+// services pulling through the proxy only restarted, when the installed CA changed.
+// Without a proxy CA, the one installed earlier is removed.
+func getProxyCAStages(clusterCtx *domain.ClusterContext) []yip.Stage {
+	if clusterCtx.ProxyCACert == "" {
+		return getProxyCACleanupStages()
+	}
+
+	store := getTrustStore()
+	trustPath := filepath.Join(store.dir, proxyCACertName)
+	containerdCAPath := getContainerdCAPath()
+
+	files := []yip.File{
+		{
+			Path:        trustPath,
+			Permissions: 0644,
+			Content:     clusterCtx.ProxyCACert,
+		},
+		{
+			Path:        containerdCAPath,
+			Permissions: 0644,
+			Content:     clusterCtx.ProxyCACert,
+		},
+	}
+
+	hostsPath := getContainerdHostsPath()
+	if isManagedFile(hostsPath) {
+		files = append(files, yip.File{
+			Path:        hostsPath,
+			Permissions: 0644,
+			Content:     fmt.Sprintf("%s\nca = %q\n", managedHeader, containerdCAPath),
+		})
+	} else {
+		logrus.Warnf("%s is not managed by provider-canonical, add ca = %q to it to trust the proxy CA in containerd", hostsPath, containerdCAPath)
+	}
+
+	stages := []yip.Stage{
+		{
+			Name:  "Install proxy CA certificate",
+			Files: files,
+		},
+	}
+
+	if utils.FileContentMatches(fs.OSFS, trustPath, clusterCtx.ProxyCACert) &&
+		utils.FileContentMatches(fs.OSFS, containerdCAPath, clusterCtx.ProxyCACert) {
+		return stages
+	}
+	return append(stages, getProxyCAReloadStage(store.command))
+}
+
+// getProxyCACleanupStages removes the proxy CA files the provider installed once
+// the proxy CA is no longer configured, so the node stops trusting it.
+func getProxyCACleanupStages() []yip.Stage {
+	var stale []string
+	var commands []string
+	for _, store := range trustStores {
+		path := filepath.Join(store.dir, proxyCACertName)
+		if utils.FileExists(fs.OSFS, path) {
+			stale = append(stale, path)
+			commands = appendIfNotPresent(commands, store.command)
+		}
+	}
+	if containerdCAPath := getContainerdCAPath(); utils.FileExists(fs.OSFS, containerdCAPath) {
+		stale = append(stale, containerdCAPath)
+	}
+	if hostsPath := getContainerdHostsPath(); utils.FileExists(fs.OSFS, hostsPath) && isManagedFile(hostsPath) {
+		stale = append(stale, hostsPath)
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+
+	stage := getProxyCAReloadStage(commands...)
+	stage.Name = "Remove proxy CA certificate"
+	stage.Commands = append([]string{fmt.Sprintf("rm -f %s", strings.Join(stale, " "))}, stage.Commands...)
+	return []yip.Stage{stage}
+}
+
+// getProxyCAReloadStage rebuilds the trust store and restarts the installed
+// services using it, the datastore and apiserver first.
+func getProxyCAReloadStage(storeCommands ...string) yip.Stage {
+	commands := storeCommands
+	if services := installedServices(proxyCAServices); len(services) > 0 {
+		commands = append(commands, getProxyServiceReloadStage(services, false).Commands...)
+	}
+	return yip.Stage{
+		Name:     "Update trust store and restart services after proxy CA change",
+		Commands: commands,
+	}
+}
+
+func getContainerdCAPath() string {
+	return filepath.Join(domain.ContainerdHostsDir, "_default", proxyCACertName)
+}
+
+func getContainerdHostsPath() string {
+	return filepath.Join(domain.ContainerdHostsDir, "_default", "hosts.toml")
+}
+
+// isManagedFile reports whether path is absent or was written by the provider, and
+// so can be (over)written without losing user configuration.
+func isManagedFile(path string) bool {
+	content, err := fs.OSFS.ReadFile(path)
+	if err != nil {
+		return true
+	}
+	return strings.HasPrefix(string(content), managedHeader)
+}
@@ -0,0 +1,141 @@
+package stages
+
+import (
+	"testing"
+
+	"github.com/kairos-io/provider-canonical/pkg/domain"
+	"github.com/kairos-io/provider-canonical/pkg/fs"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4/vfst"
+)
+
+func TestGetProxyCAStages(t *testing.T) {
+	g := NewWithT(t)
+
+	useTestFS := func(t *testing.T, root map[string]interface{}) {
+		testFS, cleanup, err := vfst.NewTestFS(root)
+		g.Expect(err).NotTo(HaveOccurred())
+		t.Cleanup(cleanup)
+
+		originalFS := fs.OSFS
+		fs.OSFS = testFS
+		t.Cleanup(func() { fs.OSFS = originalFS })
+	}
+
+	t.Run("returns no stages without a proxy CA", func(t *testing.T) {
+		useTestFS(t, map[string]interface{}{
+			"/etc/containerd/hosts.d/_default/hosts.toml": "skip_verify = true\n",
+		})
+
+		g.Expect(getProxyCAStages(&domain.ClusterContext{})).To(BeEmpty())
+	})
+
+	t.Run("removes the CA once it is no longer configured", func(t *testing.T) {
+		useTestFS(t, withUnits(map[string]interface{}{
+			"/usr/local/share/ca-certificates/provider-canonical-proxy-ca.crt": testCACrt,
+			"/etc/containerd/hosts.d/_default/provider-canonical-proxy-ca.crt": testCACrt,
+			"/etc/containerd/hosts.d/_default/hosts.toml":                      managedHeader + "\nca = \"/etc/containerd/hosts.d/_default/provider-canonical-proxy-ca.crt\"\n",
+		}, "snapd.service", "snap.k8s.containerd.service", "snap.k8s.kube-apiserver.service"))
+
+		stages := getProxyCAStages(&domain.ClusterContext{})
+
+		g.Expect(stages).To(HaveLen(1))
+		g.Expect(stages[0].Name).To(Equal("Remove proxy CA certificate"))
+		g.Expect(stages[0].Commands).To(Equal([]string{
+			"rm -f /usr/local/share/ca-certificates/provider-canonical-proxy-ca.crt " +
+				"/etc/containerd/hosts.d/_default/provider-canonical-proxy-ca.crt " +
+				"/etc/containerd/hosts.d/_default/hosts.toml",
+			"update-ca-certificates",
+			"systemctl restart snap.k8s.kube-apiserver.service",
+			"systemctl restart snapd.service",
+			"systemctl restart snap.k8s.containerd.service",
+		}))
+	})
+
+	t.Run("keeps a user managed containerd default hosts file on removal", func(t *testing.T) {
+		useTestFS(t, map[string]interface{}{
+			"/etc/pki/ca-trust/source/anchors/provider-canonical-proxy-ca.crt": testCACrt,
+			"/etc/containerd/hosts.d/_default/hosts.toml":                      "skip_verify = true\n",
+		})
+
+		stages := getProxyCAStages(&domain.ClusterContext{})
+
+		g.Expect(stages).To(HaveLen(1))
+		g.Expect(stages[0].Commands).To(Equal([]string{
+			"rm -f /etc/pki/ca-trust/source/anchors/provider-canonical-proxy-ca.crt",
+			"update-ca-trust extract",
+		}))
+	})
+
+	t.Run("installs the CA and restarts the affected services", func(t *testing.T) {
+		useTestFS(t, withUnits(map[string]interface{}{}, proxyCAServices...))
+
+		stages := getProxyCAStages(&domain.ClusterContext{ProxyCACert: testCACrt})
+
+		g.Expect(stages).To(HaveLen(2))
+		g.Expect(stages[0].Files).To(HaveLen(3))
+		g.Expect(stages[0].Files[0].Path).To(Equal("/usr/local/share/ca-certificates/provider-canonical-proxy-ca.crt"))
+		g.Expect(stages[0].Files[0].Content).To(Equal(testCACrt))
+		g.Expect(stages[0].Files[1].Path).To(Equal("/etc/containerd/hosts.d/_default/provider-canonical-proxy-ca.crt"))
+		g.Expect(stages[0].Files[2].Path).To(Equal("/etc/containerd/hosts.d/_default/hosts.toml"))
+		g.Expect(stages[0].Files[2].Content).To(ContainSubstring(`ca = "/etc/containerd/hosts.d/_default/provider-canonical-proxy-ca.crt"`))
+
+		g.Expect(stages[1].Commands[0]).To(Equal("update-ca-certificates"))
+		g.Expect(stages[1].Commands[1:4]).To(Equal([]string{
+			"systemctl restart snap.k8s.k8s-dqlite.service",
+			"systemctl restart snap.k8s.etcd.service",
+			"systemctl restart snap.k8s.kube-apiserver.service",
+		}))
+		g.Expect(stages[1].Commands).To(HaveLen(len(proxyCAServices) + 1))
+		g.Expect(stages[1].Commands).To(ContainElements(
+			"systemctl restart snapd.service",
+			"systemctl restart snap.k8s.containerd.service",
+			"systemctl restart snap.k8s.kubelet.service",
+		))
+	})
+
+	t.Run("only rebuilds the trust store before the services are installed", func(t *testing.T) {
+		useTestFS(t, map[string]interface{}{
+			"/usr/lib/systemd/system/snapd.service": "[Unit]\n",
+		})
+
+		stages := getProxyCAStages(&domain.ClusterContext{ProxyCACert: testCACrt})
+
+		g.Expect(stages[1].Commands).To(Equal([]string{
+			"update-ca-certificates",
+			"systemctl restart snapd.service",
+		}))
+	})
+
+	t.Run("uses the trust store of the running distribution", func(t *testing.T) {
+		useTestFS(t, map[string]interface{}{
+			"/etc/pki/ca-trust/source/anchors": &vfst.Dir{Perm: 0755},
+		})
+
+		stages := getProxyCAStages(&domain.ClusterContext{ProxyCACert: testCACrt})
+
+		g.Expect(stages[0].Files[0].Path).To(Equal("/etc/pki/ca-trust/source/anchors/provider-canonical-proxy-ca.crt"))
+		g.Expect(stages[1].Commands[0]).To(Equal("update-ca-trust extract"))
+	})
+
+	t.Run("does not restart services when the CA is unchanged", func(t *testing.T) {
+		useTestFS(t, map[string]interface{}{
+			"/usr/local/share/ca-certificates/provider-canonical-proxy-ca.crt": testCACrt,
+			"/etc/containerd/hosts.d/_default/provider-canonical-proxy-ca.crt": testCACrt,
+		})
+
+		stages := getProxyCAStages(&domain.ClusterContext{ProxyCACert: testCACrt})
+
+		g.Expect(stages).To(HaveLen(1))
+	})
+
+	t.Run("does not overwrite a user managed containerd default hosts file", func(t *testing.T) {
+		useTestFS(t, map[string]interface{}{
+			"/etc/containerd/hosts.d/_default/hosts.toml": "skip_verify = true\n",
+		})
+
+		stages := getProxyCAStages(&domain.ClusterContext{ProxyCACert: testCACrt})
+
+		g.Expect(stages[0].Files).To(HaveLen(2))
+	})
+}
@@ -263,7 +263,26 @@ func getKubeProxyArgs(updatedArgs map[string]*string) string {
 }
 
 func getKubeletArgs(updatedArgs map[string]*string) string {
-	return getArgs(updatedArgs, "kubelet")
+	return getArgs(mergeKubeletRegistrationArgs(updatedArgs), "kubelet")
+}
+
+// mergeKubeletRegistrationArgs merges the node labels and taints into the lists of
+// the current kubelet args file, which also holds those set by the snap.
+func mergeKubeletRegistrationArgs(updatedArgs map[string]*string) map[string]*string {
+	currentArgs, _ := readServiceArgsFile(fs.OSFS, "kubelet")
+	merged := maps.Clone(updatedArgs)
+
+	for arg, key := range map[string]func(string) string{nodeLabelsArg: labelKey, nodeTaintsArg: taintKey} {
+		updated, current := updatedArgs[arg], currentArgs[arg]
+		if updated == nil || current == nil {
+			continue
+		}
+		unquoted := strings.Trim(*current, `"`)
+		base := map[string]*string{arg: &unquoted}
+		mergeListArg(base, arg, strings.Split(*updated, ","), key)
+		merged[arg] = base[arg]
+	}
+	return merged
 }
 
 func getEtcdArgs(updatedArgs map[string]*string) string {
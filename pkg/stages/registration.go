@@ -0,0 +1,74 @@
+package stages
+
+import (
+	"strings"
+
+	"github.com/kairos-io/provider-canonical/pkg/domain"
+)
+
+const (
+	nodeLabelsArg = "--node-labels"
+	nodeTaintsArg = "--register-with-taints"
+)
+
+// setNodeRegistrationArgs adds the node labels and taints to the kubelet args,
+// merged into the lists already set there. The kubelet applies them when it
+// registers the node, so on a registered node a change only reaches the args file
+// and labels or taints dropped from the config are never removed from the node.
+func setNodeRegistrationArgs(args map[string]*string, clusterCtx *domain.ClusterContext, taints ...string) {
+	mergeListArg(args, nodeLabelsArg, clusterCtx.NodeLabels, labelKey)
+	mergeListArg(args, nodeTaintsArg, append(taints, clusterCtx.NodeTaints...), taintKey)
+}
+
+// setControlPlaneRegistrationArgs sets the registration args of a control plane
+// node. The snap registers the control-plane-taints of the config through the
+// same kubelet arg, which the node taints replace, so they are repeated there.
+// A joining node takes them from its own config, which has to match the one the
+// cluster was bootstrapped with.
+func setControlPlaneRegistrationArgs(args map[string]*string, clusterCtx *domain.ClusterContext, controlPlaneTaints []string) {
+	if len(clusterCtx.NodeTaints) == 0 {
+		controlPlaneTaints = nil
+	}
+	setNodeRegistrationArgs(args, clusterCtx, controlPlaneTaints...)
+}
+
+// mergeListArg merges items into the comma separated list of arg, replacing the
+// items of the same key.
+func mergeListArg(args map[string]*string, arg string, items []string, key func(string) string) {
+	if len(items) == 0 {
+		return
+	}
+
+	var merged []string
+	if current := args[arg]; current != nil && *current != "" {
+		merged = strings.Split(*current, ",")
+	}
+	for _, item := range items {
+		replaced := false
+		for i, existing := range merged {
+			if key(existing) == key(item) {
+				merged[i] = item
+				replaced = true
+			}
+		}
+		if !replaced {
+			merged = append(merged, item)
+		}
+	}
+
+	value := strings.Join(merged, ",")
+	args[arg] = &value
+}
+
+func labelKey(label string) string {
+	key, _, _ := strings.Cut(label, "=")
+	return key
+}
+
+// taintKey identifies a taint by key and effect, the same key may be set with
+// several effects.
+func taintKey(taint string) string {
+	keyValue, effect, _ := strings.Cut(taint, ":")
+	key, _, _ := strings.Cut(keyValue, "=")
+	return key + ":" + effect
+}
@@ -0,0 +1,93 @@
+package stages
+
+import (
+	"path/filepath"
+	"testing"
+
+	apiv1 "github.com/canonical/k8s-snap-api/api/v1"
+	"github.com/kairos-io/provider-canonical/pkg/domain"
+	"github.com/kairos-io/provider-canonical/pkg/fs"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4/vfst"
+	"gopkg.in/yaml.v3"
+)
+
+func TestNodeRegistrationArgs(t *testing.T) {
+	g := NewWithT(t)
+
+	clusterCtx := &domain.ClusterContext{
+		NodeLabels: []string{"env=prod", "example.com/rack=r1"},
+		NodeTaints: []string{"dedicated=gpu:NoSchedule"},
+	}
+
+	t.Run("merges into the user kubelet args", func(t *testing.T) {
+		labels := "env=dev,team=a"
+		args := map[string]*string{nodeLabelsArg: &labels}
+
+		setNodeRegistrationArgs(args, clusterCtx)
+
+		g.Expect(*args[nodeLabelsArg]).To(Equal("env=prod,team=a,example.com/rack=r1"))
+		g.Expect(*args[nodeTaintsArg]).To(Equal("dedicated=gpu:NoSchedule"))
+	})
+
+	t.Run("keeps the control plane taints of the bootstrap config", func(t *testing.T) {
+		userOptions := "control-plane-taints:\n  - node-role.kubernetes.io/control-plane:NoSchedule\n"
+		stages := GetInitStage(&domain.ClusterContext{
+			UserOptions: userOptions,
+			NodeTaints:  clusterCtx.NodeTaints,
+		})
+
+		var config apiv1.BootstrapConfig
+		g.Expect(yaml.Unmarshal([]byte(stages[0].Files[0].Content), &config)).To(Succeed())
+		g.Expect(*config.ExtraNodeKubeletArgs[nodeTaintsArg]).To(Equal("node-role.kubernetes.io/control-plane:NoSchedule,dedicated=gpu:NoSchedule"))
+	})
+
+	t.Run("keeps the control plane taints on a control plane join", func(t *testing.T) {
+		userOptions := "control-plane-taints:\n  - node-role.kubernetes.io/control-plane:NoSchedule\n"
+		stages := GetControlPlaneJoinStage(&domain.ClusterContext{
+			UserOptions: userOptions,
+			NodeTaints:  clusterCtx.NodeTaints,
+		})
+
+		var config apiv1.ControlPlaneJoinConfig
+		g.Expect(yaml.Unmarshal([]byte(stages[0].Files[0].Content), &config)).To(Succeed())
+		g.Expect(*config.ExtraNodeKubeletArgs[nodeTaintsArg]).To(Equal("node-role.kubernetes.io/control-plane:NoSchedule,dedicated=gpu:NoSchedule"))
+	})
+
+	t.Run("leaves the control plane taints to the snap without node taints", func(t *testing.T) {
+		userOptions := "control-plane-taints:\n  - node-role.kubernetes.io/control-plane:NoSchedule\n"
+		stages := GetControlPlaneJoinStage(&domain.ClusterContext{UserOptions: userOptions})
+
+		var config apiv1.ControlPlaneJoinConfig
+		g.Expect(yaml.Unmarshal([]byte(stages[0].Files[0].Content), &config)).To(Succeed())
+		g.Expect(config.ExtraNodeKubeletArgs).NotTo(HaveKey(nodeTaintsArg))
+	})
+
+	t.Run("sets the worker kubelet args", func(t *testing.T) {
+		stages := GetWorkerJoinStage(clusterCtx)
+
+		var config apiv1.WorkerJoinConfig
+		g.Expect(yaml.Unmarshal([]byte(stages[0].Files[0].Content), &config)).To(Succeed())
+		g.Expect(*config.ExtraNodeKubeletArgs[nodeLabelsArg]).To(Equal("env=prod,example.com/rack=r1"))
+		g.Expect(*config.ExtraNodeKubeletArgs[nodeTaintsArg]).To(Equal("dedicated=gpu:NoSchedule"))
+	})
+
+	t.Run("merges with the labels of the kubelet args file on day-2", func(t *testing.T) {
+		testFS, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+			filepath.Join(domain.KubeComponentsArgsPath, "kubelet"): "--node-labels=\"k8sd.io/role=worker,env=dev\"\n--register-with-taints=dedicated=gpu:NoExecute\n",
+		})
+		g.Expect(err).NotTo(HaveOccurred())
+		defer cleanup()
+
+		originalFS := fs.OSFS
+		fs.OSFS = testFS
+		defer func() { fs.OSFS = originalFS }()
+
+		args := map[string]*string{}
+		setNodeRegistrationArgs(args, clusterCtx)
+		merged := mergeKubeletRegistrationArgs(args)
+
+		g.Expect(*merged[nodeLabelsArg]).To(Equal("k8sd.io/role=worker,env=prod,example.com/rack=r1"))
+		g.Expect(*merged[nodeTaintsArg]).To(Equal("dedicated=gpu:NoExecute,dedicated=gpu:NoSchedule"))
+	})
+}
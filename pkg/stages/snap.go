@@ -0,0 +1,19 @@
+package stages
+
+import (
+	"github.com/kairos-io/provider-canonical/pkg/domain"
+)
+
+// snapSourceEnv tells the scripts to install and refresh the snaps from the store
+// rather than the airgap bundle, which stays the default.
+func snapSourceEnv(clusterCtx *domain.ClusterContext) map[string]string {
+	if clusterCtx.SnapSource != domain.SnapSourceStore {
+		return nil
+	}
+
+	env := map[string]string{"SNAP_SOURCE": domain.SnapSourceStore}
+	if clusterCtx.K8sChannel != "" {
+		env["K8S_CHANNEL"] = clusterCtx.K8sChannel
+	}
+	return env
+}
@@ -8,11 +8,11 @@ import (
 	yip "github.com/mudler/yip/pkg/schema"
 )
 
-func getUpgradeStage(clusterCtx *domain.ClusterContext) yip.Stage {
+func getUpgradeStage() yip.Stage {
 	return yip.Stage{
 		Name: "Run Canonical Upgrade",
 		Commands: []string{
-			fmt.Sprintf("bash %s %s", filepath.Join(domain.CanonicalScriptDir, "upgrade.sh"), clusterCtx.NodeRole),
+			fmt.Sprintf("bash %s", filepath.Join(domain.CanonicalScriptDir, "upgrade.sh")),
 		},
 	}
 }
@@ -0,0 +1,187 @@
+package status
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/kairos-io/provider-canonical/pkg/domain"
+	"github.com/pkg/errors"
+	"github.com/twpayne/go-vfs/v4"
+)
+
+// Version is bumped whenever the status layout changes incompatibly.
+const Version = 1
+
+// The states of a provisioning phase.
+const (
+	StateRunning   = "running"
+	StateSucceeded = "succeeded"
+	StateFailed    = "failed"
+	// StateWaiting is given to a phase waiting on another node before it starts,
+	// such as an upgrade waiting on the upgrade lock.
+	StateWaiting = "waiting"
+)
+
+// The provisioning phases of the node. The bootstrap, restore, join and upgrade
+// phases are recorded by their scripts.
+const (
+	PhasePreSetup         = "pre-setup"
+	PhaseProxy            = "proxy"
+	PhaseImageImport      = "image-import"
+	PhaseBootstrap        = "bootstrap"
+	PhaseRestore          = "restore"
+	PhaseJoin             = "join"
+	PhaseUpgrade          = "upgrade"
+	PhaseReconfigure      = "reconfigure"
+	PhaseCertRegeneration = "cert-regeneration"
+	PhaseReset            = "reset"
+)
+
+// Paths are where the status is written, the first one being read back first.
+var Paths = []string{domain.StatusPath, domain.StatusMirrorPath}
+
+// Phase is the state of one provisioning phase of the node, such as its bootstrap
+// or join, with the k8s revision and config hash it last ran with and the error it
+// failed with.
+type Phase struct {
+	State       string     `json:"state"`
+	StartedAt   *time.Time `json:"startedAt,omitempty"`
+	FinishedAt  *time.Time `json:"finishedAt,omitempty"`
+	K8sRevision string     `json:"k8sRevision,omitempty"`
+	ConfigHash  string     `json:"configHash,omitempty"`
+	Retries     int        `json:"retries,omitempty"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// Status is the provisioning status of the node.
+type Status struct {
+	Version     int              `json:"version"`
+	UpdatedAt   *time.Time       `json:"updatedAt,omitempty"`
+	K8sRevision string           `json:"k8sRevision,omitempty"`
+	ConfigHash  string           `json:"configHash,omitempty"`
+	Phases      map[string]Phase `json:"phases"`
+}
+
+// Update is a change of the state of a phase.
+type Update struct {
+	Phase string
+	State string
+	// Error is only kept for a failed phase.
+	Error string
+	// ConfigHash identifies the provider config a starting phase runs with.
+	ConfigHash string
+	// Retries are the retries of a finished phase.
+	Retries     int
+	K8sRevision string
+	Time        time.Time
+}
+
+// Load reads the first status of paths there is, an empty status when there is
+// none yet.
+func Load(root vfs.FS, paths ...string) (Status, error) {
+	status := Status{Version: Version, Phases: map[string]Phase{}}
+
+	for _, path := range paths {
+		content, err := root.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return status, errors.Wrap(err, "failed to read the status")
+		}
+		if err = json.Unmarshal(content, &status); err != nil {
+			return Status{Version: Version, Phases: map[string]Phase{}}, errors.Wrapf(err, "failed to parse %s", path)
+		}
+		break
+	}
+
+	status.Version = Version
+	if status.Phases == nil {
+		status.Phases = map[string]Phase{}
+	}
+	return status, nil
+}
+
+// Save writes the status to each of paths, replacing the previous one at once so
+// readers never see a partial status.
+func (s Status) Save(root vfs.FS, paths ...string) error {
+	content, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		if err = vfs.MkdirAll(root, filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+		if err = root.WriteFile(tmp, append(content, '\n'), 0644); err != nil {
+			return errors.Wrapf(err, "failed to write %s", path)
+		}
+		if err = root.Rename(tmp, path); err != nil {
+			return errors.Wrapf(err, "failed to write %s", path)
+		}
+	}
+	return nil
+}
+
+// Record applies update to its phase. A waiting or running phase starts over,
+// clearing the outcome of its previous run. A failed phase stays failed until it
+// starts over, as a phase of several commands only succeeds once all of them ran.
+func (s *Status) Record(update Update) error {
+	if update.Phase == "" {
+		return errors.New("phase name is empty")
+	}
+	now := update.Time.UTC()
+
+	phase := s.Phases[update.Phase]
+	switch update.State {
+	case StateRunning:
+		phase = Phase{State: update.State, StartedAt: &now, ConfigHash: update.ConfigHash}
+		if update.ConfigHash != "" {
+			s.ConfigHash = update.ConfigHash
+		}
+	case StateWaiting:
+		phase = Phase{State: update.State}
+	case StateSucceeded:
+		if phase.State == StateFailed {
+			return nil
+		}
+		phase.State = update.State
+		phase.FinishedAt = &now
+		phase.Retries = update.Retries
+		phase.Error = ""
+	case StateFailed:
+		phase.State = update.State
+		phase.FinishedAt = &now
+		phase.Retries = update.Retries
+		phase.Error = update.Error
+	default:
+		return errors.Errorf("unknown phase state %q", update.State)
+	}
+
+	if update.K8sRevision != "" {
+		phase.K8sRevision = update.K8sRevision
+		s.K8sRevision = update.K8sRevision
+	}
+
+	if s.Phases == nil {
+		s.Phases = map[string]Phase{}
+	}
+	s.Phases[update.Phase] = phase
+	s.Version = Version
+	s.UpdatedAt = &now
+	return nil
+}
+
+// InstalledK8sRevision returns the revision of the installed k8s snap, empty when
+// it is not installed.
+func InstalledK8sRevision(root vfs.FS) string {
+	revision, err := root.Readlink(domain.K8sSnapCurrentPath)
+	if err != nil {
+		return ""
+	}
+	return filepath.Base(revision)
+}
@@ -0,0 +1,136 @@
+package status
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4/vfst"
+)
+
+func TestRecord(t *testing.T) {
+	g := NewWithT(t)
+
+	started := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	finished := started.Add(time.Minute)
+
+	t.Run("records the outcome of a phase", func(t *testing.T) {
+		status := Status{}
+		g.Expect(status.Record(Update{Phase: PhaseJoin, State: StateRunning, ConfigHash: "abc", Time: started})).To(Succeed())
+		g.Expect(status.Record(Update{
+			Phase:       PhaseJoin,
+			State:       StateFailed,
+			Error:       "k8s join-cluster failed after 5 attempts",
+			K8sRevision: "2345",
+			Time:        finished,
+		})).To(Succeed())
+
+		g.Expect(status).To(Equal(Status{
+			Version:     Version,
+			UpdatedAt:   &finished,
+			K8sRevision: "2345",
+			ConfigHash:  "abc",
+			Phases: map[string]Phase{
+				PhaseJoin: {
+					State:       StateFailed,
+					StartedAt:   &started,
+					FinishedAt:  &finished,
+					K8sRevision: "2345",
+					ConfigHash:  "abc",
+					Error:       "k8s join-cluster failed after 5 attempts",
+				},
+			},
+		}))
+	})
+
+	t.Run("keeps a failed phase failed until it starts over", func(t *testing.T) {
+		status := Status{}
+		g.Expect(status.Record(Update{Phase: PhaseProxy, State: StateRunning, Time: started})).To(Succeed())
+		g.Expect(status.Record(Update{Phase: PhaseProxy, State: StateFailed, Error: "restart failed", Time: started})).To(Succeed())
+		g.Expect(status.Record(Update{Phase: PhaseProxy, State: StateSucceeded, Time: finished})).To(Succeed())
+		g.Expect(status.Phases[PhaseProxy].State).To(Equal(StateFailed))
+
+		g.Expect(status.Record(Update{Phase: PhaseProxy, State: StateRunning, Time: finished})).To(Succeed())
+		g.Expect(status.Phases[PhaseProxy]).To(Equal(Phase{State: StateRunning, StartedAt: &finished}))
+	})
+
+	t.Run("records the retries of a phase waiting on another node", func(t *testing.T) {
+		status := Status{}
+		g.Expect(status.Record(Update{Phase: PhaseUpgrade, State: StateSucceeded, Time: started})).To(Succeed())
+		g.Expect(status.Record(Update{Phase: PhaseUpgrade, State: StateWaiting, Time: started})).To(Succeed())
+		g.Expect(status.Phases[PhaseUpgrade]).To(Equal(Phase{State: StateWaiting}))
+
+		g.Expect(status.Record(Update{Phase: PhaseUpgrade, State: StateRunning, Time: started})).To(Succeed())
+		g.Expect(status.Record(Update{Phase: PhaseUpgrade, State: StateSucceeded, Retries: 2, Time: finished})).To(Succeed())
+		g.Expect(status.Phases[PhaseUpgrade].Retries).To(Equal(2))
+	})
+
+	t.Run("rejects an unknown state", func(t *testing.T) {
+		status := Status{}
+		g.Expect(status.Record(Update{Phase: PhaseJoin, State: "done", Time: started})).NotTo(Succeed())
+		g.Expect(status.Record(Update{State: StateRunning, Time: started})).NotTo(Succeed())
+	})
+}
+
+func TestLoadSave(t *testing.T) {
+	g := NewWithT(t)
+
+	testFS, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/run":                       &vfst.Dir{Perm: 0755},
+		"/opt/canonical/status.json": `{"version":1,"phases":{"bootstrap":{"state":"succeeded"}}}`,
+	})
+	g.Expect(err).NotTo(HaveOccurred())
+	defer cleanup()
+
+	t.Run("falls back to the mirror after a reboot", func(t *testing.T) {
+		status, err := Load(testFS, Paths...)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(status.Phases).To(Equal(map[string]Phase{PhaseBootstrap: {State: StateSucceeded}}))
+	})
+
+	t.Run("writes the status and its mirror", func(t *testing.T) {
+		status, err := Load(testFS, Paths...)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(status.Record(Update{Phase: PhaseUpgrade, State: StateSucceeded, Time: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)})).To(Succeed())
+		g.Expect(status.Save(testFS, Paths...)).To(Succeed())
+
+		expected := `{
+  "version": 1,
+  "updatedAt": "2026-01-02T03:04:05Z",
+  "phases": {
+    "bootstrap": {
+      "state": "succeeded"
+    },
+    "upgrade": {
+      "state": "succeeded",
+      "finishedAt": "2026-01-02T03:04:05Z"
+    }
+  }
+}
+`
+		vfst.RunTests(t, testFS, "",
+			vfst.TestPath("/run/provider-canonical/status.json", vfst.TestModePerm(0644), vfst.TestContentsString(expected)),
+			vfst.TestPath("/opt/canonical/status.json", vfst.TestModePerm(0644), vfst.TestContentsString(expected)),
+			vfst.TestPath("/run/provider-canonical/.status.json.tmp", vfst.TestDoesNotExist),
+		)
+
+		loaded, err := Load(testFS, Paths...)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(loaded).To(Equal(status))
+	})
+}
+
+func TestInstalledK8sRevision(t *testing.T) {
+	g := NewWithT(t)
+
+	testFS, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/snap/k8s/2345":    &vfst.Dir{Perm: 0755},
+		"/snap/k8s/current": &vfst.Symlink{Target: "2345"},
+	})
+	g.Expect(err).NotTo(HaveOccurred())
+	defer cleanup()
+
+	g.Expect(InstalledK8sRevision(testFS)).To(Equal("2345"))
+	g.Expect(testFS.Remove("/snap/k8s/current")).To(Succeed())
+	g.Expect(InstalledK8sRevision(testFS)).To(BeEmpty())
+}
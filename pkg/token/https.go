@@ -0,0 +1,197 @@
+package token
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	stderrors "errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/kairos-io/provider-canonical/pkg/domain"
+	"github.com/pkg/errors"
+	"github.com/twpayne/go-vfs/v4"
+)
+
+const (
+	httpsTimeout  = 30 * time.Second
+	defaultExpiry = "24h"
+)
+
+// httpsRefresher asks an HTTPS endpoint for a new token, either at the address of
+// the URL host or at each join address of the current token in turn.
+type httpsRefresher struct {
+	url                  *url.URL
+	headers              map[string]string
+	expiry               string
+	resolveJoinAddresses bool
+	tlsConfig            *tls.Config
+}
+
+type refreshRequest struct {
+	Expiry    string `json:"expiry"`
+	K8sEngine string `json:"k8sEngine"`
+	NodeName  string `json:"nodeName"`
+	NodeRole  string `json:"nodeRole"`
+}
+
+type refreshResponse struct {
+	Token   string `json:"token"`
+	Message string `json:"message"`
+	Error   string `json:"error"`
+}
+
+func newHTTPSRefresher(root vfs.FS, config domain.TokenRefreshConfig) (Refresher, error) {
+	u, err := url.Parse(config.URL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return nil, errors.Errorf("token refresh URL %q is not an https URL", config.URL)
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: config.Insecure}
+	if config.CAFile != "" {
+		ca, err := root.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read the token refresh CA")
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, errors.Errorf("token refresh CA %s holds no certificate", config.CAFile)
+		}
+	}
+
+	if config.ClientCertFile != "" || config.ClientKeyFile != "" {
+		if config.ClientCertFile == "" || config.ClientKeyFile == "" {
+			return nil, errors.New("token refresh client certificate needs both a certificate and a key")
+		}
+		cert, err := root.ReadFile(config.ClientCertFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read the token refresh client certificate")
+		}
+		key, err := root.ReadFile(config.ClientKeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read the token refresh client key")
+		}
+		pair, err := tls.X509KeyPair(cert, key)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid token refresh client certificate")
+		}
+		tlsConfig.Certificates = []tls.Certificate{pair}
+	}
+
+	expiry := config.Expiry
+	if expiry == "" {
+		expiry = defaultExpiry
+	}
+	return &httpsRefresher{
+		url:                  u,
+		headers:              config.Headers,
+		expiry:               expiry,
+		resolveJoinAddresses: config.ResolveJoinAddresses,
+		tlsConfig:            tlsConfig,
+	}, nil
+}
+
+func (r *httpsRefresher) Refresh(ctx context.Context, request Request) (string, error) {
+	targets := []string{""}
+	if r.resolveJoinAddresses {
+		info, err := Decode(request.Token)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to read the join addresses")
+		}
+		targets = targets[:0]
+		for _, address := range info.JoinAddresses {
+			if host, _, err := net.SplitHostPort(address); err == nil {
+				address = host
+			}
+			targets = append(targets, address)
+		}
+	}
+
+	var errs []error
+	for _, target := range targets {
+		token, err := r.refresh(ctx, target, request)
+		if err == nil {
+			return token, nil
+		}
+		errs = append(errs, err)
+	}
+	return "", stderrors.Join(errs...)
+}
+
+// refresh sends the request to target, or to the URL host if target is empty.
+func (r *httpsRefresher) refresh(ctx context.Context, target string, request Request) (string, error) {
+	body, err := json.Marshal(refreshRequest{
+		Expiry:    r.expiry,
+		K8sEngine: "canonical",
+		NodeName:  request.NodeName,
+		NodeRole:  request.NodeRole,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.url.String(), bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	for name, value := range r.headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := r.client(target).Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	content, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read the token refresh response")
+	}
+
+	var response refreshResponse
+	_ = json.Unmarshal(content, &response)
+	if resp.StatusCode >= http.StatusBadRequest || response.Token == "" {
+		message := response.Message
+		if message == "" {
+			message = response.Error
+		}
+		return "", errors.Errorf("token refresh at %s failed with HTTP %d: %s", r.describe(target), resp.StatusCode, message)
+	}
+	return response.Token, nil
+}
+
+// client returns a client that connects to target instead of the URL host, and
+// then bypasses the proxy as the target is a cluster node.
+func (r *httpsRefresher) client(target string) *http.Client {
+	transport := &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: r.tlsConfig,
+	}
+	if target != "" {
+		port := r.url.Port()
+		if port == "" {
+			port = "443"
+		}
+		transport.Proxy = nil
+		transport.DialContext = func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, net.JoinHostPort(target, port))
+		}
+	}
+	return &http.Client{Transport: transport, Timeout: httpsTimeout}
+}
+
+func (r *httpsRefresher) describe(target string) string {
+	if target == "" {
+		return r.url.Host
+	}
+	return r.url.Host + " via " + target
+}
@@ -0,0 +1,73 @@
+package token
+
+import (
+	"context"
+	"strings"
+
+	"github.com/kairos-io/provider-canonical/pkg/domain"
+	"github.com/pkg/errors"
+	"github.com/twpayne/go-vfs/v4"
+)
+
+// ErrDisabled is returned by the refresher of nodes without a token refresh source.
+var ErrDisabled = errors.New("join token refresh is disabled")
+
+// Request describes the node a new join token is for.
+type Request struct {
+	// Token is the join token the cluster no longer accepts.
+	Token    string
+	NodeName string
+	NodeRole string
+}
+
+// Refresher gets a new join token when the cluster no longer knows the one the
+// node was given.
+type Refresher interface {
+	Refresh(ctx context.Context, request Request) (string, error)
+}
+
+// NewRefresher returns the refresher of the configured source.
+func NewRefresher(root vfs.FS, config domain.TokenRefreshConfig) (Refresher, error) {
+	switch config.Source {
+	case "", domain.TokenRefreshDisabled:
+		return disabledRefresher{}, nil
+	case domain.TokenRefreshFile:
+		if config.File == "" {
+			return nil, errors.New("token refresh source file needs a file")
+		}
+		return fileRefresher{root: root, path: config.File}, nil
+	case domain.TokenRefreshHTTPS:
+		return newHTTPSRefresher(root, config)
+	default:
+		return nil, errors.Errorf("unknown token refresh source %q", config.Source)
+	}
+}
+
+type disabledRefresher struct{}
+
+func (disabledRefresher) Refresh(context.Context, Request) (string, error) {
+	return "", ErrDisabled
+}
+
+// fileRefresher reads the new token from a file on disk, where another agent on
+// the node drops it.
+type fileRefresher struct {
+	root vfs.FS
+	path string
+}
+
+func (r fileRefresher) Refresh(_ context.Context, request Request) (string, error) {
+	content, err := r.root.ReadFile(r.path)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read the join token file")
+	}
+
+	token := strings.TrimSpace(string(content))
+	switch token {
+	case "":
+		return "", errors.Errorf("join token file %s is empty", r.path)
+	case strings.TrimSpace(request.Token):
+		return "", errors.Errorf("join token file %s has no new token", r.path)
+	}
+	return token, nil
+}
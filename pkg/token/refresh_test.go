@@ -0,0 +1,183 @@
+package token
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/kairos-io/provider-canonical/pkg/domain"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4/vfst"
+)
+
+func TestDisabledAndFileRefreshers(t *testing.T) {
+	g := NewWithT(t)
+
+	testFS, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/var/lib/agent/join-token": "new-token\n",
+	})
+	g.Expect(err).NotTo(HaveOccurred())
+	defer cleanup()
+
+	t.Run("refuses to refresh when disabled", func(t *testing.T) {
+		refresher, err := NewRefresher(testFS, domain.TokenRefreshConfig{Source: domain.TokenRefreshDisabled})
+		g.Expect(err).NotTo(HaveOccurred())
+
+		_, err = refresher.Refresh(context.Background(), Request{Token: "old-token"})
+		g.Expect(err).To(MatchError(ErrDisabled))
+	})
+
+	t.Run("reads a new token from the file", func(t *testing.T) {
+		refresher, err := NewRefresher(testFS, domain.TokenRefreshConfig{Source: domain.TokenRefreshFile, File: "/var/lib/agent/join-token"})
+		g.Expect(err).NotTo(HaveOccurred())
+
+		token, err := refresher.Refresh(context.Background(), Request{Token: "old-token"})
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(token).To(Equal("new-token"))
+
+		_, err = refresher.Refresh(context.Background(), Request{Token: "new-token"})
+		g.Expect(err).To(MatchError("join token file /var/lib/agent/join-token has no new token"))
+	})
+
+	t.Run("rejects unknown sources", func(t *testing.T) {
+		_, err := NewRefresher(testFS, domain.TokenRefreshConfig{Source: "carrier-pigeon"})
+		g.Expect(err).To(HaveOccurred())
+	})
+}
+
+func TestHTTPSRefresher(t *testing.T) {
+	g := NewWithT(t)
+
+	clientCert, clientKey := generateClientCert(g)
+
+	var received refreshRequest
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Publisher-Host-Id") != "edge-1" {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"message":"unknown edge host"}`))
+			return
+		}
+		g.Expect(json.NewDecoder(r.Body).Decode(&received)).To(Succeed())
+		_, _ = w.Write([]byte(`{"token":"new-token"}`))
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	serverURL, err := url.Parse(server.URL)
+	g.Expect(err).NotTo(HaveOccurred())
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+
+	testFS, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+		"/etc/refresh/ca.crt":     string(ca),
+		"/etc/refresh/client.crt": string(clientCert),
+		"/etc/refresh/client.key": string(clientKey),
+	})
+	g.Expect(err).NotTo(HaveOccurred())
+	defer cleanup()
+
+	config := domain.TokenRefreshConfig{
+		Source:         domain.TokenRefreshHTTPS,
+		URL:            server.URL + "/v1/cluster-token",
+		CAFile:         "/etc/refresh/ca.crt",
+		ClientCertFile: "/etc/refresh/client.crt",
+		ClientKeyFile:  "/etc/refresh/client.key",
+		Headers:        map[string]string{"Publisher-Host-Id": "edge-1"},
+	}
+	request := Request{Token: "old-token", NodeName: "node-1", NodeRole: "worker"}
+
+	t.Run("requests a new token", func(t *testing.T) {
+		refresher, err := NewRefresher(testFS, config)
+		g.Expect(err).NotTo(HaveOccurred())
+
+		token, err := refresher.Refresh(context.Background(), request)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(token).To(Equal("new-token"))
+		g.Expect(received).To(Equal(refreshRequest{Expiry: "24h", K8sEngine: "canonical", NodeName: "node-1", NodeRole: "worker"}))
+	})
+
+	t.Run("sends the request to the join addresses", func(t *testing.T) {
+		resolving := config
+		resolving.URL = "https://example.com:" + serverURL.Port() + "/v1/cluster-token"
+		resolving.ResolveJoinAddresses = true
+		refresher, err := NewRefresher(testFS, resolving)
+		g.Expect(err).NotTo(HaveOccurred())
+
+		joinToken := base64.StdEncoding.EncodeToString([]byte(`{"token":"s3cr3t","join_addresses":["[::1]:6400","127.0.0.1:6400"]}`))
+		token, err := refresher.Refresh(context.Background(), Request{Token: joinToken, NodeName: "node-1", NodeRole: "worker"})
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(token).To(Equal("new-token"))
+	})
+
+	t.Run("returns the error of the endpoint", func(t *testing.T) {
+		withoutHeaders := config
+		withoutHeaders.Headers = nil
+		refresher, err := NewRefresher(testFS, withoutHeaders)
+		g.Expect(err).NotTo(HaveOccurred())
+
+		_, err = refresher.Refresh(context.Background(), request)
+		g.Expect(err).To(MatchError(ContainSubstring("failed with HTTP 403: unknown edge host")))
+	})
+
+	t.Run("only skips the certificate verification when insecure", func(t *testing.T) {
+		untrusted := config
+		untrusted.CAFile = ""
+		refresher, err := NewRefresher(testFS, untrusted)
+		g.Expect(err).NotTo(HaveOccurred())
+
+		_, err = refresher.Refresh(context.Background(), request)
+		g.Expect(err).To(MatchError(ContainSubstring("certificate")))
+
+		untrusted.Insecure = true
+		refresher, err = NewRefresher(testFS, untrusted)
+		g.Expect(err).NotTo(HaveOccurred())
+
+		token, err := refresher.Refresh(context.Background(), request)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(token).To(Equal("new-token"))
+	})
+
+	t.Run("rejects invalid configs", func(t *testing.T) {
+		for _, invalid := range []domain.TokenRefreshConfig{
+			{Source: domain.TokenRefreshHTTPS, URL: "http://example.com/token"},
+			{Source: domain.TokenRefreshHTTPS, URL: server.URL, CAFile: "/etc/refresh/missing.crt"},
+			{Source: domain.TokenRefreshHTTPS, URL: server.URL, ClientCertFile: "/etc/refresh/client.crt"},
+		} {
+			_, err := NewRefresher(testFS, invalid)
+			g.Expect(err).To(HaveOccurred())
+		}
+	})
+}
+
+func generateClientCert(g *WithT) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "node-1"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	g.Expect(err).NotTo(HaveOccurred())
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
@@ -0,0 +1,44 @@
+package token
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Info is what a join token tells about the cluster it joins: the addresses of its
+// nodes, the SHA-256 fingerprint of their k8sd certificate, and when the token
+// expires if it says so.
+type Info struct {
+	JoinAddresses []string   `json:"join_addresses"`
+	Fingerprint   string     `json:"fingerprint,omitempty"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+}
+
+// Decode reads the base64 encoded JSON of a control plane or worker join token.
+func Decode(token string) (Info, error) {
+	token = strings.TrimSpace(token)
+
+	var content []byte
+	var err error
+	for _, encoding := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if content, err = encoding.DecodeString(token); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return Info{}, errors.New("join token is not base64 encoded")
+	}
+
+	var info Info
+	if err = json.Unmarshal(content, &info); err != nil {
+		return Info{}, errors.Wrap(err, "join token is not JSON")
+	}
+	if len(info.JoinAddresses) == 0 {
+		return Info{}, errors.New("join token has no join addresses")
+	}
+	return info, nil
+}
@@ -0,0 +1,38 @@
+package token
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestDecode(t *testing.T) {
+	g := NewWithT(t)
+
+	t.Run("reads a worker token", func(t *testing.T) {
+		token := base64.StdEncoding.EncodeToString([]byte(`{"token":"s3cr3t","join_addresses":["10.0.0.1:6400","[fd00::1]:6400"]}`))
+
+		info, err := Decode(token + "\n")
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(info.JoinAddresses).To(Equal([]string{"10.0.0.1:6400", "[fd00::1]:6400"}))
+		g.Expect(info.ExpiresAt).To(BeNil())
+	})
+
+	t.Run("reads the expiry of a control plane token", func(t *testing.T) {
+		token := base64.RawURLEncoding.EncodeToString([]byte(`{"secret":"s3cr3t","join_addresses":["10.0.0.1:6400"],"expires_at":"2026-10-20T12:00:00Z"}`))
+
+		info, err := Decode(token)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(*info.ExpiresAt).To(Equal(time.Date(2026, 10, 20, 12, 0, 0, 0, time.UTC)))
+	})
+
+	t.Run("rejects tokens it cannot read", func(t *testing.T) {
+		_, err := Decode("not a token")
+		g.Expect(err).To(MatchError("join token is not base64 encoded"))
+
+		_, err = Decode(base64.StdEncoding.EncodeToString([]byte(`{"token":"s3cr3t"}`)))
+		g.Expect(err).To(MatchError("join token has no join addresses"))
+	})
+}
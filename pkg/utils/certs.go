@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"math/big"
 	"net"
+	"strings"
 	"time"
 
 	"github.com/kairos-io/provider-canonical/pkg/fs"
@@ -35,6 +36,35 @@ func GetExistingIpAndDnsSans(certPath string) ([]string, []net.IP, error) {
 	return cert.DNSNames, cert.IPAddresses, nil
 }
 
+// ValidateCertificateBundle checks that bundle holds at least one PEM certificate
+// and nothing else.
+func ValidateCertificateBundle(bundle string) error {
+	rest := []byte(bundle)
+	count := 0
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			return errors.Errorf("unexpected PEM block %q in certificate bundle", block.Type)
+		}
+		if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+			return errors.Wrap(err, "failed to parse certificate")
+		}
+		count++
+	}
+
+	if count == 0 {
+		return errors.New("no PEM certificate found")
+	}
+	if len(strings.TrimSpace(string(rest))) > 0 {
+		return errors.New("trailing data after PEM certificates")
+	}
+	return nil
+}
+
 func GetAllSans(certPath string) ([]string, error) {
 	var sans []string
 
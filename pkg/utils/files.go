@@ -11,3 +11,9 @@ func DirExists(fs vfs.FS, path string) bool {
 	info, err := fs.Stat(path)
 	return err == nil && info.IsDir()
 }
+
+// FileContentMatches reports whether the file at path exists with exactly content.
+func FileContentMatches(fs vfs.FS, path, content string) bool {
+	current, err := fs.ReadFile(path)
+	return err == nil && string(current) == content
+}
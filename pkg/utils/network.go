@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"net"
+	"net/netip"
+
+	"github.com/pkg/errors"
+)
+
+// HostAddrs returns the addresses of an interface, or of all interfaces when no
+// interface is named.
+func HostAddrs(iface string) ([]netip.Addr, error) {
+	var addrs []net.Addr
+	if iface == "" {
+		all, err := net.InterfaceAddrs()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to list interface addresses")
+		}
+		addrs = all
+	} else {
+		netIface, err := net.InterfaceByName(iface)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to find interface %s", iface)
+		}
+		if addrs, err = netIface.Addrs(); err != nil {
+			return nil, errors.Wrapf(err, "failed to list addresses of interface %s", iface)
+		}
+	}
+
+	var result []netip.Addr
+	for _, addr := range addrs {
+		if prefix, err := netip.ParsePrefix(addr.String()); err == nil {
+			result = append(result, prefix.Addr())
+		} else if ip, err := netip.ParseAddr(addr.String()); err == nil {
+			result = append(result, ip)
+		}
+	}
+	return result, nil
+}
+
+// DefaultAddress returns the source address of the default route, IPv4 first. The
+// UDP sockets are only connected, nothing is sent.
+func DefaultAddress() (netip.Addr, error) {
+	var errs []error
+	for _, target := range []string{"192.0.2.1:9", "[2001:db8::1]:9"} {
+		conn, err := net.Dial("udp", target)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		addr := conn.LocalAddr().(*net.UDPAddr).AddrPort().Addr().Unmap()
+		_ = conn.Close()
+		return addr, nil
+	}
+	return netip.Addr{}, errors.Errorf("no default route: %v", errs)
+}
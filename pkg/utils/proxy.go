@@ -1,58 +1,84 @@
 package utils
 
 import (
-	"net"
+	"net/netip"
+	"net/url"
+	"strings"
 
 	"github.com/kairos-io/provider-canonical/pkg/domain"
 )
 
+const ipv6Loopback = "::1"
+
 func IsProxyConfigured(proxyMap map[string]string) bool {
 	return len(proxyMap["HTTP_PROXY"]) > 0 || len(proxyMap["HTTPS_PROXY"]) > 0
 }
 
+// HasProxyCredentials reports whether a proxy URL has user info.
+func HasProxyCredentials(proxy string) bool {
+	u, err := url.Parse(proxy)
+	return err == nil && u.User != nil
+}
+
+// GetDefaultNoProxy returns the pod and service CIDRs, the kubernetes service IP of
+// each service CIDR and the cluster local names. Both CIDRs may be comma separated
+// dual-stack pairs.
 func GetDefaultNoProxy(clusterCtx *domain.ClusterContext) string {
-	var noProxy string
+	var noProxy []string
+	ipv6 := false
 
-	clusterCidr := clusterCtx.ClusterCidr
-	serviceCidr := clusterCtx.ServiceCidr
+	for _, cidr := range SplitCidrs(clusterCtx.ClusterCidr) {
+		noProxy = append(noProxy, cidr)
+		ipv6 = ipv6 || isIPv6Cidr(cidr)
+	}
 
-	if len(clusterCidr) > 0 {
-		noProxy = clusterCidr
+	for _, cidr := range SplitCidrs(clusterCtx.ServiceCidr) {
+		noProxy = append(noProxy, cidr)
+		if ip := getFirstIpServiceCidr(cidr); ip != "" {
+			noProxy = append(noProxy, ip)
+		}
+		ipv6 = ipv6 || isIPv6Cidr(cidr)
 	}
 
-	if len(serviceCidr) > 0 {
-		noProxy = noProxy + "," + serviceCidr + "," + getFirstIpServiceCidr(serviceCidr)
+	noProxy = append(noProxy, domain.K8sNoProxy)
+	if ipv6 {
+		noProxy = append(noProxy, ipv6Loopback)
 	}
-	return noProxy + "," + domain.K8sNoProxy
+	return strings.Join(noProxy, ",")
 }
 
-func getFirstIpServiceCidr(serviceCidr string) string {
-	return listIPs(serviceCidr)[0]
+// SplitCidrs splits a comma separated CIDR list, dropping empty entries.
+func SplitCidrs(cidrs string) []string {
+	var result []string
+	for _, cidr := range strings.Split(cidrs, ",") {
+		if cidr = strings.TrimSpace(cidr); cidr != "" {
+			result = append(result, cidr)
+		}
+	}
+	return result
 }
 
-func listIPs(cidr string) []string {
-	ip, ipnet, _ := net.ParseCIDR(cidr)
-
-	var ips []string
-	for ip := ip.Mask(ipnet.Mask); ipnet.Contains(ip); incIP(ip) {
-		ips = append(ips, ip.String())
+// getFirstIpServiceCidr returns the first usable address of the CIDR, which the
+// kubernetes service is allocated. It is computed from the prefix rather than by
+// enumerating the range, so it takes the same time for any CIDR size or family.
+func getFirstIpServiceCidr(serviceCidr string) string {
+	prefix, err := netip.ParsePrefix(serviceCidr)
+	if err != nil {
+		return ""
 	}
+	network := prefix.Masked().Addr()
 
-	// Remove network and broadcast addresses if applicable
-	if len(ips) > 2 {
-		return ips[1 : len(ips)-1]
+	// ranges of one or two addresses (/32, /31, /128, /127) have no network
+	// address to skip
+	if network.BitLen()-prefix.Bits() < 2 {
+		return network.String()
 	}
-
-	return ips
+	return network.Next().String()
 }
 
-func incIP(ip net.IP) {
-	for j := len(ip) - 1; j >= 0; j-- {
-		ip[j]++
-		if ip[j] != 0 {
-			break
-		}
-	}
+func isIPv6Cidr(cidr string) bool {
+	prefix, err := netip.ParsePrefix(cidr)
+	return err == nil && prefix.Addr().Is6()
 }
 
 func GetNoProxyConfig(clusterCtx *domain.ClusterContext) string {
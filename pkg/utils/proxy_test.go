@@ -3,6 +3,7 @@ package utils
 import (
 	"testing"
 
+	"github.com/kairos-io/provider-canonical/pkg/domain"
 	. "github.com/onsi/gomega"
 )
 
@@ -13,4 +14,86 @@ func TestGetFirstIpServiceCidr(t *testing.T) {
 		ip := getFirstIpServiceCidr("192.169.0.0/16")
 		g.Expect(ip).To(Equal("192.169.0.1"))
 	})
+
+	t.Run("masks host bits of the cidr", func(t *testing.T) {
+		g.Expect(getFirstIpServiceCidr("10.96.12.34/12")).To(Equal("10.96.0.1"))
+	})
+
+	t.Run("get second ip in an ipv6 cidr", func(t *testing.T) {
+		g.Expect(getFirstIpServiceCidr("fd98::/108")).To(Equal("fd98::1"))
+		g.Expect(getFirstIpServiceCidr("fd00:10:96::/64")).To(Equal("fd00:10:96::1"))
+	})
+
+	t.Run("get the only usable ip of tiny cidrs", func(t *testing.T) {
+		g.Expect(getFirstIpServiceCidr("10.0.0.5/32")).To(Equal("10.0.0.5"))
+		g.Expect(getFirstIpServiceCidr("10.0.0.4/31")).To(Equal("10.0.0.4"))
+		g.Expect(getFirstIpServiceCidr("fd00::1/128")).To(Equal("fd00::1"))
+	})
+
+	t.Run("returns empty for an invalid cidr", func(t *testing.T) {
+		g.Expect(getFirstIpServiceCidr("not-a-cidr")).To(BeEmpty())
+	})
+
+	t.Run("allocates the same for any cidr size", func(t *testing.T) {
+		small := testing.AllocsPerRun(100, func() { getFirstIpServiceCidr("10.96.0.0/30") })
+		large := testing.AllocsPerRun(100, func() { getFirstIpServiceCidr("10.0.0.0/8") })
+		huge := testing.AllocsPerRun(100, func() { getFirstIpServiceCidr("fd00::/8") })
+		g.Expect(large).To(Equal(small))
+		g.Expect(huge).To(Equal(small))
+	})
+}
+
+func TestGetDefaultNoProxy(t *testing.T) {
+	g := NewWithT(t)
+
+	t.Run("ipv4 single stack", func(t *testing.T) {
+		noProxy := GetDefaultNoProxy(&domain.ClusterContext{
+			ClusterCidr: "10.244.0.0/16",
+			ServiceCidr: "10.96.0.0/12",
+		})
+		g.Expect(noProxy).To(Equal("10.244.0.0/16,10.96.0.0/12,10.96.0.1," + domain.K8sNoProxy))
+	})
+
+	t.Run("ipv6 single stack", func(t *testing.T) {
+		noProxy := GetDefaultNoProxy(&domain.ClusterContext{
+			ClusterCidr: "fd00:10:244::/56",
+			ServiceCidr: "fd98::/108",
+		})
+		g.Expect(noProxy).To(Equal("fd00:10:244::/56,fd98::/108,fd98::1," + domain.K8sNoProxy + ",::1"))
+	})
+
+	t.Run("dual stack", func(t *testing.T) {
+		noProxy := GetDefaultNoProxy(&domain.ClusterContext{
+			ClusterCidr: "10.244.0.0/16, fd00:10:244::/56",
+			ServiceCidr: "10.96.0.0/12,fd98::/108",
+		})
+		g.Expect(noProxy).To(Equal("10.244.0.0/16,fd00:10:244::/56,10.96.0.0/12,10.96.0.1,fd98::/108,fd98::1," +
+			domain.K8sNoProxy + ",::1"))
+	})
+
+	t.Run("without cidrs", func(t *testing.T) {
+		g.Expect(GetDefaultNoProxy(&domain.ClusterContext{})).To(Equal(domain.K8sNoProxy))
+	})
+}
+
+func BenchmarkGetFirstIpServiceCidr(b *testing.B) {
+	for _, cidr := range []string{"10.96.0.0/24", "10.96.0.0/12", "10.0.0.0/8", "fd98::/108", "fd00::/64", "fd00::/8"} {
+		b.Run(cidr, func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				getFirstIpServiceCidr(cidr)
+			}
+		})
+	}
+}
+
+func BenchmarkGetDefaultNoProxy(b *testing.B) {
+	clusterCtx := &domain.ClusterContext{
+		ClusterCidr: "10.0.0.0/8,fd00:10:244::/56",
+		ServiceCidr: "10.96.0.0/12,fd98::/108",
+	}
+	b.ReportAllocs()
+	for b.Loop() {
+		GetDefaultNoProxy(clusterCtx)
+	}
 }
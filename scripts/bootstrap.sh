@@ -1,27 +1,28 @@
 #!/bin/bash
 
 source "$(dirname "$0")/common.sh"
-setup_logging /var/log/canonical-bootstrap.log
-set -xu
 
 load_provider_environment
-
-advertise_address=$1
+load_provider_params
+setup_logging bootstrap /var/log/canonical-bootstrap.log
+set -xu
 
 log "starting canonical k8s bootstrap"
+start_phase bootstrap
 
 install_all_snaps
 
-bootstrap_cmd='k8s bootstrap --file /opt/canonical/bootstrap-config.yaml'
-if [ -n "$advertise_address" ]; then
-	bootstrap_cmd="$bootstrap_cmd --address $advertise_address"
+bootstrap_args=(--file /opt/canonical/bootstrap-config.yaml)
+if [ -n "$ADVERTISE_ADDRESS" ]; then
+	bootstrap_args+=(--address "$ADVERTISE_ADDRESS")
 fi
 
-log "bootstrapping k8s cluster with command: $bootstrap_cmd"
+log "bootstrapping k8s cluster with command: k8s bootstrap ${bootstrap_args[*]}"
 
-with_retry "k8s bootstrap" eval "$bootstrap_cmd"
+with_retry "k8s bootstrap" k8s bootstrap "${bootstrap_args[@]}"
 
 wait_for_k8s_ready
 hold_k8s_snap_refresh
 
 touch /opt/canonical/canonical.bootstrap
+finish_phase
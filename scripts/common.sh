@@ -3,12 +3,21 @@
 # Common functions shared across bootstrap, join, and upgrade scripts.
 #
 
+PROVIDER_BIN=/usr/local/system/providers/agent-provider-canonical
+
 # -------- Logging --------
+# setup_logging <phase> <log file> sends the output and the xtrace of the script
+# line by line to the provider log, tagged with the phase, still echoing the
+# output. The xtrace is logged at debug level, so it is dropped at the default
+# info level. The per-script log file of older releases, which keeps the xtrace
+# regardless, is only appended to as well when SCRIPT_LOG_FILES is true.
 setup_logging() {
-  local log_file="$1"
-  exec   > >(tee -ia "$log_file")
-  exec  2> >(tee -ia "$log_file" >&2)
-  exec 19>> "$log_file"
+  local phase="$1" log_file="$2"
+  local args=(log-output --phase "$phase")
+  [ "${SCRIPT_LOG_FILES:-false}" = "true" ] && args+=(--file "$log_file")
+  exec   > >("$PROVIDER_BIN" "${args[@]}" --stream stdout --echo)
+  exec  2> >("$PROVIDER_BIN" "${args[@]}" --stream stderr --echo >&2)
+  exec 19> >("$PROVIDER_BIN" "${args[@]}" --stream trace)
   export BASH_XTRACEFD="19"
 }
 
@@ -16,13 +25,121 @@ log() {
   echo "[$(date '+%Y-%m-%d %H:%M:%S')] $*"
 }
 
+# -------- Phase helpers --------
+# A phase is a step of the provisioning such as the bootstrap or the join. Its
+# state is recorded in /run/provider-canonical/status.json, mirrored to
+# /opt/canonical/status.json, and the retries within it are bounded by its
+# deadline.
+PHASE=""
+PHASE_STARTED=$(date +%s)
+PHASE_RETRIES=0
+
+start_phase() {
+  PHASE="$1"
+  export PROVIDER_CANONICAL_PHASE="$PHASE"
+  PHASE_STARTED=$(date +%s)
+  PHASE_RETRIES=0
+  record_phase running ${CONFIG_HASH:+--config-hash "$CONFIG_HASH"}
+}
+
+# Record that the phase waits on another node before it starts.
+wait_phase() {
+  PHASE="$1"
+  export PROVIDER_CANONICAL_PHASE="$PHASE"
+  record_phase waiting
+}
+
+finish_phase() {
+  record_phase succeeded --retries "$PHASE_RETRIES"
+}
+
+# Record why the phase failed and end the script: its stage fails and the rest of
+# the boot goes on.
+fail_phase() {
+  local message="$1"
+  log "${PHASE:-provisioning} failed: ${message}"
+  record_phase failed --retries "$PHASE_RETRIES" --error "$message"
+  exit 1
+}
+
+record_phase() {
+  local state="$1"; shift
+  [ -n "$PHASE" ] || return 0
+  "$PROVIDER_BIN" record-phase --phase "$PHASE" --state "$state" \
+    ${METRICS_DIR:+--metrics-dir "$METRICS_DIR"} "$@" \
+    || log "failed to record the ${PHASE} phase as ${state}"
+}
+
+# Print the seconds left before the deadline of the phase, 0 when it has none and
+# at least 1 otherwise, for the waits of the provider subcommands.
+phase_remaining() {
+  local deadline="${RETRY_PHASE_DEADLINE:-0}"
+  if [ "$deadline" -le 0 ]; then
+    echo 0
+    return
+  fi
+  local remaining=$(( PHASE_STARTED + deadline - $(date +%s) ))
+  [ "$remaining" -lt 1 ] && remaining=1
+  echo "$remaining"
+}
+
+phase_deadline_passed() {
+  local deadline="${RETRY_PHASE_DEADLINE:-0}"
+  [ "$deadline" -gt 0 ] && [ "$(date +%s)" -ge $(( PHASE_STARTED + deadline )) ]
+}
+
 # -------- Retry helpers --------
+# The retry policy comes from the provider parameters: RETRY_MAX_ATTEMPTS bounds
+# the attempts at each step, 0 for no bound, and the delay between them doubles
+# from RETRY_INITIAL_DELAY up to RETRY_MAX_DELAY seconds, with jitter.
+
+# Print the delay in seconds before the attempt after the given one: half of the
+# backoff plus a random share of the other half, so nodes retrying together
+# spread out.
+retry_delay() {
+  local attempt="$1"
+  local delay="${RETRY_INITIAL_DELAY:-${RETRY_DELAY:-10}}"
+  local max="${RETRY_MAX_DELAY:-300}"
+  local i
+  for (( i = 1; i < attempt && delay < max; i++ )); do
+    delay=$(( delay * 2 ))
+  done
+  [ "$delay" -gt "$max" ] && delay=$max
+  delay=$(( delay / 2 + RANDOM % (delay - delay / 2 + 1) ))
+  [ "$delay" -lt 1 ] && delay=1
+  echo "$delay"
+}
+
+# retry_or_fail <desc> <attempt> [last error] fails the phase once the attempts at
+# the step or the phase deadline are exhausted, and otherwise waits before the
+# next attempt.
+retry_or_fail() {
+  local desc="$1" attempt="$2" last_error="${3:-}"
+  local max_attempts="${RETRY_MAX_ATTEMPTS:-0}"
+
+  if [ "$max_attempts" -gt 0 ] && [ "$attempt" -ge "$max_attempts" ]; then
+    fail_phase "${desc} failed after ${attempt} attempts${last_error:+: ${last_error}}"
+  fi
+  if phase_deadline_passed; then
+    fail_phase "${desc} did not succeed within ${RETRY_PHASE_DEADLINE}s${last_error:+: ${last_error}}"
+  fi
+
+  local delay
+  delay=$(retry_delay "$attempt")
+  local remaining
+  remaining=$(phase_remaining)
+  [ "$remaining" -gt 0 ] && [ "$delay" -gt "$remaining" ] && delay=$remaining
+  log "${desc} failed (attempt ${attempt}); retrying in ${delay}s..."
+  PHASE_RETRIES=$(( PHASE_RETRIES + 1 ))
+  sleep "$delay"
+}
+
 with_retry() {
   local desc="$1"; shift
-  local delay="${RETRY_DELAY:-10}"
+  local attempt=1
   until "$@"; do
-    log "${desc} failed; retrying in ${delay}s..."
-    sleep "$delay"
+    retry_or_fail "$desc" "$attempt" "exit code $?"
+    attempt=$(( attempt + 1 ))
   done
   log "${desc} succeeded"
 }
@@ -51,9 +168,10 @@ snap_is_busy() {
 }
 
 wait_for_snap_idle() {
-  until ! snap_is_busy; do
-    log "snapd has a change in progress; waiting 10s..."
-    sleep 10
+  local attempt=1
+  while snap_is_busy; do
+    retry_or_fail "waiting for snapd to finish its changes" "$attempt"
+    attempt=$(( attempt + 1 ))
   done
 }
 
@@ -111,9 +229,64 @@ install_k8s() {
   sudo snap install "$snap_file" --classic
 }
 
-# Install all three snaps with retry
+# Verify the snaps, their assertions and architecture once and wait for a complete
+# bundle, reporting what is missing or corrupt in the provider log and
+# /run/provider-canonical/bundle.json instead of retrying the installs blindly.
+wait_for_snap_bundle() {
+  log "verifying the snap bundle in /opt/canonical-k8s"
+  "$PROVIDER_BIN" verify-bundle --wait --interval "${RETRY_INITIAL_DELAY:-${RETRY_DELAY:-10}}s" \
+    --timeout "$(phase_remaining)s" \
+    || fail_phase "the snap bundle is incomplete or corrupt, see /run/provider-canonical/bundle.json"
+}
+
+# -------- Store helpers --------
+# Point snapd at the proxy of the provider environment, with xtrace off as the
+# proxy URLs may hold credentials.
+configure_snap_proxy() {
+  local xtrace=""
+  [[ $- == *x* ]] && xtrace=1
+  { set +x; } 2>/dev/null
+
+  local settings=()
+  local http_proxy_url="${HTTP_PROXY:-${http_proxy:-}}"
+  local https_proxy_url="${HTTPS_PROXY:-${https_proxy:-}}"
+  [ -n "$http_proxy_url" ] && settings+=("proxy.http=$http_proxy_url")
+  [ -n "$https_proxy_url" ] && settings+=("proxy.https=$https_proxy_url")
+  if [ ${#settings[@]} -gt 0 ]; then
+    log "configuring the snapd proxy"
+    sudo snap set system "${settings[@]}"
+  fi
+
+  [ -n "$xtrace" ] && set -x
+  return 0
+}
+
+# Install k8s from the store, or refresh it to the configured channel. A named
+# refresh goes through the refresh hold.
+install_k8s_from_store() {
+  wait_for_snap_idle
+  if snap list k8s >/dev/null 2>&1; then
+    sudo snap refresh k8s ${K8S_CHANNEL:+--channel "$K8S_CHANNEL"}
+  else
+    sudo snap install k8s --classic ${K8S_CHANNEL:+--channel "$K8S_CHANNEL"}
+  fi
+}
+
+snap_source_is_store() {
+  [ "${SNAP_SOURCE:-airgap}" = "store" ]
+}
+
+# Install all three snaps with retry, or k8s from the store in store mode
 install_all_snaps() {
   snap wait system seed.loaded
+
+  if snap_source_is_store; then
+    configure_snap_proxy
+    with_retry "k8s install from the store" install_k8s_from_store
+    return 0
+  fi
+
+  wait_for_snap_bundle
   cd /opt/canonical-k8s
 
   with_retry "snapd install" install_snapd
@@ -123,9 +296,10 @@ install_all_snaps() {
 
 # -------- K8s helpers --------
 wait_for_k8s_ready() {
+  local attempt=1
   until k8s status --wait-ready; do
-    log "waiting for k8s status..."
-    sleep 10
+    retry_or_fail "waiting for k8s to be ready" "$attempt" "exit code $?"
+    attempt=$(( attempt + 1 ))
   done
   log "k8s is ready"
 }
@@ -135,8 +309,35 @@ hold_k8s_snap_refresh() {
   snap refresh k8s --hold
 }
 
-load_provider_environment() {
-  if [ -f "/run/provider-canonical/env" ]; then
-    . "/run/provider-canonical/env"
+# The provider environment and parameters may hold proxy credentials and the join
+# token: they are only loaded when owned by root, and with xtrace off so the
+# assignments don't end up in the traces.
+load_root_file() {
+  local file="$1"
+  local xtrace=""
+  [[ $- == *x* ]] && xtrace=1
+  { set +x; } 2>/dev/null
+
+  if [ -f "$file" ]; then
+    if [ "$(stat -c %u "$file")" = "0" ]; then
+      . "$file"
+    else
+      log "ignoring $file as it is not owned by root"
+    fi
   fi
+
+  [ -n "$xtrace" ] && set -x
+  return 0
+}
+
+load_provider_environment() {
+  load_root_file /run/provider-canonical/env
+}
+
+# The node parameters: NODE_ROLE, ADVERTISE_ADDRESS, CONFIG_HASH, METRICS_DIR,
+# SCRIPT_LOG_FILES, the RETRY_* policy, and RESTORE_BACKUP_PATH when the init node
+# restores its cluster from a backup.
+load_provider_params() {
+  NODE_ROLE="" ADVERTISE_ADDRESS="" CONFIG_HASH="" METRICS_DIR="" SCRIPT_LOG_FILES="" RESTORE_BACKUP_PATH=""
+  load_root_file /run/provider-canonical/params
 }
\ No newline at end of file
@@ -1,5 +1,6 @@
 #!/bin/bash
 
+source "$(dirname "$0")/common.sh"
 set -x
 
 load_provider_environment
@@ -1,5 +1,6 @@
 #!/bin/bash
 
+source "$(dirname "$0")/common.sh"
 set -x
 
 load_provider_environment
@@ -7,6 +8,10 @@ load_provider_environment
 node_role=$1
 node_name=$(cat /etc/hostname)
 
+# The reset starts the status of the node over; METRICS_DIR is set by the provider.
+rm -f /run/provider-canonical/status.json /opt/canonical/status.json
+start_phase reset
+
 if [ "$node_role" != "worker" ]; then
   k8s remove-node "$node_name"
 fi
@@ -19,6 +24,8 @@ snap remove k8s --purge
 snap list | awk '/^core[0-9]+/ {print $1}' | xargs -n1 snap remove --purge
 
 rm -rf /opt/canonical
+# the purge emptied the snap images dir, so the images must be imported again
+rm -f /var/lib/provider-canonical/imported-images.json
 rm -rf /opt/canonical-k8s
 rm -rf /opt/containerd
 rm -rf /opt/*init
@@ -29,3 +36,5 @@ rm -rf /etc/kubernetes/*
 rm -rf /var/log/provider-canonical.log
 rm -rf /var/log/canonical*.log
 rm -rf /var/log/pods
+
+finish_phase
@@ -0,0 +1,43 @@
+#!/bin/bash
+
+source "$(dirname "$0")/common.sh"
+
+load_provider_environment
+load_provider_params
+setup_logging restore /var/log/canonical-restore.log
+set -xu
+
+backup_archive=$RESTORE_BACKUP_PATH
+
+log "starting canonical k8s restore from $backup_archive"
+start_phase restore
+
+if [ ! -f "$backup_archive" ]; then
+	fail_phase "backup archive $backup_archive not found"
+fi
+
+install_all_snaps
+
+log "stopping k8s services before restoring"
+snap stop k8s
+
+if ! "$PROVIDER_BIN" restore --archive "$backup_archive"; then
+	fail_phase "failed to restore backup $backup_archive"
+fi
+
+# start k8sd and containerd, then every service the restored args describe
+log "starting restored k8s services"
+snap start --enable k8s.k8sd
+snap start --enable k8s.containerd
+for args_file in /var/snap/k8s/common/args/*; do
+	service="k8s.$(basename "$args_file")"
+	if snap services "$service" > /dev/null 2>&1; then
+		snap start --enable "$service"
+	fi
+done
+
+wait_for_k8s_ready
+hold_k8s_snap_refresh
+
+touch /opt/canonical/canonical.bootstrap
+finish_phase
@@ -1,30 +1,44 @@
 #!/bin/bash
 
 source "$(dirname "$0")/common.sh"
-setup_logging /var/log/canonical-upgrade.log
-set -xu
 
 load_provider_environment
+load_provider_params
+setup_logging upgrade /var/log/canonical-upgrade.log
+set -xu
 
 export KUBECONFIG=/etc/kubernetes/admin.conf
 current_node_name=$(cat /etc/hostname)
 
 # -------- inputs --------
-node_role=$1
+node_role=$NODE_ROLE
 
 log "starting canonical k8s upgrade"
 
 current_installed_revision=$(snap list k8s | grep k8s | awk '{print $3}')
 
-upcoming_revision=$(read_revision k8s)
-log "current installed k8s revision: $current_installed_revision"
-log "upcoming k8s revision to install: $upcoming_revision"
-if [ "$current_installed_revision" = "$upcoming_revision" ]; then
-	log "k8s is already up to date"
-	exit 0
-fi
+if snap_source_is_store; then
+	current_channel=$(snap list k8s | awk '$1 == "k8s" {print $4}')
+	upcoming_channel=${K8S_CHANNEL:-$current_channel}
+	log "current installed k8s revision: $current_installed_revision tracking $current_channel"
+	log "upcoming k8s channel: $upcoming_channel"
+	if [ "$current_channel" = "$upcoming_channel" ] && ! snap refresh --list 2>/dev/null | awk '{print $1}' | grep -qx k8s; then
+		log "k8s is already up to date"
+		exit 0
+	fi
 
-log "upgrading k8s from $current_installed_revision to $upcoming_revision"
+	log "upgrading k8s from $current_installed_revision to the latest revision of $upcoming_channel"
+else
+	upcoming_revision=$(read_revision k8s)
+	log "current installed k8s revision: $current_installed_revision"
+	log "upcoming k8s revision to install: $upcoming_revision"
+	if [ "$current_installed_revision" = "$upcoming_revision" ]; then
+		log "k8s is already up to date"
+		exit 0
+	fi
+
+	log "upgrading k8s from $current_installed_revision to $upcoming_revision"
+fi
 
 get_current_upgrading_node_name() {
 	kubectl get configmap upgrade-lock -n kube-system -o jsonpath="{['data']['node']}"
@@ -45,14 +59,19 @@ acquire_lock() {
 				log "resuming upgrade"
 				break
 			fi
+			[ "$PHASE" = "upgrade" ] || wait_phase upgrade
 			log "failed to create configmap for upgrade lock, upgrade in progress on node ${upgrade_node}; retrying in 60s..."
 			sleep 60
 		done
 	fi
 }
 
+# The wait on the upgrade lock is not bounded, it lasts as long as the upgrades of
+# the other nodes. A failed upgrade keeps the lock, which this node resumes with
+# on its next boot.
 do_upgrade() {
 	acquire_lock
+	start_phase upgrade
 
 	install_all_snaps
 
@@ -63,6 +82,7 @@ do_upgrade() {
 	hold_k8s_snap_refresh
 
 	delete_lock_config_map
+	finish_phase
 }
 
 do_upgrade